@@ -70,10 +70,28 @@ type OrgUnit struct {
 	OrgUnitGroup grp.Group
 }
 
+// `Members()` returns the members of the org unit group.
+func (ou OrgUnit) Members() []string {
+	return ou.OrgUnitGroup.Members
+}
+
+// `Members()` returns the members of the service group.
+func (s Service) Members() []string {
+	return s.ServiceGroup.Members
+}
+
+// `OpsMembers()` returns the members of the service ops group.
+func (s Service) OpsMembers() []string {
+	return s.ServiceOpsGroup.Members
+}
+
 // `DirWithPolicy` represents a filesystem directory with an access policy.
+// If `MemberDirs` is true, the directory contains a directory for each member
+// of the org unit group; see `OrgUnit.MemberDirs()`.
 type DirWithPolicy struct {
-	Name   string
-	Policy DirPolicy
+	Name       string
+	Policy     DirPolicy
+	MemberDirs bool `yaml:"memberdirs,omitempty"`
 }
 
 // `DirPolicy` enumerates directory access policies.  Its underlying type is
@@ -340,8 +358,9 @@ func parseOrgUnits(
 		for _, d := range cou.Subdirs {
 			checkName(d.Name)
 			sds = append(sds, DirWithPolicy{
-				Name:   d.Name,
-				Policy: MustDirPolicy(d.Policy),
+				Name:       d.Name,
+				Policy:     MustDirPolicy(d.Policy),
+				MemberDirs: d.MemberDirs,
 			})
 			xds = append(xds, d.Name)
 		}
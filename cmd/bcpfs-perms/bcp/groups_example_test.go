@@ -51,12 +51,12 @@ func ExampleGroupMap() {
 	fmt.Println(ok)
 
 	// Output:
-	// {org_alice 1} true
+	// {org_alice 1 []} true
 	// false
-	// {org_alice 1} true
+	// {org_alice 1 []} true
 	// false
-	// {srv_foo 3} true
+	// {srv_foo 3 []} true
 	// false
-	// {srv_em-ops 4} true
+	// {srv_em-ops 4 []} true
 	// false
 }
@@ -0,0 +1,50 @@
+package bcp
+
+import (
+	"os/user"
+	"sort"
+	"strconv"
+)
+
+// `MemberDir` is a per-member directory `<ou>/<subdir>/<User>`, which is
+// owned by `Uid`.
+type MemberDir struct {
+	User string
+	Uid  int
+}
+
+// `UidFunc` returns the uid of a user name.
+type UidFunc func(name string) (int, error)
+
+// `LookupUid()` is the default `UidFunc`, which uses the system user
+// database.
+func LookupUid(name string) (int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// `MemberDirs()` returns the per-member directories of the org unit members,
+// sorted by user name, using `lookup` to determine the owners.  Members whose
+// uid cannot be determined are returned in `unknown`.  A nil `lookup` uses
+// `LookupUid()`.
+func (ou OrgUnit) MemberDirs(
+	lookup UidFunc,
+) (dirs []MemberDir, unknown []string) {
+	if lookup == nil {
+		lookup = LookupUid
+	}
+	users := append([]string{}, ou.Members()...)
+	sort.Strings(users)
+	for _, u := range users {
+		uid, err := lookup(u)
+		if err != nil {
+			unknown = append(unknown, u)
+			continue
+		}
+		dirs = append(dirs, MemberDir{User: u, Uid: uid})
+	}
+	return dirs, unknown
+}
@@ -0,0 +1,35 @@
+package bcp_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+)
+
+func ExampleOrgUnit_MemberDirs() {
+	ou := bcp.OrgUnit{
+		Name: "ag-alice",
+		OrgUnitGroup: grp.Group{
+			Name:    "org_ag-alice",
+			Gid:     2001,
+			Members: []string{"bob", "alice", "ghost"},
+		},
+	}
+	uids := map[string]int{"alice": 1001, "bob": 1002}
+	lookup := func(name string) (int, error) {
+		uid, ok := uids[name]
+		if !ok {
+			return -1, fmt.Errorf("unknown user")
+		}
+		return uid, nil
+	}
+
+	dirs, unknown := ou.MemberDirs(lookup)
+	fmt.Printf("%+v\n", dirs)
+	fmt.Println(unknown)
+
+	// Output:
+	// [{User:alice Uid:1001} {User:bob Uid:1002}]
+	// [ghost]
+}
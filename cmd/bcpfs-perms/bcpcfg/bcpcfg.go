@@ -8,7 +8,8 @@
 // `cmd/bcpfs-perms/generic-example-bcpfs.hcl` contains an example file with
 // the available settings documented.
 //
-// Use `Load()` to load a config file.  Then use package `grp` to parse the
+// Use `Load()` to load a config file or a directory of config files; see
+// `ConfigFiles()`.  Then use package `grp` to parse the
 // available Unix groups and package `bcp` to combine the groups and the config
 // and obtain a struct that represents the organization.
 package bcpcfg
@@ -29,6 +30,7 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"regexp"
+	"time"
 
 	"github.com/hashicorp/hcl"
 	"github.com/hashicorp/hcl/hcl/ast"
@@ -51,8 +53,10 @@ type Root struct {
 	Facilities     []Facility   `hcl:"-"`
 	OrgUnits       []OrgUnit    `hcl:"-"`
 	Filter         []FilterRule `hcl:"-"`
+	DefaultAction  string       `hcl:"defaultAction" yaml:"defaultaction,omitempty"`
 	Symlinks       []Symlink    `hcl:"-"`
 	Sharing        *Sharing     `hcl:"-" yaml:",omitempty"`
+	Include        []string     `hcl:"include" yaml:"-"`
 }
 
 type Facility struct {
@@ -68,9 +72,13 @@ type OrgUnit struct {
 	ExtraDirs []string `hcl:"extraDirs"`
 }
 
+// `DirWithPolicy` is an org unit subdir.  If `MemberDirs` is true, a
+// directory is maintained for each member of the org unit group below the
+// subdir, which requires policy `owner`.
 type DirWithPolicy struct {
-	Name   string `hcl:"name"`
-	Policy string `hcl:"policy"`
+	Name       string `hcl:"name"`
+	Policy     string `hcl:"policy"`
+	MemberDirs bool   `hcl:"memberDirs" yaml:"memberdirs,omitempty"`
 }
 
 type Sharing struct {
@@ -105,17 +113,77 @@ func isValidDirPolicy(p string) bool {
 }
 
 type FilterRuleCfg struct {
-	Service  string   `hcl:"service"`
-	Services []string `hcl:"services"`
-	OrgUnit  string   `hcl:"orgUnit"`
-	OrgUnits []string `hcl:"orgUnits"`
-	Action   string   `hcl:"action"`
+	Service     string   `hcl:"service"`
+	Services    []string `hcl:"services"`
+	OrgUnit     string   `hcl:"orgUnit"`
+	OrgUnits    []string `hcl:"orgUnits"`
+	Facility    string   `hcl:"facility"`
+	Facilities  []string `hcl:"facilities"`
+	Access      string   `hcl:"access"`
+	OrgUnitKind string   `hcl:"orgUnitKind"`
+	Action      string   `hcl:"action"`
+	ValidFrom   string   `hcl:"validFrom"`
+	ValidUntil  string   `hcl:"validUntil"`
 }
 
+// `FilterRule` is a validated filter rule.  A rule matches if all of its
+// criteria match.  `Services` and `OrgUnits` are name regexes.  `Facilities`
+// are regexes for the name of the facility that owns the service.  `Access`
+// is the facility access policy, `perService` or `allOrgUnits`.
+// `OrgUnitKind` is `facility` or `lab`, where labs are all org units that are
+// not facilities.  Empty criteria match everything.
+//
+// `ValidFrom` and `ValidUntil` are optional dates `YYYY-MM-DD` in local time
+// that limit the rule to a time window; see `ValidWindow()`.
 type FilterRule struct {
-	Services []string
-	OrgUnits []string
-	Action   string
+	Services    []string
+	OrgUnits    []string
+	Action      string
+	Facilities  []string `yaml:"facilities,omitempty"`
+	Access      string   `yaml:"access,omitempty"`
+	OrgUnitKind string   `yaml:"orgunitkind,omitempty"`
+	ValidFrom   string   `yaml:"validfrom,omitempty"`
+	ValidUntil  string   `yaml:"validuntil,omitempty"`
+}
+
+// `DateLayout` is the format of filter rule dates.
+const DateLayout = "2006-01-02"
+
+// `ParseDate()` parses a date `YYYY-MM-DD` as the start of the day in local
+// time.
+func ParseDate(s string) (time.Time, error) {
+	return time.ParseInLocation(DateLayout, s, time.Local)
+}
+
+// `ValidWindow()` returns the time window of the rule.  The rule applies at
+// times `t` with `from <= t < until`.  `until` is the start of the day after
+// `ValidUntil`, so that `ValidUntil` is the last day on which the rule
+// applies.  Zero times indicate that the window is unbounded.
+func (r FilterRule) ValidWindow() (from, until time.Time, err error) {
+	if r.ValidFrom != "" {
+		from, err = ParseDate(r.ValidFrom)
+		if err != nil {
+			return from, until, fmt.Errorf(
+				"invalid `validFrom` `%s`", r.ValidFrom,
+			)
+		}
+	}
+	if r.ValidUntil != "" {
+		until, err = ParseDate(r.ValidUntil)
+		if err != nil {
+			return from, until, fmt.Errorf(
+				"invalid `validUntil` `%s`", r.ValidUntil,
+			)
+		}
+		until = until.AddDate(0, 0, 1)
+	}
+	return from, until, nil
+}
+
+// `HasRegexpOnly()` returns true if the rule uses only the classic
+// `Services` and `OrgUnits` criteria.
+func (r FilterRule) HasRegexpOnly() bool {
+	return len(r.Facilities) == 0 && r.Access == "" && r.OrgUnitKind == ""
 }
 
 type Symlink struct {
@@ -123,18 +191,42 @@ type Symlink struct {
 	Target string `hcl:"target"`
 }
 
-// `Load()` loads a config from `path`.
+// `Load()` loads a config from `path`, which can be a file or a directory.
+// The config may be split across several files; see `ConfigFiles()` and
+// `LoadFiles()`.  Files are parsed according to `FormatOfPath()` as HCL, YAML,
+// or JSON.
 func Load(path string) (*Root, error) {
-	d, err := ioutil.ReadFile(path)
+	files, err := ConfigFiles(path)
 	if err != nil {
 		return nil, err
 	}
-	return Parse(string(d))
+	if len(files) == 1 {
+		d, err := ioutil.ReadFile(files[0])
+		if err != nil {
+			return nil, err
+		}
+		return ParseFormat(string(d), FormatOfPath(files[0]))
+	}
+	return LoadFiles(files)
 }
 
 // `Parse()` parses the config from a string.  External users usually should
 // use `Load()`.
 func Parse(d string) (*Root, error) {
+	cfg, err := parseFragment(d)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRoot(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// `parseFragment()` parses an HCL config string without checking the
+// settings that must be specified once for the complete config, so that it
+// can be used for the parts of a config that is split across files.
+func parseFragment(d string) (*Root, error) {
 	root, err := hcl.Parse(d)
 	if err != nil {
 		return nil, err
@@ -194,14 +286,98 @@ func Parse(d string) (*Root, error) {
 		return nil, errors.New("More than one 'sharing' block.")
 	}
 
+	if err := validateFragment(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// `validateFragment()` checks the values of a config fragment after it has
+// been decoded from any format.  It normalizes the filter rules with
+// `ValidateFilterRule()`.
+func validateFragment(cfg *Root) error {
+	for _, f := range cfg.Facilities {
+		switch f.Access {
+		case "", "perService", "allOrgUnits":
+		default:
+			return fmt.Errorf(
+				"Failed to parse 'facilities': "+
+					"invalid Access `%s` in facility `%s`.",
+				f.Access, f.Name,
+			)
+		}
+	}
+
+	for i, ou := range cfg.OrgUnits {
+		if err := validateSubdirs(ou.Subdirs); err != nil {
+			return fmt.Errorf(
+				"Failed to parse 'orgUnits': "+
+					"invalid dirs in item %d: %s", i, err,
+			)
+		}
+	}
+
+	for i, r := range cfg.Filter {
+		rule, err := ValidateFilterRule(FilterRuleCfg{
+			Services:    r.Services,
+			OrgUnits:    r.OrgUnits,
+			Facilities:  r.Facilities,
+			Access:      r.Access,
+			OrgUnitKind: r.OrgUnitKind,
+			Action:      r.Action,
+			ValidFrom:   r.ValidFrom,
+			ValidUntil:  r.ValidUntil,
+		})
+		if err != nil {
+			return fmt.Errorf(
+				"Failed to parse 'filter': item %d: %s", i, err,
+			)
+		}
+		cfg.Filter[i] = rule
+	}
+
+	for i, link := range cfg.Symlinks {
+		if link.Path == "" {
+			return fmt.Errorf(
+				"Failed to parse 'symlink': "+
+					"empty `path` in item %d", i,
+			)
+		}
+		if link.Target == "" {
+			return fmt.Errorf(
+				"Failed to parse 'symlink': "+
+					"empty `target` in item %d", i,
+			)
+		}
+	}
+
+	if cfg.Sharing != nil {
+		if err := validateSharing(cfg.Sharing); err != nil {
+			return fmt.Errorf("Failed to parse 'sharing': %s", err)
+		}
+	}
+
+	switch cfg.DefaultAction {
+	case "", "accept", "reject":
+	default:
+		return fmt.Errorf(
+			"Invalid `defaultAction` `%s`", cfg.DefaultAction,
+		)
+	}
+
+	return nil
+}
+
+// `validateRoot()` checks the settings that must be specified for the
+// complete config.
+func validateRoot(cfg *Root) error {
 	if cfg.Rootdir == "" {
-		return nil, errors.New("Missing `rootdir`")
+		return errors.New("Missing `rootdir`")
 	}
 	if !filepath.IsAbs(cfg.Rootdir) {
-		return nil, errors.New("`rootdir` must be absolute")
+		return errors.New("`rootdir` must be absolute")
 	}
-
-	return &cfg, nil
+	return nil
 }
 
 func parseFacilityConfigs(cfg *Root, list *ast.ObjectList) error {
@@ -212,15 +388,6 @@ func parseFacilityConfigs(cfg *Root, list *ast.ObjectList) error {
 				"failed to parse item %d: %s", i, err,
 			)
 		}
-
-		if fs[i].Access != "perService" &&
-			fs[i].Access != "allOrgUnits" &&
-			fs[i].Access != "" {
-			return fmt.Errorf(
-				"invalid Access `%s` in facility `%s`.",
-				fs[i].Access, fs[i].Name,
-			)
-		}
 	}
 	cfg.Facilities = fs
 	return nil
@@ -229,21 +396,11 @@ func parseFacilityConfigs(cfg *Root, list *ast.ObjectList) error {
 func parseOrgUnitConfigs(cfg *Root, list *ast.ObjectList) error {
 	ous := make([]OrgUnit, len(list.Items))
 	for i, e := range list.Items {
-		var ou OrgUnit
-		if err := hcl.DecodeObject(&ou, e.Val); err != nil {
+		if err := hcl.DecodeObject(&ous[i], e.Val); err != nil {
 			return fmt.Errorf(
 				"failed to parse item %d: %s", i, err,
 			)
 		}
-
-		err := validateSubdirs(ou.Subdirs)
-		if err != nil {
-			return fmt.Errorf(
-				"invalid dirs in item %d: %s", i, err,
-			)
-		}
-
-		ous[i] = ou
 	}
 	cfg.OrgUnits = ous
 	return nil
@@ -254,10 +411,17 @@ func validateSubdirs(dirs []DirWithPolicy) error {
 		if !isValidDirPolicy(d.Policy) {
 			return fmt.Errorf("invalid policy in item %d", i)
 		}
+		if d.MemberDirs && d.Policy != "owner" {
+			return fmt.Errorf(
+				"memberDirs requires policy owner in item %d", i,
+			)
+		}
 	}
 	return nil
 }
 
+// `parseFilter()` uses `ValidateFilterRule()` to convert the HCL rules, which
+// may use the singular forms `service` and `orgUnit`.
 func parseFilter(cfg *Root, list *ast.ObjectList) error {
 	var filterRules []FilterRule
 	for i, e := range list.Items {
@@ -278,6 +442,9 @@ func parseFilter(cfg *Root, list *ast.ObjectList) error {
 	return nil
 }
 
+// `ValidateFilterRule()` checks a filter rule config.  Rules that use only
+// `service` and `orgUnit` must specify both.  Rules with `facility`,
+// `access`, or `orgUnitKind` may omit them.
 func ValidateFilterRule(r FilterRuleCfg) (rule FilterRule, err error) {
 
 	if (r.Action != "accept") && (r.Action != "reject") {
@@ -285,12 +452,40 @@ func ValidateFilterRule(r FilterRuleCfg) (rule FilterRule, err error) {
 	}
 	rule.Action = r.Action
 
+	if r.Facility != "" && len(r.Facilities) > 0 {
+		return rule, fmt.Errorf(
+			"Use either `facility` or `facilities`!",
+		)
+	}
+	if r.Facility == "" {
+		rule.Facilities = r.Facilities
+	} else {
+		rule.Facilities = append(rule.Facilities, r.Facility)
+	}
+
+	switch r.Access {
+	case "", "perService", "allOrgUnits":
+		rule.Access = r.Access
+	default:
+		return rule, fmt.Errorf("Invalid access `%s`!", r.Access)
+	}
+
+	switch r.OrgUnitKind {
+	case "", "facility", "lab":
+		rule.OrgUnitKind = r.OrgUnitKind
+	default:
+		return rule, fmt.Errorf(
+			"Invalid orgUnitKind `%s`!", r.OrgUnitKind,
+		)
+	}
+	regexpOnly := rule.HasRegexpOnly()
+
 	if (r.Service != "") && (len(r.Services) > 0) {
 		return rule, fmt.Errorf("Use either `service` or `services`!")
 	}
 
 	if r.Service == "" {
-		if len(r.Services) == 0 {
+		if len(r.Services) == 0 && regexpOnly {
 			return rule, fmt.Errorf("No service defined!")
 		}
 		rule.Services = r.Services
@@ -303,7 +498,7 @@ func ValidateFilterRule(r FilterRuleCfg) (rule FilterRule, err error) {
 	}
 
 	if r.OrgUnit == "" {
-		if len(r.OrgUnits) == 0 {
+		if len(r.OrgUnits) == 0 && regexpOnly {
 			return rule, fmt.Errorf("No orgUnit defined!")
 		}
 		rule.OrgUnits = r.OrgUnits
@@ -311,6 +506,27 @@ func ValidateFilterRule(r FilterRuleCfg) (rule FilterRule, err error) {
 		rule.OrgUnits = append(rule.OrgUnits, r.OrgUnit)
 	}
 
+	if r.ValidFrom != "" {
+		if _, err := ParseDate(r.ValidFrom); err != nil {
+			return rule, fmt.Errorf(
+				"Invalid validFrom `%s`!", r.ValidFrom,
+			)
+		}
+	}
+	if r.ValidUntil != "" {
+		if _, err := ParseDate(r.ValidUntil); err != nil {
+			return rule, fmt.Errorf(
+				"Invalid validUntil `%s`!", r.ValidUntil,
+			)
+		}
+	}
+	if r.ValidFrom != "" && r.ValidUntil != "" &&
+		r.ValidUntil < r.ValidFrom {
+		return rule, fmt.Errorf("validUntil before validFrom!")
+	}
+	rule.ValidFrom = r.ValidFrom
+	rule.ValidUntil = r.ValidUntil
+
 	return rule, nil
 }
 
@@ -323,13 +539,6 @@ func parseSymlinks(cfg *Root, list *ast.ObjectList) error {
 				"failed to parse item %d: %s", i, err,
 			)
 		}
-		if link.Path == "" {
-			return fmt.Errorf("empty `path` in item %d", i)
-		}
-		if link.Target == "" {
-			return fmt.Errorf("empty `target` in item %d", i)
-		}
-
 		links = append(links, link)
 	}
 	cfg.Symlinks = links
@@ -388,15 +597,6 @@ func parseSharingNamingPolicies(list *ast.ObjectList) (
 				"failed to parse item %d: %s", i, err,
 			)
 		}
-
-		if !isValidNamingPolicyAction(pol.Action) {
-			return nil, fmt.Errorf(
-				"failed to parse item %d: "+
-					"invalid naming policy action `%s`",
-				i, pol.Action,
-			)
-		}
-
 		pols = append(pols, pol)
 	}
 	return pols, nil
@@ -417,17 +617,6 @@ func parseSharingExports(list *ast.ObjectList) ([]SharingExport, error) {
 				"failed to parse item %d: %s", i, err,
 			)
 		}
-
-		for j, ace := range exp.Acl {
-			if !rgxSharingAce.MatchString(ace) {
-				return nil, fmt.Errorf(
-					"failed to parse item %d: "+
-						"malformed ACL entry %d",
-					i, j,
-				)
-			}
-		}
-
 		exps = append(exps, exp)
 	}
 	return exps, nil
@@ -442,18 +631,50 @@ func parseSharingImports(list *ast.ObjectList) ([]SharingImport, error) {
 				"failed to parse item %d: %s", i, err,
 			)
 		}
+		imps = append(imps, imp)
+	}
+	return imps, nil
+}
+
+// `validateSharing()` checks the naming policy actions, the export ACL entries,
+// and the import actions.
+func validateSharing(cfg *Sharing) error {
+	for i, pol := range cfg.NamingPolicies {
+		if !isValidNamingPolicyAction(pol.Action) {
+			return fmt.Errorf(
+				"Failed to parse 'sharing.namingPolicy': "+
+					"failed to parse item %d: "+
+					"invalid naming policy action `%s`",
+				i, pol.Action,
+			)
+		}
+	}
 
+	for i, exp := range cfg.Exports {
+		for j, ace := range exp.Acl {
+			if !rgxSharingAce.MatchString(ace) {
+				return fmt.Errorf(
+					"Failed to parse 'sharing.export': "+
+						"failed to parse item %d: "+
+						"malformed ACL entry %d",
+					i, j,
+				)
+			}
+		}
+	}
+
+	for i, imp := range cfg.Imports {
 		if !isValidImportAction(imp.Action) {
-			return nil, fmt.Errorf(
-				"failed to parse item %d: "+
+			return fmt.Errorf(
+				"Failed to parse 'sharing.imports': "+
+					"failed to parse item %d: "+
 					"invalid Import action `%s`",
 				i, imp.Action,
 			)
 		}
-
-		imps = append(imps, imp)
 	}
-	return imps, nil
+
+	return nil
 }
 
 func isValidImportAction(a string) bool {
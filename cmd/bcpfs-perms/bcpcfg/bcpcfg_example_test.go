@@ -69,8 +69,33 @@ func ExampleParseCfg() {
 	// /fsroot
 	// ag_org
 	// [{Name:microscopy Services:[m1] Access:perService}]
-	// [{Name:lab Subdirs:[{Name:people Policy:owner} {Name:service Policy:group} {Name:shared Policy:manager}] ExtraDirs:[projects]}]
-	// [{Services:[m1] OrgUnits:[lab1] Action:accept} {Services:[m1 m2] OrgUnits:[lab1 lab2] Action:accept}]
+	// [{Name:lab Subdirs:[{Name:people Policy:owner MemberDirs:false} {Name:service Policy:group MemberDirs:false} {Name:shared Policy:manager MemberDirs:false}] ExtraDirs:[projects]}]
+	// [{Services:[m1] OrgUnits:[lab1] Action:accept Facilities:[] Access: OrgUnitKind: ValidFrom: ValidUntil:} {Services:[m1 m2] OrgUnits:[lab1 lab2] Action:accept Facilities:[] Access: OrgUnitKind: ValidFrom: ValidUntil:}]
+}
+
+func ExampleParse_memberDirs() {
+	for _, subdir := range []string{
+		`{ name = "people", policy = "owner", memberDirs = true }`,
+		`{ name = "people", policy = "group", memberDirs = true }`,
+	} {
+		cfg, err := bcpcfg.Parse(`
+rootdir = "/fsroot"
+
+orgUnit {
+    name = "lab"
+    subdirs = [ ` + subdir + ` ]
+}
+`)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("%+v\n", cfg.OrgUnits[0].Subdirs)
+	}
+
+	// Output:
+	// [{Name:people Policy:owner MemberDirs:true}]
+	// Failed to parse 'orgUnits': invalid dirs in item 0: memberDirs requires policy owner in item 0
 }
 
 func ExampleValidateFilterRule() {
@@ -144,5 +169,71 @@ func ExampleValidateFilterRule() {
 	// Use either `service` or `services`!
 	// No orgUnit defined!
 	// Use either `orgUnit` or `orgUnits`!
-	// {[mic] [lab1 lab2] accept}
+	// {[mic] [lab1 lab2] accept []    }
+}
+
+func ExampleValidateFilterRule_facility() {
+	r, err := bcpcfg.ValidateFilterRule(bcpcfg.FilterRuleCfg{
+		Facility:    "em",
+		OrgUnitKind: "lab",
+		Action:      "accept",
+	})
+	fmt.Printf("%+v %v\n", r, err)
+
+	_, err = bcpcfg.ValidateFilterRule(bcpcfg.FilterRuleCfg{
+		Access: "allOrgUnit",
+		Action: "accept",
+	})
+	fmt.Println(err)
+
+	_, err = bcpcfg.ValidateFilterRule(bcpcfg.FilterRuleCfg{
+		OrgUnitKind: "group",
+		Action:      "accept",
+	})
+	fmt.Println(err)
+
+	// Output:
+	// {Services:[] OrgUnits:[] Action:accept Facilities:[em] Access: OrgUnitKind:lab ValidFrom: ValidUntil:} <nil>
+	// Invalid access `allOrgUnit`!
+	// Invalid orgUnitKind `group`!
+}
+
+func ExampleFilterRule_ValidWindow() {
+	r, err := bcpcfg.ValidateFilterRule(bcpcfg.FilterRuleCfg{
+		Service:    "micro",
+		OrgUnit:    "ag-alice",
+		Action:     "accept",
+		ValidFrom:  "2019-01-01",
+		ValidUntil: "2019-06-30",
+	})
+	fmt.Println(err)
+	from, until, err := r.ValidWindow()
+	fmt.Println(from.Format(bcpcfg.DateLayout))
+	fmt.Println(until.Format(bcpcfg.DateLayout))
+	fmt.Println(err)
+
+	_, err = bcpcfg.ValidateFilterRule(bcpcfg.FilterRuleCfg{
+		Service:    "micro",
+		OrgUnit:    "ag-alice",
+		Action:     "accept",
+		ValidUntil: "2019-06-31",
+	})
+	fmt.Println(err)
+
+	_, err = bcpcfg.ValidateFilterRule(bcpcfg.FilterRuleCfg{
+		Service:    "micro",
+		OrgUnit:    "ag-alice",
+		Action:     "accept",
+		ValidFrom:  "2019-07-01",
+		ValidUntil: "2019-06-30",
+	})
+	fmt.Println(err)
+
+	// Output:
+	// <nil>
+	// 2019-01-01
+	// 2019-07-01
+	// <nil>
+	// Invalid validUntil `2019-06-31`!
+	// validUntil before validFrom!
 }
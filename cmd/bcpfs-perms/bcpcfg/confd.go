@@ -0,0 +1,276 @@
+package bcpcfg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/hashicorp/hcl"
+)
+
+// `configExts` are the extensions of config files in a config directory.
+var configExts = []string{".hcl", ".yaml", ".yml", ".json"}
+
+// `ConfigFiles()` returns the config files for `path`.  If `path` is a
+// directory, like `/etc/bcpfs.d`, the files are the `*.hcl`, `*.yaml`,
+// `*.yml`, and `*.json` files in the directory sorted by name.  If `path` is
+// an HCL file, the files are `path` followed by the files that match the glob
+// patterns of its `include` setting, in the order of the patterns and sorted
+// by name for each pattern.  Relative patterns are relative to the directory
+// of `path`.  Files in a directory must not use `include`.
+func ConfigFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		var files []string
+		for _, ext := range configExts {
+			matches, err := filepath.Glob(
+				filepath.Join(path, "*"+ext),
+			)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no config files in `%s`", path)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			include, err := readInclude(f)
+			if err != nil {
+				return nil, err
+			}
+			if len(include) > 0 {
+				return nil, fmt.Errorf(
+					"`%s`: `include` is not supported "+
+						"in a config directory", f,
+				)
+			}
+		}
+		return files, nil
+	}
+
+	include, err := readInclude(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	seen := map[string]bool{filepath.Clean(path): true}
+	for _, pat := range include {
+		if !filepath.IsAbs(pat) {
+			pat = filepath.Join(filepath.Dir(path), pat)
+		}
+		matches, err := filepath.Glob(pat)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid `include` `%s`: %v", pat, err,
+			)
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			if seen[filepath.Clean(m)] {
+				continue
+			}
+			seen[filepath.Clean(m)] = true
+			files = append(files, m)
+		}
+	}
+	return files, nil
+}
+
+// `readInclude()` returns the `include` patterns of the HCL file `path`.  It
+// returns nil for other formats, which do not support `include`, and if the
+// file cannot be decoded; `Parse()` reports such errors.
+func readInclude(path string) ([]string, error) {
+	if FormatOfPath(path) != FormatHCL {
+		return nil, nil
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var inc struct {
+		Include []string `hcl:"include"`
+	}
+	if err := hcl.Decode(&inc, string(d)); err != nil {
+		return nil, nil
+	}
+	return inc.Include, nil
+}
+
+// `LoadFiles()` loads a config that is split across `files` and merges the
+// parts in order:
+//
+//  - Settings like `rootdir` may be specified in several files if the values
+//    are equal.
+//  - `facility`, `orgUnit`, `filter`, and `symlink` blocks and the `sharing`
+//    `namingPolicy`, `export`, and `import` blocks are concatenated in file
+//    order, so that the order of filter rules is deterministic.
+//  - Facilities, org units, symlink paths, and export paths must be unique,
+//    and a service must not be listed in more than one facility.
+//
+// Only the first file may use `include`.
+func LoadFiles(files []string) (*Root, error) {
+	m := newMerger()
+	for i, f := range files {
+		d, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		part, err := parseFormatFragment(string(d), FormatOfPath(f))
+		if err != nil {
+			return nil, fmt.Errorf("`%s`: %v", f, err)
+		}
+		if i > 0 && len(part.Include) > 0 {
+			return nil, fmt.Errorf(
+				"`%s`: `include` is only supported "+
+					"in the main config file", f,
+			)
+		}
+		if err := m.merge(part, f); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := &m.cfg
+	cfg.Include = nil
+	if err := validateRoot(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// `merger` combines config parts and remembers the origin files for conflict
+// messages.
+type merger struct {
+	cfg        Root
+	settings   map[string]string
+	facilities map[string]string
+	services   map[string]string
+	orgUnits   map[string]string
+	symlinks   map[string]string
+	exports    map[string]string
+}
+
+func newMerger() *merger {
+	return &merger{
+		settings:   make(map[string]string),
+		facilities: make(map[string]string),
+		services:   make(map[string]string),
+		orgUnits:   make(map[string]string),
+		symlinks:   make(map[string]string),
+		exports:    make(map[string]string),
+	}
+}
+
+func (m *merger) merge(part *Root, file string) error {
+	if err := m.mergeSettings(part, file); err != nil {
+		return err
+	}
+
+	for _, f := range part.Facilities {
+		if other, ok := m.facilities[f.Name]; ok {
+			return fmt.Errorf(
+				"duplicate facility `%s` in `%s` and `%s`",
+				f.Name, other, file,
+			)
+		}
+		m.facilities[f.Name] = file
+		for _, s := range f.Services {
+			if other, ok := m.services[s]; ok {
+				return fmt.Errorf(
+					"service `%s` in facility `%s` "+
+						"in `%s` is also listed "+
+						"in facility `%s`",
+					s, f.Name, file, other,
+				)
+			}
+			m.services[s] = f.Name
+		}
+		m.cfg.Facilities = append(m.cfg.Facilities, f)
+	}
+
+	for _, ou := range part.OrgUnits {
+		if other, ok := m.orgUnits[ou.Name]; ok {
+			return fmt.Errorf(
+				"duplicate org unit `%s` in `%s` and `%s`",
+				ou.Name, other, file,
+			)
+		}
+		m.orgUnits[ou.Name] = file
+		m.cfg.OrgUnits = append(m.cfg.OrgUnits, ou)
+	}
+
+	m.cfg.Filter = append(m.cfg.Filter, part.Filter...)
+
+	for _, link := range part.Symlinks {
+		if other, ok := m.symlinks[link.Path]; ok {
+			return fmt.Errorf(
+				"duplicate symlink `%s` in `%s` and `%s`",
+				link.Path, other, file,
+			)
+		}
+		m.symlinks[link.Path] = file
+		m.cfg.Symlinks = append(m.cfg.Symlinks, link)
+	}
+
+	if part.Sharing == nil {
+		return nil
+	}
+	if m.cfg.Sharing == nil {
+		m.cfg.Sharing = &Sharing{}
+	}
+	sharing := m.cfg.Sharing
+	sharing.NamingPolicies = append(
+		sharing.NamingPolicies, part.Sharing.NamingPolicies...,
+	)
+	for _, exp := range part.Sharing.Exports {
+		if other, ok := m.exports[exp.Path]; ok {
+			return fmt.Errorf(
+				"duplicate sharing export `%s` "+
+					"in `%s` and `%s`",
+				exp.Path, other, file,
+			)
+		}
+		m.exports[exp.Path] = file
+		sharing.Exports = append(sharing.Exports, exp)
+	}
+	sharing.Imports = append(sharing.Imports, part.Sharing.Imports...)
+	return nil
+}
+
+// `mergeSettings()` merges the string settings of `Root`, like `rootdir`.
+// A setting may be specified in several files only with the same value.
+func (m *merger) mergeSettings(part *Root, file string) error {
+	dst := reflect.ValueOf(&m.cfg).Elem()
+	src := reflect.ValueOf(part).Elem()
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() != reflect.String {
+			continue
+		}
+		name := t.Field(i).Tag.Get("hcl")
+		val := src.Field(i).String()
+		if val == "" {
+			continue
+		}
+		if cur := dst.Field(i).String(); cur != "" && cur != val {
+			return fmt.Errorf(
+				"conflicting `%s` `%s` in `%s` "+
+					"and `%s` in `%s`",
+				name, cur, m.settings[name], val, file,
+			)
+		}
+		dst.Field(i).SetString(val)
+		if _, ok := m.settings[name]; !ok {
+			m.settings[name] = file
+		}
+	}
+	return nil
+}
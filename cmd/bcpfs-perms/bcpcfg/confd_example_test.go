@@ -0,0 +1,125 @@
+package bcpcfg_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+)
+
+func ExampleLoad_directory() {
+	dir, err := ioutil.TempDir("", "bcpcfg")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	write("00-main.hcl", `
+rootdir = "/fsroot"
+serviceDir = "srv"
+`)
+	write("10-em.hcl", `
+serviceDir = "srv"
+facility { name = "em", services = ["tem"] }
+filter { service = "tem", orgUnit = "ag-alice", action = "accept" }
+`)
+	write("20-lm.hcl", `
+facility { name = "lm", services = ["spim"] }
+filter { service = "spim", orgUnit = "ag-bob", action = "accept" }
+`)
+
+	cfg, err := bcpcfg.Load(dir)
+	fmt.Println(err)
+	fmt.Println(cfg.Rootdir, cfg.ServiceDir)
+	fmt.Printf("%+v\n", cfg.Facilities)
+	for _, r := range cfg.Filter {
+		fmt.Println(r.Services, r.OrgUnits)
+	}
+
+	write("30-conflict.hcl", `
+serviceDir = "services"
+`)
+	_, err = bcpcfg.Load(dir)
+	fmt.Println(err != nil)
+	if err := os.Remove(filepath.Join(dir, "30-conflict.hcl")); err != nil {
+		panic(err)
+	}
+
+	// `include` is rejected in every file of a directory.
+	write("00-main.hcl", `
+rootdir = "/fsroot"
+serviceDir = "srv"
+include = ["more/*.hcl"]
+`)
+	_, err = bcpcfg.Load(dir)
+	fmt.Println(strings.Replace(err.Error(), dir, "<dir>", -1))
+
+	// Output:
+	// <nil>
+	// /fsroot srv
+	// [{Name:em Services:[tem] Access:} {Name:lm Services:[spim] Access:}]
+	// [tem] [ag-alice]
+	// [spim] [ag-bob]
+	// true
+	// `<dir>/00-main.hcl`: `include` is not supported in a config directory
+}
+
+func ExampleConfigFiles() {
+	dir, err := ioutil.TempDir("", "bcpcfg")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "bcpfs.d"), 0755); err != nil {
+		panic(err)
+	}
+	files := map[string]string{
+		"bcpfs.hcl": `
+rootdir = "/fsroot"
+include = ["bcpfs.d/*.hcl"]
+`,
+		"bcpfs.d/b.hcl": `
+facility { name = "lm", services = ["spim"] }
+`,
+		"bcpfs.d/a.hcl": `
+facility { name = "em", services = ["tem"] }
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	main := filepath.Join(dir, "bcpfs.hcl")
+	paths, err := bcpcfg.ConfigFiles(main)
+	fmt.Println(err)
+	for _, p := range paths {
+		rel, _ := filepath.Rel(dir, p)
+		fmt.Println(rel)
+	}
+
+	cfg, err := bcpcfg.Load(main)
+	fmt.Println(err)
+	fmt.Printf("%+v\n", cfg.Facilities)
+
+	// Output:
+	// <nil>
+	// bcpfs.hcl
+	// bcpfs.d/a.hcl
+	// bcpfs.d/b.hcl
+	// <nil>
+	// [{Name:em Services:[tem] Access:} {Name:lm Services:[spim] Access:}]
+}
@@ -0,0 +1,623 @@
+package bcpcfg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/parser"
+	"github.com/hashicorp/hcl/hcl/token"
+)
+
+// Severities of lint `Problem`s.  Errors make the config invalid.  Warnings
+// indicate settings that are probably unintended.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// `Problem` is a lint finding at a position in a config file.
+type Problem struct {
+	Filename string
+	Line     int
+	Column   int
+	Severity string
+	Message  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf(
+		"%s:%d:%d: %s: %s",
+		p.Filename, p.Line, p.Column, p.Severity, p.Message,
+	)
+}
+
+// `HasErrors()` returns true if any problem is an error.
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// `Lint()` checks the config `d` from file `filename` and returns the problems
+// sorted by position.  In addition to the checks of `Parse()`, it reports
+// unknown fields, regexes that do not compile, duplicate facilities, services
+// that are listed in more than one facility, and filter rules that are
+// shadowed by earlier rules.  If `orgUnits` is non-nil, it also reports
+// sharing ACL entries and imports whose groups are not in `orgUnits`.
+//
+// YAML and JSON files, see `FormatOfPath()`, are only checked for the errors
+// of `ParseFormat()`, which are reported at the start of the file.
+func Lint(filename string, d string, orgUnits []string) []Problem {
+	return lint(filename, d, orgUnits, false)
+}
+
+// `LintFiles()` checks a config that is split across `files`; see
+// `LoadFiles()`.  Each file is checked like with `Lint()`, except that
+// settings like `rootdir` may be specified in another file.  If there are no
+// errors, it reports the problems of merging the files.
+func LintFiles(files []string, orgUnits []string) ([]Problem, error) {
+	if len(files) == 1 {
+		d, err := ioutil.ReadFile(files[0])
+		if err != nil {
+			return nil, err
+		}
+		return Lint(files[0], string(d), orgUnits), nil
+	}
+
+	var problems []Problem
+	for _, f := range files {
+		d, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(
+			problems, lint(f, string(d), orgUnits, true)...,
+		)
+	}
+	if HasErrors(problems) {
+		return problems, nil
+	}
+	if _, err := LoadFiles(files); err != nil {
+		problems = append(problems, Problem{
+			Filename: files[0],
+			Line:     1,
+			Column:   1,
+			Severity: SeverityError,
+			Message:  err.Error(),
+		})
+	}
+	return problems, nil
+}
+
+// `lint()` implements `Lint()`.  If `fragment` is true, `d` is a part of a
+// config that is split across files.
+func lint(
+	filename string, d string, orgUnits []string, fragment bool,
+) []Problem {
+	l := linter{filename: filename, fragment: fragment}
+	if format := FormatOfPath(filename); format != FormatHCL {
+		parse := ParseFormat
+		if fragment {
+			parse = parseFormatFragment
+		}
+		if _, err := parse(d, format); err != nil {
+			l.errorf(token.Pos{Line: 1, Column: 1}, "%v", err)
+		}
+		return l.problems
+	}
+
+	root, err := hcl.Parse(d)
+	if err != nil {
+		if pe, ok := err.(*parser.PosError); ok {
+			l.errorf(pe.Pos, "%v", pe.Err)
+		} else {
+			l.errorf(token.Pos{Line: 1, Column: 1}, "%v", err)
+		}
+		return l.problems
+	}
+	list, ok := root.Node.(*ast.ObjectList)
+	if !ok {
+		l.errorf(root.Pos(), "missing config root object")
+		return l.problems
+	}
+
+	l.checkKeys(list, "", rootKeys)
+	l.lintRoot(root, list)
+	l.lintFacilities(itemsWithKey(list, "facility"))
+	l.lintOrgUnits(itemsWithKey(list, "orgUnit"))
+	l.lintFilters(itemsWithKey(list, "filter"))
+	l.lintSymlinks(itemsWithKey(list, "symlink"))
+	l.lintSharing(itemsWithKey(list, "sharing"), orgUnits)
+
+	// `Lint()` should never accept a config that `Parse()` rejects.
+	parse := Parse
+	if fragment {
+		parse = parseFragment
+	}
+	if !HasErrors(l.problems) {
+		if _, err := parse(d); err != nil {
+			l.errorf(token.Pos{Line: 1, Column: 1}, "%v", err)
+		}
+	}
+
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		a, b := l.problems[i], l.problems[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.problems
+}
+
+type linter struct {
+	filename string
+	fragment bool
+	problems []Problem
+}
+
+func (l *linter) add(
+	pos token.Pos, severity, format string, a ...interface{},
+) {
+	l.problems = append(l.problems, Problem{
+		Filename: l.filename,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+func (l *linter) errorf(pos token.Pos, format string, a ...interface{}) {
+	l.add(pos, SeverityError, format, a...)
+}
+
+func (l *linter) warnf(pos token.Pos, format string, a ...interface{}) {
+	l.add(pos, SeverityWarning, format, a...)
+}
+
+// `hclKeys()` returns the HCL keys of the struct `v`, which are the `hcl`
+// tags that are not `-`, together with the `blocks` that are decoded
+// explicitly.
+func hclKeys(v interface{}, blocks ...string) map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("hcl"), ",")[0]
+		if tag != "" && tag != "-" {
+			keys[tag] = true
+		}
+	}
+	for _, b := range blocks {
+		keys[b] = true
+	}
+	return keys
+}
+
+var (
+	rootKeys = hclKeys(
+		Root{}, "facility", "orgUnit", "filter", "symlink", "sharing",
+	)
+	facilityKeys = hclKeys(Facility{})
+	orgUnitKeys  = hclKeys(OrgUnit{})
+	subdirKeys   = hclKeys(DirWithPolicy{})
+	filterKeys   = hclKeys(FilterRuleCfg{})
+	symlinkKeys  = hclKeys(Symlink{})
+	sharingKeys  = hclKeys(
+		Sharing{}, "namingPolicy", "export", "import",
+	)
+	namingPolicyKeys  = hclKeys(SharingNamingPolicy{})
+	sharingExportKeys = hclKeys(SharingExport{})
+	sharingImportKeys = hclKeys(SharingImport{})
+)
+
+// `itemsWithKey()` returns the items of `list` with key `key`.  Unlike
+// `ast.ObjectList.Filter()`, it keeps the keys, so that the item positions
+// are available.
+func itemsWithKey(list *ast.ObjectList, key string) []*ast.ObjectItem {
+	var items []*ast.ObjectItem
+	for _, item := range list.Items {
+		if keyName(item) == key {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func keyName(item *ast.ObjectItem) string {
+	if len(item.Keys) == 0 {
+		return ""
+	}
+	return strings.Trim(item.Keys[0].Token.Text, `"`)
+}
+
+// `checkKeys()` warns about unknown keys in `list`.  `block` is the name of
+// the enclosing block for messages.
+func (l *linter) checkKeys(
+	list *ast.ObjectList, block string, known map[string]bool,
+) {
+	for _, item := range list.Items {
+		k := keyName(item)
+		if known[k] {
+			continue
+		}
+		if block == "" {
+			l.warnf(item.Pos(), "unknown field `%s`", k)
+		} else {
+			l.warnf(
+				item.Pos(), "unknown field `%s` in `%s`",
+				k, block,
+			)
+		}
+	}
+}
+
+// `objectList()` returns the fields of a block or reports an error.
+func (l *linter) objectList(item *ast.ObjectItem) (*ast.ObjectList, bool) {
+	obj, ok := item.Val.(*ast.ObjectType)
+	if !ok {
+		l.errorf(item.Pos(), "`%s` must be a block", keyName(item))
+		return nil, false
+	}
+	return obj.List, true
+}
+
+// `decode()` decodes a block or reports an error.
+func (l *linter) decode(out interface{}, item *ast.ObjectItem) bool {
+	if err := hcl.DecodeObject(out, item.Val); err != nil {
+		l.errorf(item.Pos(), "invalid `%s`: %v", keyName(item), err)
+		return false
+	}
+	return true
+}
+
+// `checkRegexp()` reports an error and returns false if `pattern` does not
+// compile.
+func (l *linter) checkRegexp(pos token.Pos, what string, pattern string) bool {
+	if _, err := regexp.Compile("^(" + pattern + ")$"); err != nil {
+		l.errorf(pos, "invalid %s regex `%s`: %v", what, pattern, err)
+		return false
+	}
+	return true
+}
+
+func (l *linter) lintRoot(root *ast.File, list *ast.ObjectList) {
+	var cfg Root
+	if err := hcl.DecodeObject(&cfg, root); err != nil {
+		l.errorf(root.Pos(), "%v", err)
+		return
+	}
+
+	posOf := func(key string) token.Pos {
+		if items := itemsWithKey(list, key); len(items) > 0 {
+			return items[0].Pos()
+		}
+		return token.Pos{Line: 1, Column: 1}
+	}
+
+	switch {
+	case cfg.Rootdir == "" && l.fragment:
+	case cfg.Rootdir == "":
+		l.errorf(posOf("rootdir"), "missing `rootdir`")
+	case !strings.HasPrefix(cfg.Rootdir, "/"):
+		l.errorf(posOf("rootdir"), "`rootdir` must be absolute")
+	}
+	switch cfg.DefaultAction {
+	case "", "accept", "reject":
+	default:
+		l.errorf(
+			posOf("defaultAction"),
+			"invalid `defaultAction` `%s`", cfg.DefaultAction,
+		)
+	}
+}
+
+func (l *linter) lintFacilities(items []*ast.ObjectItem) {
+	facilityPos := make(map[string]token.Pos)
+	serviceFacility := make(map[string]string)
+	for _, item := range items {
+		fields, ok := l.objectList(item)
+		if !ok {
+			continue
+		}
+		l.checkKeys(fields, "facility", facilityKeys)
+		var f Facility
+		if !l.decode(&f, item) {
+			continue
+		}
+
+		switch f.Access {
+		case "", "perService", "allOrgUnits":
+		default:
+			l.errorf(
+				item.Pos(),
+				"invalid access `%s` in facility `%s`",
+				f.Access, f.Name,
+			)
+		}
+
+		if pos, ok := facilityPos[f.Name]; ok {
+			l.warnf(
+				item.Pos(),
+				"duplicate facility `%s`, "+
+					"also defined at line %d",
+				f.Name, pos.Line,
+			)
+		} else {
+			facilityPos[f.Name] = item.Pos()
+		}
+
+		for _, s := range f.Services {
+			other, ok := serviceFacility[s]
+			if ok && other != f.Name {
+				l.warnf(
+					item.Pos(),
+					"service `%s` of facility `%s` "+
+						"is also listed in "+
+						"facility `%s`",
+					s, f.Name, other,
+				)
+				continue
+			}
+			serviceFacility[s] = f.Name
+		}
+	}
+}
+
+func (l *linter) lintOrgUnits(items []*ast.ObjectItem) {
+	for _, item := range items {
+		fields, ok := l.objectList(item)
+		if !ok {
+			continue
+		}
+		l.checkKeys(fields, "orgUnit", orgUnitKeys)
+		for _, sub := range itemsWithKey(fields, "subdirs") {
+			lst, ok := sub.Val.(*ast.ListType)
+			if !ok {
+				continue
+			}
+			for _, n := range lst.List {
+				if obj, ok := n.(*ast.ObjectType); ok {
+					l.checkKeys(
+						obj.List, "subdirs", subdirKeys,
+					)
+				}
+			}
+		}
+
+		var ou OrgUnit
+		if !l.decode(&ou, item) {
+			continue
+		}
+		if err := validateSubdirs(ou.Subdirs); err != nil {
+			l.errorf(
+				item.Pos(),
+				"invalid subdirs in org unit `%s`: %v",
+				ou.Name, err,
+			)
+		}
+	}
+}
+
+type lintFilterRule struct {
+	pos  token.Pos
+	rule FilterRule
+}
+
+func (l *linter) lintFilters(items []*ast.ObjectItem) {
+	var rules []lintFilterRule
+	for _, item := range items {
+		fields, ok := l.objectList(item)
+		if !ok {
+			continue
+		}
+		l.checkKeys(fields, "filter", filterKeys)
+		var cfg FilterRuleCfg
+		if !l.decode(&cfg, item) {
+			continue
+		}
+		rule, err := ValidateFilterRule(cfg)
+		if err != nil {
+			l.errorf(
+				item.Pos(), "invalid filter rule: %s",
+				strings.TrimSuffix(err.Error(), "!"),
+			)
+			continue
+		}
+
+		valid := true
+		check := func(what string, patterns []string) {
+			for _, p := range patterns {
+				if !l.checkRegexp(item.Pos(), what, p) {
+					valid = false
+				}
+			}
+		}
+		check("service", rule.Services)
+		check("orgUnit", rule.OrgUnits)
+		check("facility", rule.Facilities)
+		if !valid {
+			continue
+		}
+
+		for _, earlier := range rules {
+			if filterRuleCovers(earlier.rule, rule) {
+				l.warnf(
+					item.Pos(),
+					"unreachable filter rule, "+
+						"shadowed by the rule "+
+						"at line %d",
+					earlier.pos.Line,
+				)
+				break
+			}
+		}
+		rules = append(rules, lintFilterRule{
+			pos: item.Pos(), rule: rule,
+		})
+	}
+}
+
+// `filterRuleCovers()` returns true if rule `a` matches at least all
+// combinations that rule `b` matches, so that `b` is never reached if `a` is
+// tested first.  It compares patterns literally; `.*` matches everything.
+func filterRuleCovers(a, b FilterRule) bool {
+	if a.ValidFrom != "" || a.ValidUntil != "" {
+		return false
+	}
+	matchesAll := func(ps []string) bool {
+		if len(ps) == 0 {
+			return true
+		}
+		for _, p := range ps {
+			if p == ".*" {
+				return true
+			}
+		}
+		return false
+	}
+	covers := func(a, b []string) bool {
+		if matchesAll(a) {
+			return true
+		}
+		if matchesAll(b) {
+			return false
+		}
+		set := make(map[string]bool)
+		for _, p := range a {
+			set[p] = true
+		}
+		for _, p := range b {
+			if !set[p] {
+				return false
+			}
+		}
+		return true
+	}
+	return covers(a.Services, b.Services) &&
+		covers(a.OrgUnits, b.OrgUnits) &&
+		covers(a.Facilities, b.Facilities) &&
+		(a.Access == "" || a.Access == b.Access) &&
+		(a.OrgUnitKind == "" || a.OrgUnitKind == b.OrgUnitKind)
+}
+
+func (l *linter) lintSymlinks(items []*ast.ObjectItem) {
+	for _, item := range items {
+		fields, ok := l.objectList(item)
+		if !ok {
+			continue
+		}
+		l.checkKeys(fields, "symlink", symlinkKeys)
+		var link Symlink
+		if !l.decode(&link, item) {
+			continue
+		}
+		if link.Path == "" {
+			l.errorf(item.Pos(), "empty `path` in symlink")
+		}
+		if link.Target == "" {
+			l.errorf(item.Pos(), "empty `target` in symlink")
+		}
+	}
+}
+
+func (l *linter) lintSharing(
+	items []*ast.ObjectItem, orgUnits []string,
+) {
+	if len(items) > 1 {
+		for _, item := range items[1:] {
+			l.errorf(item.Pos(), "more than one `sharing` block")
+		}
+	}
+
+	var known map[string]bool
+	if orgUnits != nil {
+		known = make(map[string]bool)
+		for _, ou := range orgUnits {
+			known[ou] = true
+		}
+	}
+	checkGroup := func(pos token.Pos, what, group string) {
+		if known != nil && !known[group] {
+			l.errorf(
+				pos, "unknown org unit `%s` in %s",
+				group, what,
+			)
+		}
+	}
+
+	for _, item := range items {
+		fields, ok := l.objectList(item)
+		if !ok {
+			continue
+		}
+		l.checkKeys(fields, "sharing", sharingKeys)
+
+		for _, e := range itemsWithKey(fields, "namingPolicy") {
+			if f, ok := l.objectList(e); ok {
+				l.checkKeys(f, "namingPolicy", namingPolicyKeys)
+			}
+			var pol SharingNamingPolicy
+			if !l.decode(&pol, e) {
+				continue
+			}
+			if !isValidNamingPolicyAction(pol.Action) {
+				l.errorf(
+					e.Pos(),
+					"invalid naming policy action `%s`",
+					pol.Action,
+				)
+			}
+			l.checkRegexp(e.Pos(), "namingPolicy match", pol.Match)
+		}
+
+		for _, e := range itemsWithKey(fields, "export") {
+			if f, ok := l.objectList(e); ok {
+				l.checkKeys(f, "export", sharingExportKeys)
+			}
+			var exp SharingExport
+			if !l.decode(&exp, e) {
+				continue
+			}
+			for _, ace := range exp.Acl {
+				if !rgxSharingAce.MatchString(ace) {
+					l.errorf(
+						e.Pos(),
+						"malformed ACL entry `%s`",
+						ace,
+					)
+					continue
+				}
+				group := strings.Split(ace, ":")[1]
+				checkGroup(e.Pos(), "export ACL", group)
+			}
+		}
+
+		for _, e := range itemsWithKey(fields, "import") {
+			if f, ok := l.objectList(e); ok {
+				l.checkKeys(f, "import", sharingImportKeys)
+			}
+			var imp SharingImport
+			if !l.decode(&imp, e) {
+				continue
+			}
+			if !isValidImportAction(imp.Action) {
+				l.errorf(
+					e.Pos(), "invalid import action `%s`",
+					imp.Action,
+				)
+			}
+			l.checkRegexp(e.Pos(), "import match", imp.Match)
+			checkGroup(e.Pos(), "import", imp.Group)
+		}
+	}
+}
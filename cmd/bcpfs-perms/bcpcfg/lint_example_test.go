@@ -0,0 +1,70 @@
+package bcpcfg_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+)
+
+func ExampleLint() {
+	cfg := `
+rootdir = "/fsroot"
+serviceDir = "srv"
+orgUnitDir = "org"
+orgUnitPrefix = "ag"
+servicePrefix = "srv"
+superGroup = "ag_org"
+defaultActoin = "accept"
+
+facility {
+    name = "em"
+    services = ["tem", "sem"]
+}
+
+facility {
+    name = "lm"
+    services = ["spim", "sem"]
+}
+
+filter {
+    services = ["tem", "sem"]
+    orgUnit = "ag-alice"
+    action = "accept"
+}
+
+filter {
+    service = "tem"
+    orgUnit = "ag-alice"
+    action = "reject"
+}
+
+filter {
+    service = "tem("
+    orgUnit = "ag-bob"
+    action = "reject"
+}
+
+sharing {
+    import { action = "accept", group = "ag-charly", match = "ag-bob/.*" }
+}
+`
+	problems := bcpcfg.Lint("bcpfs.hcl", cfg, []string{"ag-alice", "ag-bob"})
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	fmt.Println(bcpcfg.HasErrors(problems))
+
+	problems = bcpcfg.Lint("bcpfs.hcl", `rootdir = "/fsroot`, nil)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	// Output:
+	// bcpfs.hcl:8:1: warning: unknown field `defaultActoin`
+	// bcpfs.hcl:15:1: warning: service `sem` of facility `lm` is also listed in facility `em`
+	// bcpfs.hcl:26:1: warning: unreachable filter rule, shadowed by the rule at line 20
+	// bcpfs.hcl:32:1: error: invalid service regex `tem(`: error parsing regexp: missing closing ): `^(tem()$`
+	// bcpfs.hcl:39:5: error: unknown org unit `ag-charly` in import
+	// true
+	// bcpfs.hcl:1:19: error: literal not terminated
+}
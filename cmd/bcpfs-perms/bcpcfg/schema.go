@@ -0,0 +1,152 @@
+package bcpcfg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// `SchemaURI` is the JSON Schema draft of `Schema()`.
+const SchemaURI = "http://json-schema.org/draft-07/schema#"
+
+// `schemaConstraints` restricts the values of the fields `<Type>.<Field>`
+// beyond their Go types.  The enums must be kept in sync with the checks of
+// `Parse()`.
+var schemaConstraints = map[string]map[string]interface{}{
+	"Root.DefaultAction": {
+		"enum": []string{"accept", "reject"},
+	},
+	"Facility.Access": {
+		"enum": []string{"", "perService", "allOrgUnits"},
+	},
+	"DirWithPolicy.Policy": {
+		"enum": []string{"owner", "group", "manager"},
+	},
+	"FilterRule.Action": {
+		"enum": []string{"accept", "reject"},
+	},
+	"FilterRule.Access": {
+		"enum": []string{"perService", "allOrgUnits"},
+	},
+	"FilterRule.OrgUnitKind": {
+		"enum": []string{"facility", "lab"},
+	},
+	"FilterRule.ValidFrom": {
+		"pattern": "^[0-9]{4}-[0-9]{2}-[0-9]{2}$",
+	},
+	"FilterRule.ValidUntil": {
+		"pattern": "^[0-9]{4}-[0-9]{2}-[0-9]{2}$",
+	},
+	"SharingNamingPolicy.Action": {
+		"enum": []string{"allow", "deny"},
+	},
+	"SharingExport.Acl": {
+		"items": map[string]interface{}{
+			"type":    "string",
+			"pattern": rgxSharingAce.String(),
+		},
+	},
+	"SharingImport.Action": {
+		"enum": []string{"accept", "reject"},
+	},
+}
+
+// `schemaRequired` lists the fields `<Type>.<Field>` that must be present.
+// `rootdir` is not required, so that the schema can be used for the parts of
+// a config that is split across files.
+var schemaRequired = map[string]bool{
+	"Facility.Name":              true,
+	"OrgUnit.Name":               true,
+	"DirWithPolicy.Name":         true,
+	"DirWithPolicy.Policy":       true,
+	"FilterRule.Action":          true,
+	"Symlink.Path":               true,
+	"Symlink.Target":             true,
+	"SharingNamingPolicy.Action": true,
+	"SharingNamingPolicy.Match":  true,
+	"SharingExport.Path":         true,
+	"SharingExport.Acl":          true,
+	"SharingImport.Action":       true,
+	"SharingImport.Group":        true,
+	"SharingImport.Match":        true,
+}
+
+// `Schema()` returns a JSON Schema for YAML and JSON config files, see
+// `ParseYAML()` and `ParseJSON()`, which accept exactly the keys of the
+// schema.  It does not apply to HCL.  The schema is generated from the fields
+// of `Root` and the structs that it contains.  Filter rules use the list
+// fields of `FilterRule`, like `services` and `orgunits`, which
+// `ValidateFilterRule()` derives from `FilterRuleCfg`.  The result can be
+// encoded with `encoding/json`.
+func Schema() map[string]interface{} {
+	s := objectSchema(reflect.TypeOf(Root{}))
+	s["$schema"] = SchemaURI
+	s["title"] = "bcpfs-perms config"
+	return s
+}
+
+func objectSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := yamlFieldName(f)
+		if name == "" {
+			continue
+		}
+		key := t.Name() + "." + f.Name
+		p := typeSchema(f.Type)
+		for k, v := range schemaConstraints[key] {
+			p[k] = v
+		}
+		props[name] = p
+		if schemaRequired[key] {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		return objectSchema(t)
+	default:
+		panic(fmt.Sprintf("unsupported config field type `%s`", t))
+	}
+}
+
+// `yamlFieldName()` returns the YAML key of a struct field, which is the
+// name from the `yaml` tag or the lowercase field name, like in
+// `gopkg.in/yaml.v2`.  It returns the empty string for ignored fields.
+func yamlFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(f.Name)
+	default:
+		return name
+	}
+}
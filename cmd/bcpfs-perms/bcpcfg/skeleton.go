@@ -0,0 +1,212 @@
+package bcpcfg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const skeletonHeader = "" +
+	"# Generated by `bcpfs-perms init` from the Unix groups.  " +
+	"Lines marked\n" +
+	"# with `REVIEW` are guesses that must be checked " +
+	"before the config is used.\n" +
+	"# See `generic-example-bcpfs.hcl` " +
+	"for a description of the settings.\n" +
+	"\n"
+
+const skeletonSuperGroup = "\n" +
+	"# REVIEW: `superGroup` is the Unix group " +
+	"that contains all members of all\n" +
+	"# org units.  It is required for facilities " +
+	"with `access = \"allOrgUnits\"`.\n" +
+	"# superGroup = \"\"\n"
+
+const skeletonMissingGroup = "\n" +
+	"# REVIEW: Facility `%s` lacks the Unix group `%s`.\n" +
+	"# Create the group or remove the facility.\n"
+
+// `Skeleton()` generates a commented HCL config from the Unix group names
+// `groups` as a starting point for a new site.  The rootdir, the toplevel
+// dirs, and the group naming settings are taken from `cfg`.
+//
+// Facilities are inferred from the facility org unit groups, like
+// `org_em-facility`, and the ops groups, like `srv_em-ops`.  The other service
+// groups, like `srv_em-analysis`, are assigned to the facility with the
+// longest matching name prefix.  Guesses are marked with `REVIEW` comments.
+// Facilities that lack one of the two groups are commented out, so that the
+// skeleton can be loaded as is.  Their services are listed together with the
+// services that have no facility.
+func Skeleton(groups []string, cfg *Root) string {
+	ouPfx := cfg.OrgUnitPrefix + "_"
+	srvPfx := cfg.ServicePrefix + "_"
+	opsSuf := "-" + cfg.OpsSuffix
+	facSuf := "-" + cfg.FacilitySuffix
+
+	var orgUnits, services []string
+	hasFacilityOu := make(map[string]bool)
+	hasOps := make(map[string]bool)
+	for _, g := range groups {
+		switch {
+		case strings.HasPrefix(g, ouPfx):
+			ou := g[len(ouPfx):]
+			orgUnits = append(orgUnits, ou)
+			if strings.HasSuffix(ou, facSuf) {
+				f := strings.TrimSuffix(ou, facSuf)
+				hasFacilityOu[f] = true
+			}
+		case strings.HasPrefix(g, srvPfx):
+			s := g[len(srvPfx):]
+			if strings.HasSuffix(s, opsSuf) {
+				hasOps[strings.TrimSuffix(s, opsSuf)] = true
+			} else {
+				services = append(services, s)
+			}
+		}
+	}
+	sort.Strings(orgUnits)
+	sort.Strings(services)
+
+	var facilities []string
+	for f := range hasFacilityOu {
+		facilities = append(facilities, f)
+	}
+	for f := range hasOps {
+		if !hasFacilityOu[f] {
+			facilities = append(facilities, f)
+		}
+	}
+	sort.Strings(facilities)
+
+	facilityOf := func(s string) string {
+		best := ""
+		for _, f := range facilities {
+			if s != f && !strings.HasPrefix(s, f+"-") {
+				continue
+			}
+			if len(f) > len(best) {
+				best = f
+			}
+		}
+		return best
+	}
+	servicesOf := make(map[string][]string)
+	var unassigned []string
+	for _, s := range services {
+		f := facilityOf(s)
+		if f != "" {
+			servicesOf[f] = append(servicesOf[f], s)
+		}
+		// Services of commented-out facilities are unconfigured, too.
+		if f == "" || !hasFacilityOu[f] || !hasOps[f] {
+			unassigned = append(unassigned, s)
+		}
+	}
+
+	var b strings.Builder
+	w := func(format string, a ...interface{}) {
+		fmt.Fprintf(&b, format, a...)
+	}
+
+	w("%s", skeletonHeader)
+	w("rootdir = %q\n", cfg.Rootdir)
+	w("serviceDir = %q\n", cfg.ServiceDir)
+	w("orgUnitDir = %q\n", cfg.OrgUnitDir)
+	w("orgUnitPrefix = %q\n", cfg.OrgUnitPrefix)
+	w("servicePrefix = %q\n", cfg.ServicePrefix)
+	w("opsSuffix = %q\n", cfg.OpsSuffix)
+	w("facilitySuffix = %q\n", cfg.FacilitySuffix)
+	w("defaultAction = \"reject\"\n")
+	w("%s", skeletonSuperGroup)
+
+	for _, f := range facilities {
+		pfx := ""
+		switch {
+		case !hasFacilityOu[f]:
+			w(skeletonMissingGroup, f, ouPfx+f+facSuf)
+			pfx = "# "
+		case !hasOps[f]:
+			w(skeletonMissingGroup, f, srvPfx+f+opsSuf)
+			pfx = "# "
+		case len(servicesOf[f]) == 0:
+			w("\n# REVIEW: No services match the name prefix ")
+			w("`%s-`.\n", f)
+		default:
+			w("\n# REVIEW: The services have been assigned by ")
+			w("the name prefix `%s-`.\n", f)
+		}
+		w("%sfacility {\n", pfx)
+		w("%s    name = %q\n", pfx, f)
+		if len(servicesOf[f]) == 0 {
+			w("%s    services = []\n", pfx)
+		} else {
+			w("%s    services = [\n", pfx)
+			for _, s := range servicesOf[f] {
+				w("%s        %q,\n", pfx, s)
+			}
+			w("%s    ]\n", pfx)
+		}
+		w("%s    access = \"perService\"\n", pfx)
+		w("%s}\n", pfx)
+	}
+
+	if len(unassigned) > 0 {
+		w("\n")
+		w("# REVIEW: The following services have no facility with ")
+		w("a matching name\n# prefix, or their facility is ")
+		w("commented out.  Add each service to\n# exactly ")
+		w("one `facility`:\n#\n")
+		for _, s := range unassigned {
+			w("#     %q,\n", s)
+		}
+	}
+
+	if len(orgUnits) > 0 {
+		w("\n")
+		w("# REVIEW: The `subdirs` are suggestions.  ")
+		w("Org units without an\n# `orgUnit` block have no subdirs.\n")
+	}
+	for i, ou := range orgUnits {
+		if i > 0 {
+			w("\n")
+		}
+		w("orgUnit {\n")
+		w("    name = %q\n", ou)
+		w("    subdirs = [\n")
+		w("        { name = \"shared\", policy = \"manager\" },\n")
+		w("    ]\n")
+		w("}\n")
+	}
+
+	hasLabs := false
+	for _, ou := range orgUnits {
+		if !strings.HasSuffix(ou, facSuf) {
+			hasLabs = true
+		}
+	}
+	for _, f := range facilities {
+		if !hasFacilityOu[f] || !hasOps[f] {
+			continue
+		}
+		w("\n")
+		w("# REVIEW: `%s%s` can access ", f, facSuf)
+		w("the services of its facility.\n")
+		w("filter {\n")
+		w("    facility = %q\n", f)
+		w("    orgUnit = %q\n", f+facSuf)
+		w("    action = \"accept\"\n")
+		w("}\n")
+	}
+	if hasLabs {
+		w("\n")
+		w("# REVIEW: All labs can access all services.  ")
+		w("Restrict the rule or\n# replace it by rules per facility ")
+		w("or service.\n")
+		w("filter {\n")
+		w("    orgUnitKind = \"lab\"\n")
+		w("    action = \"accept\"\n")
+		w("}\n")
+	}
+
+	return b.String()
+}
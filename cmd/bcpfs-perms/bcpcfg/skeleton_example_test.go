@@ -0,0 +1,110 @@
+package bcpcfg_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+)
+
+func ExampleSkeleton() {
+	groups := []string{
+		"org_ag-alice",
+		"org_em-facility",
+		"srv_em-analysis",
+		"srv_em-ops",
+		"srv_lm-mic1",
+		"srv_lm-ops",
+		"srv_tem",
+	}
+	skel := bcpcfg.Skeleton(groups, &bcpcfg.Root{
+		Rootdir:        "/orgfs/data",
+		ServiceDir:     "srv",
+		OrgUnitDir:     "org",
+		OrgUnitPrefix:  "org",
+		ServicePrefix:  "srv",
+		OpsSuffix:      "ops",
+		FacilitySuffix: "facility",
+	})
+	fmt.Print(skel)
+
+	// The skeleton can be loaded as is.
+	cfg, err := bcpcfg.Parse(skel)
+	fmt.Println(err)
+	fmt.Printf("%+v\n", cfg.Facilities)
+
+	// Output:
+	// # Generated by `bcpfs-perms init` from the Unix groups.  Lines marked
+	// # with `REVIEW` are guesses that must be checked before the config is used.
+	// # See `generic-example-bcpfs.hcl` for a description of the settings.
+	//
+	// rootdir = "/orgfs/data"
+	// serviceDir = "srv"
+	// orgUnitDir = "org"
+	// orgUnitPrefix = "org"
+	// servicePrefix = "srv"
+	// opsSuffix = "ops"
+	// facilitySuffix = "facility"
+	// defaultAction = "reject"
+	//
+	// # REVIEW: `superGroup` is the Unix group that contains all members of all
+	// # org units.  It is required for facilities with `access = "allOrgUnits"`.
+	// # superGroup = ""
+	//
+	// # REVIEW: The services have been assigned by the name prefix `em-`.
+	// facility {
+	//     name = "em"
+	//     services = [
+	//         "em-analysis",
+	//     ]
+	//     access = "perService"
+	// }
+	//
+	// # REVIEW: Facility `lm` lacks the Unix group `org_lm-facility`.
+	// # Create the group or remove the facility.
+	// # facility {
+	// #     name = "lm"
+	// #     services = [
+	// #         "lm-mic1",
+	// #     ]
+	// #     access = "perService"
+	// # }
+	//
+	// # REVIEW: The following services have no facility with a matching name
+	// # prefix, or their facility is commented out.  Add each service to
+	// # exactly one `facility`:
+	// #
+	// #     "lm-mic1",
+	// #     "tem",
+	//
+	// # REVIEW: The `subdirs` are suggestions.  Org units without an
+	// # `orgUnit` block have no subdirs.
+	// orgUnit {
+	//     name = "ag-alice"
+	//     subdirs = [
+	//         { name = "shared", policy = "manager" },
+	//     ]
+	// }
+	//
+	// orgUnit {
+	//     name = "em-facility"
+	//     subdirs = [
+	//         { name = "shared", policy = "manager" },
+	//     ]
+	// }
+	//
+	// # REVIEW: `em-facility` can access the services of its facility.
+	// filter {
+	//     facility = "em"
+	//     orgUnit = "em-facility"
+	//     action = "accept"
+	// }
+	//
+	// # REVIEW: All labs can access all services.  Restrict the rule or
+	// # replace it by rules per facility or service.
+	// filter {
+	//     orgUnitKind = "lab"
+	//     action = "accept"
+	// }
+	// <nil>
+	// [{Name:em Services:[em-analysis] Access:perService}]
+}
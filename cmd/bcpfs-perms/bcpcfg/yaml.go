@@ -0,0 +1,154 @@
+package bcpcfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config file formats, see `FormatOfPath()`.
+const (
+	FormatHCL  = "hcl"
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// `FormatOfPath()` determines the config format from the file extension:
+// `.yaml` and `.yml` are YAML, `.json` is JSON, and all other files are HCL.
+func FormatOfPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	default:
+		return FormatHCL
+	}
+}
+
+// `ParseFormat()` parses the config from a string in `format`.
+func ParseFormat(d string, format string) (*Root, error) {
+	cfg, err := parseFormatFragment(d, format)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRoot(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func parseFormatFragment(d string, format string) (*Root, error) {
+	switch format {
+	case FormatHCL:
+		return parseFragment(d)
+	case FormatYAML:
+		return parseYAMLFragment(d)
+	case FormatJSON:
+		return parseJSONFragment(d)
+	default:
+		return nil, fmt.Errorf("unknown config format `%s`", format)
+	}
+}
+
+// `ParseYAML()` parses the config from a string in the YAML format that
+// `bcpfs-perms describe config` prints, so that its output can be used as
+// input.  Unknown fields are rejected.  The config is validated like with
+// `Parse()`.
+func ParseYAML(d string) (*Root, error) {
+	return ParseFormat(d, FormatYAML)
+}
+
+// `ParseJSON()` parses the config from a string in JSON with the same field
+// names as `ParseYAML()`.  Field names must match exactly, like in YAML and
+// `Schema()`.
+func ParseJSON(d string) (*Root, error) {
+	return ParseFormat(d, FormatJSON)
+}
+
+func parseYAMLFragment(d string) (*Root, error) {
+	var cfg Root
+	if err := yaml.UnmarshalStrict([]byte(d), &cfg); err != nil {
+		return nil, err
+	}
+	if err := validateFragment(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// `parseJSONFragment()` relies on the case-insensitive matching of
+// `encoding/json` to decode the lowercase YAML field names, like `rootdir`,
+// into the struct fields.  `checkJSONKeys()` then rejects keys that are not
+// exactly the YAML field names, like `orgUnits`.
+func parseJSONFragment(d string) (*Root, error) {
+	var cfg Root
+	dec := json.NewDecoder(bytes.NewReader([]byte(d)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Include) > 0 {
+		return nil, errors.New("`include` is only supported in HCL")
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(d), &v); err != nil {
+		return nil, err
+	}
+	if err := checkJSONKeys(v, reflect.TypeOf(cfg)); err != nil {
+		return nil, err
+	}
+	if err := validateFragment(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// `checkJSONKeys()` verifies that the object keys in the decoded JSON `v` are
+// the YAML field names of the corresponding struct type `t`; see
+// `yamlFieldName()`.  Type mismatches are ignored, because the JSON decoder
+// reports them.
+func checkJSONKeys(v interface{}, t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return checkJSONKeys(v, t.Elem())
+	case reflect.Slice:
+		items, _ := v.([]interface{})
+		for _, item := range items {
+			if err := checkJSONKeys(item, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		obj, _ := v.(map[string]interface{})
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name := yamlFieldName(f); name != "" {
+				fields[name] = f.Type
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ft, ok := fields[k]
+			if !ok {
+				return fmt.Errorf("json: unknown field %q", k)
+			}
+			if err := checkJSONKeys(obj[k], ft); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package bcpcfg_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	"gopkg.in/yaml.v2"
+)
+
+func ExampleParseYAML() {
+	cfg, err := bcpcfg.Parse(`
+rootdir = "/fsroot"
+serviceDir = "srv"
+facility { name = "em", services = ["tem"], access = "perService" }
+orgUnit {
+    name = "ag-alice"
+    subdirs = [{ name = "people", policy = "owner" }]
+}
+filter { service = "tem", orgUnit = "ag-alice", action = "accept" }
+`)
+	if err != nil {
+		panic(err)
+	}
+
+	// The output of `describe config` can be parsed again.
+	d, err := yaml.Marshal(cfg)
+	if err != nil {
+		panic(err)
+	}
+	cfg2, err := bcpcfg.ParseYAML(string(d))
+	fmt.Println(err)
+	d2, _ := yaml.Marshal(cfg2)
+	fmt.Println(string(d) == string(d2))
+	fmt.Printf("%+v\n", cfg2.Facilities)
+	for _, r := range cfg2.Filter {
+		fmt.Println(r.Services, r.OrgUnits, r.Action)
+	}
+
+	_, err = bcpcfg.ParseYAML("rootdir: /fsroot\nrootdr: /fsroot\n")
+	fmt.Println(err != nil)
+	_, err = bcpcfg.ParseYAML(`
+rootdir: /fsroot
+filter:
+- services: [tem]
+  orgunits: [ag-alice]
+  action: acept
+`)
+	fmt.Println(err)
+
+	// Output:
+	// <nil>
+	// true
+	// [{Name:em Services:[tem] Access:perService}]
+	// [tem] [ag-alice] accept
+	// true
+	// Failed to parse 'filter': item 0: Invalid action!
+}
+
+func ExampleParseJSON() {
+	cfg, err := bcpcfg.ParseJSON(`{
+  "rootdir": "/fsroot",
+  "servicedir": "srv",
+  "facilities": [
+    { "name": "em", "services": ["tem"], "access": "perService" }
+  ],
+  "filter": [
+    { "services": ["tem"], "orgunits": ["ag-.*"], "action": "accept" }
+  ]
+}`)
+	fmt.Println(err)
+	fmt.Println(cfg.Rootdir, cfg.ServiceDir)
+	fmt.Printf("%+v\n", cfg.Facilities)
+
+	_, err = bcpcfg.ParseJSON(`{ "servicedir": "srv" }`)
+	fmt.Println(err)
+
+	// Keys must match exactly, like in YAML and the schema.
+	_, err = bcpcfg.ParseJSON(`{ "rootdir": "/fsroot", "serviceDir": "srv" }`)
+	fmt.Println(err)
+	_, err = bcpcfg.ParseJSON(`{
+  "rootdir": "/fsroot",
+  "orgunits": [{ "name": "ag-alice", "extraDirs": ["projects"] }]
+}`)
+	fmt.Println(err)
+
+	// Output:
+	// <nil>
+	// /fsroot srv
+	// [{Name:em Services:[tem] Access:perService}]
+	// Missing `rootdir`
+	// json: unknown field "serviceDir"
+	// json: unknown field "extraDirs"
+}
+
+func ExampleParseFormat() {
+	// The same checks apply to all formats.
+	for _, c := range []struct{ format, cfg string }{
+		{bcpcfg.FormatHCL, `
+rootdir = "/fsroot"
+symlink { path = "srv/guides", target = "" }
+`},
+		{bcpcfg.FormatYAML, `
+rootdir: /fsroot
+symlinks: [{ path: srv/guides, target: "" }]
+`},
+		{bcpcfg.FormatJSON, `{
+  "rootdir": "/fsroot",
+  "symlinks": [{ "path": "srv/guides", "target": "" }]
+}`},
+		{bcpcfg.FormatHCL, `
+rootdir = "/fsroot"
+sharing { import { action = "acept", group = "ag-bar", match = ".*" } }
+`},
+		{bcpcfg.FormatYAML, `
+rootdir: /fsroot
+sharing: { imports: [{ action: acept, group: ag-bar, match: .* }] }
+`},
+	} {
+		_, err := bcpcfg.ParseFormat(c.cfg, c.format)
+		fmt.Println(c.format, err)
+	}
+
+	// Output:
+	// hcl Failed to parse 'symlink': empty `target` in item 0
+	// yaml Failed to parse 'symlink': empty `target` in item 0
+	// json Failed to parse 'symlink': empty `target` in item 0
+	// hcl Failed to parse 'sharing': Failed to parse 'sharing.imports': failed to parse item 0: invalid Import action `acept`
+	// yaml Failed to parse 'sharing': Failed to parse 'sharing.imports': failed to parse item 0: invalid Import action `acept`
+}
+
+func ExampleFormatOfPath() {
+	for _, p := range []string{
+		"/etc/bcpfs.hcl", "/etc/bcpfs.yaml", "/etc/bcpfs.d/10-em.yml",
+		"/etc/bcpfs.json", "/etc/bcpfs.conf",
+	} {
+		fmt.Println(p, bcpcfg.FormatOfPath(p))
+	}
+
+	// Output:
+	// /etc/bcpfs.hcl hcl
+	// /etc/bcpfs.yaml yaml
+	// /etc/bcpfs.d/10-em.yml yaml
+	// /etc/bcpfs.json json
+	// /etc/bcpfs.conf hcl
+}
+
+func ExampleSchema() {
+	s := bcpcfg.Schema()
+	fmt.Println(s["$schema"])
+	props := s["properties"].(map[string]interface{})
+	filter := props["filter"].(map[string]interface{})
+	rule := filter["items"].(map[string]interface{})
+	fmt.Println(rule["required"])
+	ruleProps := rule["properties"].(map[string]interface{})
+	fmt.Println(ruleProps["action"])
+	fmt.Println(ruleProps["orgunitkind"])
+	_, ok := props["include"]
+	fmt.Println(ok)
+
+	// Output:
+	// http://json-schema.org/draft-07/schema#
+	// [action]
+	// map[enum:[accept reject] type:string]
+	// map[enum:[facility lab] type:string]
+	// false
+}
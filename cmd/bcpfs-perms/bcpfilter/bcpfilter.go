@@ -5,7 +5,8 @@ and org units.  It can be used to avoid meaningless directories.
 The interface `OrgServiceFilter` is used by other packages to test whether to
 `Accept(service, orgUnit)`.  `DecidersFilter` implements the interface as an
 array of `Decider` instances.  The deciders are usually initialized by
-startup code based on configuration settings.
+startup code based on configuration settings.  `DecidersFilter.Explain()`
+returns a rule-by-rule `Trace` of a decision.
 
 Decider Constructors
 
@@ -15,6 +16,34 @@ if the facility owns the service, and rejects otherwise.
 
 `NewRegexpDecider()` creates a decider that uses regular expressions for the
 service name and the org unit name.
+
+`NewFacilityDecider()` creates a decider that uses a regular expression for the
+name of the facility that owns the service.
+
+`NewAccessDecider()` creates a decider that matches the facility access policy
+of the service, `perService` or `allOrgUnits`.
+
+`NewOrgUnitKindDecider()` creates a decider that matches whether the org unit is
+a `facility` or a `lab`.
+
+`NewConjunctionDecider()` combines deciders, so that a rule matches only if all
+criteria match.
+
+`NewTimeWindowDecider()` limits a decider to a time window.  Outside of the
+window, it passes.
+
+`NewFilterRuleDecider()` creates the decider for a config filter rule.
+
+Expiration
+
+Filter rules with a `validUntil` date stop applying when the time window
+closes.  `DecidersFilter.Expired()` reports combinations that are no longer
+accepted because an accepting rule has expired, so that other packages can
+revoke access without removing data.  `DecidersFilter.Expiration()` reports
+the end of the time window of the rule that currently accepts a combination.
+
+If no rule decides, `DecidersFilter` applies its `Default` action, which is
+`REJECT` unless configured otherwise.
 */
 package bcpfilter
 
@@ -22,6 +51,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
@@ -67,11 +97,33 @@ type OrgServiceFilter interface {
 	Accept(bcp.Service, bcp.OrgUnit) (ok bool, reason string)
 }
 
+// An `ExpiringFilter` is an `OrgServiceFilter` that knows about time-bounded
+// rules.  `Expired()` returns `expired=true` if a combination is not accepted
+// because the time window of an accepting rule has closed, and the last day
+// `validUntil` of the window.
+type ExpiringFilter interface {
+	OrgServiceFilter
+	Expired(bcp.Service, bcp.OrgUnit) (expired bool, validUntil string)
+}
+
+// `IsExpired()` calls `f.Expired()` if `f` is an `ExpiringFilter`.  Other
+// filters never report expired combinations.
+func IsExpired(
+	f OrgServiceFilter, s bcp.Service, ou bcp.OrgUnit,
+) (expired bool, validUntil string) {
+	if ef, ok := f.(ExpiringFilter); ok {
+		return ef.Expired(s, ou)
+	}
+	return false, ""
+}
+
 // `DecidersFilter` is an `OrgServiceFilter`.  It tests a list of decider
 // `Rules`.  If a rule matches, the filter accepts or rejects according to the
-// return value of the decider. It rejects by default if no rule matches.
+// return value of the decider.  If no rule matches, it applies the `Default`
+// action, where the zero value rejects.
 type DecidersFilter struct {
-	Rules []Decider
+	Rules   []Decider
+	Default Action
 }
 
 // `Decider` is the interface of `DecidersFilter` rules.
@@ -83,16 +135,129 @@ func (f *DecidersFilter) Accept(
 	s bcp.Service, ou bcp.OrgUnit,
 ) (bool, string) {
 	for _, r := range f.Rules {
-		if action, reason := r.Decide(s, ou); action == ACCEPT {
+		switch action, reason := r.Decide(s, ou); action {
+		case ACCEPT:
 			return true, reason
-		}
-		if action, reason := r.Decide(s, ou); action == REJECT {
+		case REJECT:
 			return false, reason
 		}
 	}
+	return f.defaultDecision()
+}
+
+func (f *DecidersFilter) defaultDecision() (bool, string) {
+	if f.Default == ACCEPT {
+		return true, "no rule rejected; default accept"
+	}
 	return false, "no rule accepted"
 }
 
+// `Expired()` returns `expired=true` if the combination is not accepted but
+// would be accepted by a rule whose time window has closed.  `validUntil` is
+// the last day of the expired window.
+func (f *DecidersFilter) Expired(
+	s bcp.Service, ou bcp.OrgUnit,
+) (expired bool, validUntil string) {
+	if ok, _ := f.Accept(s, ou); ok {
+		return false, ""
+	}
+	for _, r := range f.Rules {
+		if w, ok := r.(*TimeWindowDecider); ok && w.Expired() {
+			switch action, _ := w.rule.Decide(s, ou); action {
+			case ACCEPT:
+				return true, w.validUntil
+			case REJECT:
+				return false, ""
+			}
+			continue
+		}
+		if action, _ := r.Decide(s, ou); action != PASS {
+			return false, ""
+		}
+	}
+	return false, ""
+}
+
+// `Expiration()` returns the last day `validUntil` of the time window of the
+// rule that accepts the combination.  It returns `""` if the combination is
+// not accepted or if the accepting rule has no end date.
+func (f *DecidersFilter) Expiration(s bcp.Service, ou bcp.OrgUnit) string {
+	for _, r := range f.Rules {
+		switch action, _ := r.Decide(s, ou); action {
+		case ACCEPT:
+			if w, ok := r.(*TimeWindowDecider); ok {
+				return w.validUntil
+			}
+			return ""
+		case REJECT:
+			return ""
+		}
+	}
+	return ""
+}
+
+// `Step` is the decision of rule number `Rule`, counting from 1, in a
+// `Trace`.
+type Step struct {
+	Rule   int
+	Action Action
+	Reason string
+}
+
+// `Trace` explains a decision of `DecidersFilter`.  `Steps` contains the
+// decisions of all rules, including the rules after the decisive rule
+// `Decisive`, which is 0 if no rule decided.  `Ok` and `Reason` are the
+// result of `Accept()`.
+type Trace struct {
+	Steps    []Step
+	Decisive int
+	Ok       bool
+	Reason   string
+}
+
+// `Explain()` evaluates every rule for a combination of service and org unit
+// and returns a trace of the decisions.
+func (f *DecidersFilter) Explain(s bcp.Service, ou bcp.OrgUnit) Trace {
+	var t Trace
+	t.Ok, t.Reason = f.defaultDecision()
+	for i, r := range f.Rules {
+		action, reason := r.Decide(s, ou)
+		t.Steps = append(t.Steps, Step{
+			Rule: i + 1, Action: action, Reason: reason,
+		})
+		if t.Decisive == 0 && action != PASS {
+			t.Decisive = i + 1
+			t.Ok = (action == ACCEPT)
+			t.Reason = reason
+		}
+	}
+	return t
+}
+
+// `String()` formats the trace with one line per rule and a final result
+// line.
+func (t Trace) String() string {
+	var b strings.Builder
+	for _, st := range t.Steps {
+		fmt.Fprintf(
+			&b, "rule %d: %s: %s", st.Rule, st.Action, st.Reason,
+		)
+		switch {
+		case st.Rule == t.Decisive:
+			b.WriteString(" (decisive)")
+		case t.Decisive != 0 && st.Rule > t.Decisive:
+			b.WriteString(" (ignored)")
+		}
+		b.WriteString("\n")
+	}
+	result := REJECT
+	if t.Ok {
+		result = ACCEPT
+	}
+	fmt.Fprintf(&b, "result: %s: %s\n", result, t.Reason)
+	return b.String()
+}
+
 // A `SameFacilityDecider` decides if the org unit is a facility and passes
 // otherwise.  It accepts combinations of (service, org unit) if the facility
 // owns the service, and rejects otherwise.
@@ -193,3 +358,273 @@ func (r *RegexpDecider) Decide(
 		r.servicePattern, r.orgUnitPattern,
 	)
 }
+
+// `FacilityDecider` evaluates combinations of (service, org unit) based on a
+// regex for the name of the facility that owns the service.  It passes if the
+// regex does not match and applies its action otherwise.
+//
+// Use `NewFacilityDecider()` to create an instance.
+type FacilityDecider struct {
+	action  Action
+	pattern string
+	rgx     *regexp.Regexp
+}
+
+func NewFacilityDecider(facilities []string, action string) (Decider, error) {
+	res := &FacilityDecider{
+		action:  ActionFromString(action),
+		pattern: strings2Pattern(facilities),
+	}
+	var err error
+	res.rgx, err = regexp.Compile(res.pattern)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r *FacilityDecider) Decide(
+	s bcp.Service, ou bcp.OrgUnit,
+) (Action, string) {
+	if !r.rgx.MatchString(s.Facility) {
+		return PASS, fmt.Sprintf("facility!~/%s/", r.pattern)
+	}
+	return r.action, fmt.Sprintf("facility=~/%s/", r.pattern)
+}
+
+// `AccessDecider` evaluates combinations of (service, org unit) based on the
+// facility access policy of the service.  It passes if the policy differs and
+// applies its action otherwise.
+//
+// Use `NewAccessDecider()` to create an instance.
+type AccessDecider struct {
+	action Action
+	access bcp.AccessPolicy
+	name   string
+}
+
+func NewAccessDecider(access string, action string) (Decider, error) {
+	if access == "" {
+		return nil, fmt.Errorf("empty access policy")
+	}
+	a, err := bcp.AccessPolicyFromString(access)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessDecider{
+		action: ActionFromString(action),
+		access: a,
+		name:   access,
+	}, nil
+}
+
+func (r *AccessDecider) Decide(
+	s bcp.Service, ou bcp.OrgUnit,
+) (Action, string) {
+	if s.Access != r.access {
+		return PASS, fmt.Sprintf("access!=%s", r.name)
+	}
+	return r.action, fmt.Sprintf("access=%s", r.name)
+}
+
+// `OrgUnitKindDecider` evaluates combinations of (service, org unit) based on
+// whether the org unit is a `facility` or a `lab`, that is any org unit that
+// is not a facility.  It passes if the kind differs and applies its action
+// otherwise.
+//
+// Use `NewOrgUnitKindDecider()` to create an instance.
+type OrgUnitKindDecider struct {
+	action     Action
+	isFacility bool
+	kind       string
+}
+
+func NewOrgUnitKindDecider(kind string, action string) (Decider, error) {
+	res := &OrgUnitKindDecider{
+		action: ActionFromString(action),
+		kind:   kind,
+	}
+	switch kind {
+	case "facility":
+		res.isFacility = true
+	case "lab":
+		res.isFacility = false
+	default:
+		return nil, fmt.Errorf("invalid org unit kind `%s`", kind)
+	}
+	return res, nil
+}
+
+func (r *OrgUnitKindDecider) Decide(
+	s bcp.Service, ou bcp.OrgUnit,
+) (Action, string) {
+	if ou.IsFacility != r.isFacility {
+		return PASS, fmt.Sprintf("orgUnitKind!=%s", r.kind)
+	}
+	return r.action, fmt.Sprintf("orgUnitKind=%s", r.kind)
+}
+
+// `ConjunctionDecider` combines `Rules`.  It passes with the reason of the
+// first rule that passes.  If no rule passes, it returns the action of the
+// last rule with the reasons of all rules.  The rules should use the same
+// action.
+//
+// Use `NewConjunctionDecider()` to create an instance.
+type ConjunctionDecider struct {
+	Rules []Decider
+}
+
+func NewConjunctionDecider(rules ...Decider) Decider {
+	return &ConjunctionDecider{Rules: rules}
+}
+
+func (r *ConjunctionDecider) Decide(
+	s bcp.Service, ou bcp.OrgUnit,
+) (Action, string) {
+	action := PASS
+	reasons := make([]string, 0, len(r.Rules))
+	for _, rule := range r.Rules {
+		a, reason := rule.Decide(s, ou)
+		if a == PASS {
+			return PASS, reason
+		}
+		action = a
+		reasons = append(reasons, reason)
+	}
+	return action, strings.Join(reasons, " and ")
+}
+
+// `TimeWindowDecider` applies `rule` only within a time window.  Outside of
+// the window, it passes.  The time `now` is fixed when the decider is created,
+// so that all decisions of a run are consistent.
+//
+// Use `NewTimeWindowDecider()` to create an instance.
+type TimeWindowDecider struct {
+	rule       Decider
+	validFrom  string
+	validUntil string
+	from       time.Time
+	until      time.Time
+	now        time.Time
+}
+
+// `NewTimeWindowDecider()` limits `rule` to the dates `validFrom` to
+// `validUntil`, inclusive, where empty strings indicate an unbounded window;
+// see `bcpcfg.FilterRule.ValidWindow()`.
+func NewTimeWindowDecider(
+	rule Decider, validFrom, validUntil string, now time.Time,
+) (*TimeWindowDecider, error) {
+	from, until, err := bcpcfg.FilterRule{
+		ValidFrom: validFrom, ValidUntil: validUntil,
+	}.ValidWindow()
+	if err != nil {
+		return nil, err
+	}
+	return &TimeWindowDecider{
+		rule:       rule,
+		validFrom:  validFrom,
+		validUntil: validUntil,
+		from:       from,
+		until:      until,
+		now:        now,
+	}, nil
+}
+
+// `Expired()` returns true if the window has closed.
+func (r *TimeWindowDecider) Expired() bool {
+	return !r.until.IsZero() && !r.now.Before(r.until)
+}
+
+func (r *TimeWindowDecider) Decide(
+	s bcp.Service, ou bcp.OrgUnit,
+) (Action, string) {
+	if !r.from.IsZero() && r.now.Before(r.from) {
+		return PASS, fmt.Sprintf("rule valid from %s", r.validFrom)
+	}
+	if r.Expired() {
+		return PASS, fmt.Sprintf("rule expired after %s", r.validUntil)
+	}
+	action, reason := r.rule.Decide(s, ou)
+	if action != PASS && r.validUntil != "" {
+		reason = fmt.Sprintf("%s until %s", reason, r.validUntil)
+	}
+	return action, reason
+}
+
+// `NewFilterRuleDecider()` creates the decider for a config filter rule,
+// using the current time for `ValidFrom` and `ValidUntil`.  See
+// `NewFilterRuleDeciderAt()`.
+func NewFilterRuleDecider(r bcpcfg.FilterRule) (Decider, error) {
+	return NewFilterRuleDeciderAt(r, time.Now())
+}
+
+// `NewFilterRuleDeciderAt()` creates the decider for a config filter rule at
+// time `now`.  Rules with only `Services` and `OrgUnits` use a
+// `RegexpDecider`.  Other rules use a `ConjunctionDecider` of the specified
+// criteria, where a missing `Services` or `OrgUnits` regex matches all names.
+// Rules with `ValidFrom` or `ValidUntil` are wrapped in a
+// `TimeWindowDecider`.
+func NewFilterRuleDeciderAt(
+	r bcpcfg.FilterRule, now time.Time,
+) (Decider, error) {
+	d, err := newCriteriaDecider(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.ValidFrom == "" && r.ValidUntil == "" {
+		return d, nil
+	}
+	w, err := NewTimeWindowDecider(d, r.ValidFrom, r.ValidUntil, now)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func newCriteriaDecider(r bcpcfg.FilterRule) (Decider, error) {
+	if r.HasRegexpOnly() {
+		return NewRegexpDecider(r)
+	}
+
+	var rules []Decider
+	if len(r.Services) > 0 || len(r.OrgUnits) > 0 {
+		rr := r
+		if len(rr.Services) == 0 {
+			rr.Services = []string{".*"}
+		}
+		if len(rr.OrgUnits) == 0 {
+			rr.OrgUnits = []string{".*"}
+		}
+		d, err := NewRegexpDecider(rr)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, d)
+	}
+	if len(r.Facilities) > 0 {
+		d, err := NewFacilityDecider(r.Facilities, r.Action)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, d)
+	}
+	if r.Access != "" {
+		d, err := NewAccessDecider(r.Access, r.Action)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, d)
+	}
+	if r.OrgUnitKind != "" {
+		d, err := NewOrgUnitKindDecider(r.OrgUnitKind, r.Action)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, d)
+	}
+
+	if len(rules) == 1 {
+		return rules[0], nil
+	}
+	return NewConjunctionDecider(rules...), nil
+}
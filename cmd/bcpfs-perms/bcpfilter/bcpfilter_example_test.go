@@ -2,6 +2,7 @@ package bcpfilter_test
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
@@ -255,3 +256,122 @@ func ExampleRegexpDecider() {
 	// true service=~/^ms-data$/ and orgUnit=~/^(ag-foo|ag-bar)$/
 	// false no rule accepted
 }
+
+func ExampleDecidersFilter_Explain() {
+	rejectBar, _ := bcpfilter.NewRegexpDecider(bcpcfg.FilterRule{
+		Services: []string{"micro"},
+		OrgUnits: []string{"ag-bar"},
+		Action:   "reject",
+	})
+	acceptAll, _ := bcpfilter.NewRegexpDecider(bcpcfg.FilterRule{
+		Services: []string{".*"},
+		OrgUnits: []string{"ag-.*"},
+		Action:   "accept",
+	})
+	filter := bcpfilter.DecidersFilter{Rules: []bcpfilter.Decider{
+		bcpfilter.NewSameFacilityDecider(), rejectBar, acceptAll,
+	}}
+
+	fmt.Print(filter.Explain(
+		bcp.Service{Name: "micro", Facility: "foo"},
+		bcp.OrgUnit{Name: "ag-bar", IsFacility: false},
+	))
+
+	// Output:
+	// rule 1: PASS: ag-bar is not a facility
+	// rule 2: REJECT: service=~/^micro$/ and orgUnit=~/^ag-bar$/ (decisive)
+	// rule 3: ACCEPT: service=~/^.*$/ and orgUnit=~/^ag-.*$/ (ignored)
+	// result: REJECT: service=~/^micro$/ and orgUnit=~/^ag-bar$/
+}
+
+func ExampleNewFilterRuleDecider() {
+	rejectLabs, _ := bcpfilter.NewFilterRuleDecider(bcpcfg.FilterRule{
+		Facilities:  []string{"lm"},
+		Access:      "perService",
+		OrgUnitKind: "lab",
+		Action:      "reject",
+	})
+	acceptAllOrgUnits, _ := bcpfilter.NewFilterRuleDecider(bcpcfg.FilterRule{
+		Access: "allOrgUnits",
+		Action: "accept",
+	})
+	filter := bcpfilter.DecidersFilter{
+		Rules:   []bcpfilter.Decider{rejectLabs, acceptAllOrgUnits},
+		Default: bcpfilter.ACCEPT,
+	}
+
+	ok, reason := filter.Accept(
+		bcp.Service{
+			Name: "micro", Facility: "lm", Access: bcp.AccessPerService,
+		},
+		bcp.OrgUnit{Name: "ag-bar", IsFacility: false},
+	)
+	fmt.Println(ok, reason)
+
+	ok, reason = filter.Accept(
+		bcp.Service{
+			Name: "micro", Facility: "lm", Access: bcp.AccessPerService,
+		},
+		bcp.OrgUnit{Name: "em-facility", IsFacility: true},
+	)
+	fmt.Println(ok, reason)
+
+	ok, reason = filter.Accept(
+		bcp.Service{
+			Name: "data", Facility: "ms", Access: bcp.AccessAllOrgUnits,
+		},
+		bcp.OrgUnit{Name: "ag-bar", IsFacility: false},
+	)
+	fmt.Println(ok, reason)
+
+	// Output:
+	// false facility=~/^lm$/ and access=perService and orgUnitKind=lab
+	// true no rule rejected; default accept
+	// true access=allOrgUnits
+}
+
+func ExampleTimeWindowDecider() {
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.Local)
+	acceptAlice, _ := bcpfilter.NewFilterRuleDeciderAt(bcpcfg.FilterRule{
+		Services:   []string{"micro"},
+		OrgUnits:   []string{"ag-alice"},
+		Action:     "accept",
+		ValidUntil: "2019-06-30",
+	}, now)
+	acceptBob, _ := bcpfilter.NewFilterRuleDeciderAt(bcpcfg.FilterRule{
+		Services:   []string{"micro"},
+		OrgUnits:   []string{"ag-bob"},
+		Action:     "accept",
+		ValidFrom:  "2019-07-01",
+		ValidUntil: "2019-12-31",
+	}, now)
+	acceptCharly, _ := bcpfilter.NewFilterRuleDeciderAt(bcpcfg.FilterRule{
+		Services:  []string{"micro"},
+		OrgUnits:  []string{"ag-charly"},
+		Action:    "accept",
+		ValidFrom: "2019-07-02",
+	}, now)
+	filter := bcpfilter.DecidersFilter{Rules: []bcpfilter.Decider{
+		acceptAlice, acceptBob, acceptCharly,
+	}}
+
+	micro := bcp.Service{Name: "micro", Facility: "foo"}
+	for _, name := range []string{"ag-alice", "ag-bob", "ag-charly"} {
+		ou := bcp.OrgUnit{Name: name}
+		ok, reason := filter.Accept(micro, ou)
+		expired, validUntil := filter.Expired(micro, ou)
+		fmt.Println(name, ok, reason)
+		fmt.Printf(
+			"    expired=%v validUntil=%q expiration=%q\n",
+			expired, validUntil, filter.Expiration(micro, ou),
+		)
+	}
+
+	// Output:
+	// ag-alice false no rule accepted
+	//     expired=true validUntil="2019-06-30" expiration=""
+	// ag-bob true service=~/^micro$/ and orgUnit=~/^ag-bob$/ until 2019-12-31
+	//     expired=false validUntil="" expiration="2019-12-31"
+	// ag-charly false no rule accepted
+	//     expired=false validUntil="" expiration=""
+}
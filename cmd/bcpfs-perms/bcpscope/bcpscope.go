@@ -0,0 +1,176 @@
+/*
+Package `bcpscope` restricts `apply` and `check` to the parts of the filesystem
+that belong to selected org units and services.
+
+A `Scope` is created from two regular expressions, one for org unit names and
+one for service names.  An empty expression selects all names.  Regexes are
+automatically anchored to the beginning "^" and end "$", like in package
+`bcpfilter`.
+
+`Scope.Selects(path)` classifies a path by its position in the filesystem tree:
+
+ - `<srv>/<service>/<ou>/...` and `<org>/<ou>/<service>` are selected if both
+   the service and the org unit match.
+ - `<srv>/<service>` is selected if the service matches and no org unit
+   expression is specified.
+ - `<org>/<ou>` and `<org>/<ou>/<subdir>/...` are selected if the org unit
+   matches and no service expression is specified.
+ - All other paths, like the rootdir, are only selected if neither expression
+   is specified.
+
+A nil `*Scope` selects all paths.
+*/
+package bcpscope
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+)
+
+type Scope struct {
+	serviceRoot    string
+	orgUnitRoot    string
+	services       map[string]bool
+	orgUnitPattern string
+	servicePattern string
+	orgUnitRgx     *regexp.Regexp
+	serviceRgx     *regexp.Regexp
+}
+
+// `New()` creates a scope for the org units that match `orgUnit` and the
+// services that match `service`.  Services are taken from `org` to recognize
+// service symlinks `<org>/<ou>/<service>`.
+func New(
+	cfg *bcpcfg.Root, org *bcp.Organization, orgUnit, service string,
+) (*Scope, error) {
+	root, err := filepath.Abs(cfg.Rootdir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scope{
+		serviceRoot: filepath.Join(root, cfg.ServiceDir),
+		orgUnitRoot: filepath.Join(root, cfg.OrgUnitDir),
+		services:    make(map[string]bool),
+	}
+	for _, srv := range org.Services {
+		s.services[srv.Name] = true
+	}
+
+	if orgUnit != "" {
+		s.orgUnitPattern = anchoredPattern(orgUnit)
+		s.orgUnitRgx, err = regexp.Compile(s.orgUnitPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid org unit regex: %v", err)
+		}
+	}
+	if service != "" {
+		s.servicePattern = anchoredPattern(service)
+		s.serviceRgx, err = regexp.Compile(s.servicePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service regex: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// `anchoredPattern()` anchors `p`.  Unlike in `bcpfilter`, the pattern is
+// grouped, so that alternatives like `ag-foo|ag-bar` are anchored, too.
+func anchoredPattern(p string) string {
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "^"), "$")
+	return "^(?:" + p + ")$"
+}
+
+// `IsAll()` returns true if the scope selects all paths.
+func (s *Scope) IsAll() bool {
+	return s == nil || (s.orgUnitRgx == nil && s.serviceRgx == nil)
+}
+
+// `String()` describes the scope for logging.
+func (s *Scope) String() string {
+	if s.IsAll() {
+		return "all"
+	}
+	var parts []string
+	if s.orgUnitRgx != nil {
+		parts = append(parts, "orgUnit=~/"+s.orgUnitPattern+"/")
+	}
+	if s.serviceRgx != nil {
+		parts = append(parts, "service=~/"+s.servicePattern+"/")
+	}
+	return strings.Join(parts, " and ")
+}
+
+// `SelectsOrgUnit()` tells whether paths that only belong to org unit `ou`,
+// like `<org>/<ou>/<subdir>`, are selected.
+func (s *Scope) SelectsOrgUnit(ou string) bool {
+	if s.IsAll() {
+		return true
+	}
+	return s.serviceRgx == nil && s.orgUnitRgx.MatchString(ou)
+}
+
+// `SelectsService()` tells whether paths that only belong to service `srv`,
+// like `<srv>/<service>`, are selected.
+func (s *Scope) SelectsService(srv string) bool {
+	if s.IsAll() {
+		return true
+	}
+	return s.orgUnitRgx == nil && s.serviceRgx.MatchString(srv)
+}
+
+// `SelectsServiceOrgUnit()` tells whether paths that belong to the
+// combination of service `srv` and org unit `ou`, like `<srv>/<service>/<ou>`,
+// are selected.
+func (s *Scope) SelectsServiceOrgUnit(srv, ou string) bool {
+	if s.IsAll() {
+		return true
+	}
+	if s.serviceRgx != nil && !s.serviceRgx.MatchString(srv) {
+		return false
+	}
+	if s.orgUnitRgx != nil && !s.orgUnitRgx.MatchString(ou) {
+		return false
+	}
+	return true
+}
+
+// `Selects()` tells whether `path` is selected; see package description.
+// Relative paths are interpreted relative to the working directory.
+func (s *Scope) Selects(path string) bool {
+	if s.IsAll() {
+		return true
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if parts, ok := below(s.serviceRoot, path); ok {
+		switch len(parts) {
+		case 1:
+			return s.SelectsService(parts[0])
+		default:
+			return s.SelectsServiceOrgUnit(parts[0], parts[1])
+		}
+	}
+	if parts, ok := below(s.orgUnitRoot, path); ok {
+		if len(parts) >= 2 && s.services[parts[1]] {
+			return s.SelectsServiceOrgUnit(parts[1], parts[0])
+		}
+		return s.SelectsOrgUnit(parts[0])
+	}
+	return false
+}
+
+// `below()` returns the path elements of `path` relative to `root` if `path`
+// is strictly below `root`.
+func below(root, path string) ([]string, bool) {
+	if !strings.HasPrefix(path, root+"/") {
+		return nil, false
+	}
+	return strings.Split(path[len(root)+1:], "/"), true
+}
@@ -0,0 +1,64 @@
+package bcpscope_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
+)
+
+func ExampleScope() {
+	cfg := &bcpcfg.Root{
+		Rootdir:    "/orgfs/data",
+		ServiceDir: "srv",
+		OrgUnitDir: "org",
+	}
+	org := &bcp.Organization{
+		Services: []bcp.Service{{Name: "micro"}, {Name: "mag"}},
+	}
+	paths := []string{
+		"/orgfs/data",
+		"/orgfs/data/srv/micro",
+		"/orgfs/data/srv/micro/ag-foo",
+		"/orgfs/data/srv/mag/ag-foo",
+		"/orgfs/data/srv/micro/ag-bar",
+		"/orgfs/data/org/ag-foo",
+		"/orgfs/data/org/ag-foo/people",
+		"/orgfs/data/org/ag-foo/micro",
+	}
+	show := func(orgUnit, service string) {
+		scope, _ := bcpscope.New(cfg, org, orgUnit, service)
+		fmt.Println(scope)
+		for _, p := range paths {
+			if scope.Selects(p) {
+				fmt.Println("   ", p)
+			}
+		}
+	}
+
+	// One "^..." to demonstrate automatic anchoring.
+	show("^ag-foo", "")
+	show("ag-foo|ag-baz", "micro")
+	show("", "micro")
+
+	_, err := bcpscope.New(cfg, org, "ag-(", "")
+	fmt.Println(err != nil)
+
+	// Output:
+	// orgUnit=~/^(?:ag-foo)$/
+	//     /orgfs/data/srv/micro/ag-foo
+	//     /orgfs/data/srv/mag/ag-foo
+	//     /orgfs/data/org/ag-foo
+	//     /orgfs/data/org/ag-foo/people
+	//     /orgfs/data/org/ag-foo/micro
+	// orgUnit=~/^(?:ag-foo|ag-baz)$/ and service=~/^(?:micro)$/
+	//     /orgfs/data/srv/micro/ag-foo
+	//     /orgfs/data/org/ag-foo/micro
+	// service=~/^(?:micro)$/
+	//     /orgfs/data/srv/micro
+	//     /orgfs/data/srv/micro/ag-foo
+	//     /orgfs/data/srv/micro/ag-bar
+	//     /orgfs/data/org/ag-foo/micro
+	// true
+}
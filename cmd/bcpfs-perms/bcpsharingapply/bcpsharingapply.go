@@ -1,6 +1,125 @@
 // `bcpsharingapply` applies NOE-9 BCPFS sharing to the filesystem.
 package bcpsharingapply
 
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsplan"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
 type Logger interface {
 	Info(string)
 }
+
+// `Options` control how sharing is applied.  `Groups` are used to map
+// filesystem group names to gids for the ACL entries.  A non-nil `Plan`
+// enables dry-run mode, which records operations in the plan instead of
+// modifying the filesystem.  `FS` is the filesystem; nil uses the real
+// filesystem `aclfs.OS`.  A non-nil `Scope` restricts changes to real shares,
+// traversal directories, and share trees that it selects.  By default, the
+// functions stop at the first error.  With `KeepGoing`, they record failed
+// paths, continue with the remaining paths, and return all failures as
+// `fsapply.Failures` at the end.
+type Options struct {
+	Groups    []grp.Group
+	Plan      *fsplan.Plan
+	FS        aclfs.FS
+	Scope     *bcpscope.Scope
+	KeepGoing bool
+}
+
+func (o *Options) isPlanning() bool {
+	return o != nil && o.Plan != nil
+}
+
+func (o *Options) fsys() aclfs.FS {
+	if o == nil || o.FS == nil {
+		return aclfs.OS{}
+	}
+	return o.FS
+}
+
+func (o *Options) selects(abspath string) bool {
+	return o == nil || o.Scope.Selects(abspath)
+}
+
+// `failures` records the failed paths of a sharing step with
+// `Options.KeepGoing`.
+type failures struct {
+	lg   Logger
+	opts *Options
+	list fsapply.Failures
+}
+
+func newFailures(lg Logger, opts *Options) *failures {
+	return &failures{lg: lg, opts: opts}
+}
+
+// `check()` returns `err` or, if `KeepGoing`, records a failure of `path` and
+// returns nil.
+func (f *failures) check(path string, err error) error {
+	if err == nil || f.opts == nil || !f.opts.KeepGoing {
+		return err
+	}
+	f.list = append(f.list, fsapply.Failure{Path: path, Err: err})
+	f.lg.Info(fmt.Sprintf("Failed `%s`: %v; continuing.", path, err))
+	return nil
+}
+
+// `err()` returns the recorded failures or nil.
+func (f *failures) err() error {
+	if len(f.list) == 0 {
+		return nil
+	}
+	return f.list
+}
+
+// `gid()` returns the gid of the filesystem group `name`.
+func (o *Options) gid(name string) (int, bool) {
+	if o == nil {
+		return 0, false
+	}
+	for _, g := range o.Groups {
+		if g.Name == name {
+			return g.Gid, true
+		}
+	}
+	return 0, false
+}
+
+// `namedGroupEntries()` maps the logical groups of `acl` to named group ACL
+// entries.
+func (o *Options) namedGroupEntries(
+	fs *bcpsharing.Bcpfs, acl bcpsharing.Acl,
+) (posixacl.ACL, error) {
+	entries := make(posixacl.ACL, 0, len(acl))
+	for _, ace := range acl {
+		name := fs.FsGroupOrgUnit(ace.Group)
+		gid, ok := o.gid(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown group `%s`", name)
+		}
+		perm, err := posixacl.ParsePerm(string(ace.Mode))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, posixacl.Entry{
+			Tag: posixacl.TagGroup, ID: gid, Perm: perm,
+		})
+	}
+	return entries, nil
+}
+
+func isDir(fsys aclfs.FS, path string) bool {
+	st, err := fsys.Stat(path)
+	if err != nil {
+		return false
+	}
+	return st.IsDir()
+}
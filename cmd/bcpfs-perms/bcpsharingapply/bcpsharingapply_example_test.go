@@ -0,0 +1,190 @@
+package bcpsharingapply_test
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharingapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+const rootdir = "/orgfs/data"
+
+// `config` shares two directories of `ag-foo` in `mic1` with `ag-bar`.
+const config = `
+rootdir = "/orgfs/data"
+serviceDir = "srv"
+orgUnitDir = "org"
+superGroup = "ag_org"
+orgUnitPrefix = "org"
+servicePrefix = "srv"
+opsSuffix = "ops"
+facilitySuffix = "facility"
+
+facility {
+    name = "lm"
+    services = [ "mic1" ]
+    access = "perService"
+}
+
+orgUnit {
+    name = "ag-foo"
+    subdirs = [ { name = "shared", policy = "manager" } ]
+}
+
+orgUnit {
+    name = "ag-bar"
+    subdirs = [ { name = "shared", policy = "manager" } ]
+}
+
+filter {
+    service = ".*"
+    orgUnit = "ag-.*"
+    action = "accept"
+}
+
+sharing {
+    namingPolicy { action = "allow", match = "ag-foo/mic1(/.*)?" }
+
+    export {
+        path = "ag-foo/mic1/data"
+        acl = [ "group:ag-bar:r-x" ]
+    }
+
+    export {
+        path = "ag-foo/mic1/data2"
+        acl = [ "group:ag-bar:r-x" ]
+    }
+
+    import { action = "accept", group = "ag-bar", match = "ag-foo/.*" }
+}
+`
+
+var groups = []grp.Group{
+	{Name: "org_ag-foo", Gid: 2001},
+	{Name: "org_ag-bar", Gid: 2004},
+}
+
+// `failingFS` fails to set ACLs or create symlinks for the paths in `fail`,
+// like immutable files.
+type failingFS struct {
+	*aclfs.MemFS
+	fail map[string]bool
+}
+
+func (fs failingFS) SetAccessACL(path string, acl posixacl.ACL) error {
+	if fs.fail[path] {
+		return &os.PathError{
+			Op: "setxattr", Path: path, Err: syscall.EPERM,
+		}
+	}
+	return fs.MemFS.SetAccessACL(path, acl)
+}
+
+func (fs failingFS) Symlink(target, path string) error {
+	if fs.fail[path] {
+		return &os.LinkError{
+			Op: "symlink", Old: target, New: path, Err: syscall.EPERM,
+		}
+	}
+	return fs.MemFS.Symlink(target, path)
+}
+
+type logger struct{}
+
+func (logger) Info(string) {}
+
+// `applySharing()` runs the three sharing steps and prints their errors.
+func applySharing(
+	sharing *bcpsharing.Sharing, opts *bcpsharingapply.Options,
+) {
+	lg := logger{}
+	printErr := func(err error) {
+		if fs, ok := err.(fsapply.Failures); ok {
+			for _, f := range fs {
+				fmt.Println("failed:", f.Path)
+			}
+			return
+		}
+		fmt.Println(err)
+	}
+	printErr(bcpsharingapply.EnsureRealShares(
+		lg, opts, sharing.Bcpfs, sharing.RealShares,
+	))
+	printErr(bcpsharingapply.EnsureTraversal(
+		lg, opts, sharing.Bcpfs, sharing.Traversal,
+	))
+	printErr(bcpsharingapply.EnsureShareTrees(
+		lg, opts, sharing.Bcpfs, sharing.ShareTrees,
+	))
+}
+
+func ExampleOptions_keepGoing() {
+	const (
+		data  = rootdir + "/srv/mic1/ag-foo/data"
+		data2 = rootdir + "/srv/mic1/ag-foo/data2"
+		tree  = rootdir + "/org/ag-bar/shared/ag-foo/mic1"
+	)
+	cfg, err := bcpcfg.Parse(config)
+	if err != nil {
+		panic(err)
+	}
+	sharing, err := bcpsharing.Compile(cfg)
+	if err != nil {
+		panic(err)
+	}
+	newFS := func() failingFS {
+		mem := aclfs.NewMemFS()
+		for _, d := range []string{
+			data + "/sub", data2,
+			rootdir + "/org/ag-foo/shared",
+			rootdir + "/org/ag-bar/shared",
+		} {
+			if err := mem.MkdirAll(d, 0755); err != nil {
+				panic(err)
+			}
+		}
+		return failingFS{
+			MemFS: mem,
+			fail: map[string]bool{
+				data + "/sub":  true,
+				tree + "/data": true,
+			},
+		}
+	}
+	shared := posixacl.MustParseFileACL("group:2004:r-x").Access[0]
+	isShared := func(fs aclfs.FS, path string) bool {
+		acl, _ := fs.GetACL(path)
+		return acl.Access.Has(shared)
+	}
+
+	// Each step stops at its first failing path.
+	fs := newFS()
+	applySharing(sharing, &bcpsharingapply.Options{Groups: groups, FS: fs})
+	fmt.Println(isShared(fs, data), isShared(fs, data2))
+
+	// With `KeepGoing`, each step continues with the remaining paths.
+	fs = newFS()
+	applySharing(sharing, &bcpsharingapply.Options{
+		Groups: groups, FS: fs, KeepGoing: true,
+	})
+	fmt.Println(isShared(fs, data), isShared(fs, data2))
+	fmt.Println(fs.Readlink(tree + "/data2"))
+
+	// Output:
+	// setxattr /orgfs/data/srv/mic1/ag-foo/data/sub: operation not permitted
+	// <nil>
+	// symlink ../../../../ag-foo/mic1/data /orgfs/data/org/ag-bar/shared/ag-foo/mic1/data: operation not permitted
+	// true false
+	// failed: /orgfs/data/srv/mic1/ag-foo/data/sub
+	// <nil>
+	// failed: /orgfs/data/org/ag-bar/shared/ag-foo/mic1/data
+	// true true
+	// ../../../../ag-foo/mic1/data2 <nil>
+}
@@ -2,171 +2,246 @@ package bcpsharingapply
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsplan"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
 )
 
-// `EnsureRealShares()` applies the filesystem ACLs for `realShares`.
+// `EnsureRealShares()` applies the filesystem ACLs for `realShares`.  Real
+// shares that do not exist on the filesystem are ignored.
 func EnsureRealShares(
 	lg Logger,
+	opts *Options,
 	fs *bcpsharing.Bcpfs,
 	realShares bcpsharing.RealExports,
 ) error {
-	root := fs.Rootdir
-	facls, err := getfaclDirPaths(root, realShares.Paths())
-	if err != nil {
-		return err
+	fsys := opts.fsys()
+	fails := newFailures(lg, opts)
+	for _, rs := range realShares {
+		abspath := filepath.Join(fs.Rootdir, rs.Path)
+		if !opts.selects(abspath) || !isDir(fsys, abspath) {
+			continue
+		}
+		err := ensureRealShare(lg, opts, fails, fs, rs, abspath)
+		if err := fails.check(abspath, err); err != nil {
+			return err
+		}
 	}
+	return fails.err()
+}
 
-	// Return early if none of the real shares exist on the filesystem.
-	if len(facls) == 0 {
-		return nil
+func ensureRealShare(
+	lg Logger,
+	opts *Options,
+	fails *failures,
+	fs *bcpsharing.Bcpfs,
+	rs bcpsharing.ExportEntry,
+	abspath string,
+) error {
+	actual, err := opts.fsys().GetACL(abspath)
+	if err != nil {
+		return err
 	}
 
-	faclsByPath := make(map[string]bcpsharing.Facl)
-	for _, facl := range facls {
-		faclsByPath[facl.Path] = facl.Acl
+	desired, err := opts.namedGroupEntries(fs, rs.Acl)
+	if err != nil {
+		return fmt.Errorf("real share `%s`: %v", rs.Path, err)
 	}
 
-	for _, rs := range realShares {
-		path := rs.Path
-		actual, ok := faclsByPath[path]
-		if !ok {
-			continue
-		}
-
-		desired := rs.Acl.AsFacl(fs)
-		additionalGroups := fs.FsGroups(rs.ManagingGroups)
-		if err := ensureFacl(
-			lg,
-			root, path,
-			actual, desired,
-			additionalGroups,
-		); err != nil {
-			return err
+	additionalGids := make([]int, 0)
+	for _, name := range fs.FsGroups(rs.ManagingGroups) {
+		if gid, ok := opts.gid(name); ok {
+			additionalGids = append(additionalGids, gid)
 		}
 	}
 
-	return nil
+	return ensureFacl(
+		lg, opts, fails,
+		abspath,
+		actual, desired,
+		additionalGids,
+	)
 }
 
 func ensureFacl(
 	lg Logger,
-	root, path string,
-	actual, desired bcpsharing.Facl,
-	additionalGroups []string,
+	opts *Options,
+	fails *failures,
+	abspath string,
+	actual posixacl.FileACL, desired posixacl.ACL,
+	additionalGids []int,
 ) error {
 	if faclNeedModify(actual, desired) {
-		modifyDirs := make([]string, 0, 2*len(desired))
-		modifyFiles := make([]string, 0, len(desired))
-		for _, ace := range desired {
-			str := ace.String()
-			modifyDirs = append(modifyDirs,
-				str,
-				"default:"+str,
-			)
-			modifyFiles = append(modifyFiles,
-				ace.WithoutX().String(),
-			)
+		modifyDirs := posixacl.FileACL{
+			Access: desired, Default: desired,
 		}
-
-		if err := setfaclDirSubdirModify(
-			root, path,
-			modifyDirs, modifyFiles,
-		); err != nil {
-			return err
+		modifyFiles := posixacl.FileACL{Access: withoutX(desired)}
+
+		if opts.isPlanning() {
+			opts.Plan.Add(fsplan.Op{
+				Action:    fsplan.ActionSetfacl,
+				Path:      abspath,
+				Modify:    modifyDirs.Strings(),
+				Recursive: true,
+			})
+		} else {
+			if err := modifyTree(
+				opts.fsys(), fails, abspath,
+				modifyDirs, modifyFiles,
+			); err != nil {
+				return err
+			}
+
+			lg.Info(fmt.Sprintf(
+				"Updated sharing ACL %s %s",
+				abspath, modifyDirs,
+			))
 		}
-
-		lg.Info(fmt.Sprintf(
-			"Updated sharing ACL %s/%s %s",
-			root, path,
-			strings.Join(modifyDirs, ","),
-		))
-
 	}
 
-	removeGroups := faclGroupsToRemove(actual, desired, additionalGroups)
-	if len(removeGroups) > 0 {
-		setfaclRm := make([]string, 0, 2*len(removeGroups))
-		for _, g := range removeGroups {
-			setfaclRm = append(setfaclRm,
-				"group:"+g,
-				"default:group:"+g,
-			)
+	removeGids := faclGroupsToRemove(actual, desired, additionalGids)
+	if len(removeGids) > 0 {
+		var rm posixacl.FileACL
+		for _, gid := range removeGids {
+			e := posixacl.Entry{Tag: posixacl.TagGroup, ID: gid}
+			rm.Access = append(rm.Access, e)
+			rm.Default = append(rm.Default, e)
 		}
 
-		if err := setfaclDirSubdirRemove(
-			root, path,
-			setfaclRm,
-		); err != nil {
-			return err
+		if opts.isPlanning() {
+			opts.Plan.Add(fsplan.Op{
+				Action:    fsplan.ActionSetfacl,
+				Path:      abspath,
+				Remove:    rm.Keys(),
+				Recursive: true,
+			})
+		} else {
+			if err := removeTree(
+				opts.fsys(), fails, abspath, rm,
+			); err != nil {
+				return err
+			}
+
+			lg.Info(fmt.Sprintf(
+				"Removed sharing ACL %s %s",
+				abspath, strings.Join(rm.Keys(), ","),
+			))
 		}
-
-		lg.Info(fmt.Sprintf(
-			"Removed sharing ACL %s/%s %s",
-			root, path,
-			strings.Join(setfaclRm, ","),
-		))
 	}
 
 	return nil
 }
 
-// `faclNeedModify(actual, desired)` returns true if the actual ACL contains
-// named group entries that differ from the desired named group entries.
-// `actual` is a full directory ACL as read from the filesystem.  `desired` is
-// an ACL that contains only ordinary named group entries.
-func faclNeedModify(
-	actual, desired bcpsharing.Facl,
-) bool {
-	have := make(map[string]struct{})
-	for _, ace := range actual.SelectNamedGroupEntries() {
-		have[ace.String()] = struct{}{}
-	}
-
-	for _, ace := range desired {
-		str := ace.String()
-
-		// Is the normal group ACE missing?
-		_, ok := have[str]
-		if !ok {
-			return true
-		}
-
-		// Is the default group ACE missing?
-		_, ok = have["default:"+str]
-		if !ok {
+// `faclNeedModify(actual, desired)` returns true if the actual ACL lacks
+// normal or default entries that are equal to the desired named group
+// entries.
+func faclNeedModify(actual posixacl.FileACL, desired posixacl.ACL) bool {
+	for _, e := range desired {
+		if !actual.Access.Has(e) || !actual.Default.Has(e) {
 			return true
 		}
 	}
-
 	return false
 }
 
-// `faclGroupsToRemove()` returns `actual` groups minus `desired` groups minus
-// `additionalGroups`.
+// `faclGroupsToRemove()` returns `actual` named group gids minus `desired`
+// gids minus `additionalGids`.
 func faclGroupsToRemove(
-	actual, desired bcpsharing.Facl,
-	additionalGroups []string,
-) []string {
-	gSet := make(map[string]struct{})
-	for _, ace := range actual.SelectNamedGroupEntries() {
-		gSet[ace.GroupName()] = struct{}{}
+	actual posixacl.FileACL, desired posixacl.ACL,
+	additionalGids []int,
+) []int {
+	gSet := make(map[int]struct{})
+	for _, e := range actual.Access.NamedGroups() {
+		gSet[e.ID] = struct{}{}
 	}
-	for _, ace := range desired.SelectNamedGroupEntries() {
-		delete(gSet, ace.GroupName())
+	for _, e := range actual.Default.NamedGroups() {
+		gSet[e.ID] = struct{}{}
 	}
-	for _, g := range additionalGroups {
-		delete(gSet, g)
+	for _, e := range desired.NamedGroups() {
+		delete(gSet, e.ID)
 	}
+	for _, gid := range additionalGids {
+		delete(gSet, gid)
+	}
+
+	gids := make([]int, 0, len(gSet))
+	for gid := range gSet {
+		gids = append(gids, gid)
+	}
+	sort.Ints(gids)
 
-	gs := make([]string, 0, len(gSet))
-	for g, _ := range gSet {
-		gs = append(gs, g)
+	return gids
+}
+
+func withoutX(acl posixacl.ACL) posixacl.ACL {
+	res := make(posixacl.ACL, 0, len(acl))
+	for _, e := range acl {
+		e.Perm &^= posixacl.PermExecute
+		res = append(res, e)
 	}
-	sort.Strings(gs)
+	return res
+}
 
-	return gs
+// `modifyTree()` modifies ACL entries of directories and regular files
+// recursively below `abspath` without recalculating the mask, like `setfacl
+// -n -m`.  Failed paths are recorded in `fails`; see `updateTree()`.
+func modifyTree(
+	fsys aclfs.FS, fails *failures,
+	abspath string, dirs, files posixacl.FileACL,
+) error {
+	return updateTree(fsys, fails, abspath, func(
+		actual posixacl.FileACL, isDir bool,
+	) posixacl.FileACL {
+		if isDir {
+			return actual.Modify(dirs)
+		}
+		return actual.Modify(files)
+	})
+}
+
+// `removeTree()` removes ACL entries of directories and regular files
+// recursively below `abspath`, like `setfacl -n -x`.  Failed paths are
+// recorded in `fails`; see `updateTree()`.
+func removeTree(
+	fsys aclfs.FS, fails *failures, abspath string, rm posixacl.FileACL,
+) error {
+	return updateTree(fsys, fails, abspath, func(
+		actual posixacl.FileACL, isDir bool,
+	) posixacl.FileACL {
+		return actual.Remove(rm)
+	})
+}
+
+// `updateTree()` walks directories and regular files below `abspath` and
+// writes the ACLs returned by `fn` if they differ.  Symlinks are not
+// followed.  With `KeepGoing`, failed paths are recorded in `fails`, and the
+// walk continues.
+func updateTree(
+	fsys aclfs.FS,
+	fails *failures,
+	abspath string,
+	fn func(actual posixacl.FileACL, isDir bool) posixacl.FileACL,
+) error {
+	walkFn := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return fails.check(path, err)
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+		actual, err := fsys.GetACL(path)
+		if err != nil {
+			return fails.check(path, err)
+		}
+		want := fn(actual, fi.IsDir())
+		err = aclfs.UpdateACL(fsys, path, actual, want)
+		return fails.check(path, err)
+	}
+	return aclfs.Walk(fsys, abspath, walkFn)
 }
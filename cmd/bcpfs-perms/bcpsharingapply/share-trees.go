@@ -7,24 +7,38 @@ import (
 	"sort"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsplan"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
 )
 
 // `EnsureShareTrees()` applies the shared tree to the filesystem.
 func EnsureShareTrees(
 	lg Logger,
+	opts *Options,
 	fs *bcpsharing.Bcpfs,
 	shareTrees bcpsharing.ShareTrees,
 ) error {
+	fails := newFailures(lg, opts)
 	for _, st := range shareTrees {
-		if err := ensureShareTree(lg, fs, st); err != nil {
+		err := ensureShareTree(lg, opts, fails, fs, st)
+		if err := fails.check(shareTreeRoot(fs, st), err); err != nil {
 			return err
 		}
 	}
-	return nil
+	return fails.err()
+}
+
+func shareTreeRoot(fs *bcpsharing.Bcpfs, tree bcpsharing.ShareTree) string {
+	return filepath.Join(fs.Rootdir, fs.OrgUnitDir, tree.OrgUnit, "shared")
 }
 
+// `ensureShareTree()` applies a single share tree.  Errors for paths below
+// the tree root are recorded in `fails` with `KeepGoing`; such paths are left
+// alone.
 func ensureShareTree(
 	lg Logger,
+	opts *Options,
+	fails *failures,
 	fs *bcpsharing.Bcpfs,
 	tree bcpsharing.ShareTree,
 ) error {
@@ -33,23 +47,26 @@ func ensureShareTree(
 		expected[f.Path] = f.Target
 	}
 	existing := make(map[string]struct{})
+	fsys := opts.fsys()
 
 	// Gather unexpected files in `rm` and expected existing files in
 	// `existing`.
 	var rm []string
-	treeRoot := filepath.Join(
-		fs.Rootdir, fs.OrgUnitDir, tree.OrgUnit, "shared",
-	)
+	treeRoot := shareTreeRoot(fs, tree)
+	if !opts.selects(treeRoot) {
+		return nil
+	}
 	rootLen := len(fs.Rootdir)
 	walkFn := func(path string, inf os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
 		if path == treeRoot {
-			return nil
+			return err
 		}
 
 		relpath := path[rootLen+1:]
+		if err != nil {
+			existing[relpath] = struct{}{}
+			return fails.check(path, err)
+		}
 		if target, ok := expected[relpath]; !ok {
 			rm = append(rm, path)
 		} else if target == "" {
@@ -62,9 +79,10 @@ func ensureShareTree(
 		} else {
 			// expect symlink
 			if inf.Mode()&os.ModeSymlink != 0 {
-				t, err := os.Readlink(path)
+				t, err := fsys.Readlink(path)
 				if err != nil {
-					return err
+					existing[relpath] = struct{}{}
+					return fails.check(path, err)
 				}
 				if t == target {
 					existing[relpath] = struct{}{}
@@ -78,15 +96,28 @@ func ensureShareTree(
 
 		return nil
 	}
-	if err := filepath.Walk(treeRoot, walkFn); err != nil {
-		return err
+	// During planning, a tree root that would be created is empty.
+	if !(opts.isPlanning() && opts.Plan.Creates(treeRoot)) {
+		if err := aclfs.Walk(fsys, treeRoot, walkFn); err != nil {
+			return err
+		}
 	}
 
 	// Remove unexpected files in depth first order.
 	sort.Sort(sort.Reverse(sort.StringSlice(rm)))
 	for _, f := range rm {
-		if err := os.Remove(f); err != nil {
-			return err
+		if opts.isPlanning() {
+			opts.Plan.Add(fsplan.Op{
+				Action: fsplan.ActionRemove,
+				Path:   f,
+			})
+			continue
+		}
+		if err := fsys.Remove(f); err != nil {
+			if err := fails.check(f, err); err != nil {
+				return err
+			}
+			continue
 		}
 		lg.Info(fmt.Sprintf(
 			"Removed unexpected sharing file %s", f,
@@ -100,16 +131,40 @@ func ensureShareTree(
 		}
 
 		path := filepath.Join(fs.Rootdir, f.Path)
+		if opts.isPlanning() {
+			if f.IsDir() {
+				opts.Plan.Add(fsplan.Op{
+					Action: fsplan.ActionMkdir,
+					Path:   path,
+				})
+			} else {
+				opts.Plan.Add(fsplan.Op{
+					Action: fsplan.ActionSymlink,
+					Path:   path,
+					Target: f.Target,
+				})
+			}
+			continue
+		}
+
 		if f.IsDir() {
-			if err := os.Mkdir(path, 0777); err != nil {
-				return err
+			err := fsys.Mkdir(path, 0777)
+			if err != nil {
+				if err := fails.check(path, err); err != nil {
+					return err
+				}
+				continue
 			}
 			lg.Info(fmt.Sprintf(
 				"Created sharing directory %s", path,
 			))
 		} else if f.IsSymlink() {
-			if err := os.Symlink(f.Target, path); err != nil {
-				return err
+			err := fsys.Symlink(f.Target, path)
+			if err != nil {
+				if err := fails.check(path, err); err != nil {
+					return err
+				}
+				continue
 			}
 			lg.Info(fmt.Sprintf(
 				"Created sharing symlink %s", path,
@@ -2,67 +2,87 @@ package bcpsharingapply
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsplan"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
 )
 
 // `EnsureTraversal()` adds traversal `--x` ACL entries to the filesystem.
+// Paths that do not exist on the filesystem are ignored.
 func EnsureTraversal(
 	lg Logger,
+	opts *Options,
 	fs *bcpsharing.Bcpfs,
 	traversal bcpsharing.RealExports,
 ) error {
-	root := fs.Rootdir
-	facls, err := getfaclDirPaths(root, traversal.Paths())
-	if err != nil {
-		return err
-	}
-
-	faclsByPath := make(map[string]bcpsharing.Facl)
-	for _, facl := range facls {
-		faclsByPath[facl.Path] = facl.Acl
-	}
-
-	// Gather paths to be modified by `<group>:--x`, so that all paths of a
-	// group can be modified with a single `xargs | setfacl`.
-	pathsByGroup := make(map[string][]string)
+	fsys := opts.fsys()
+	fails := newFailures(lg, opts)
 	for _, tr := range traversal {
-		path := tr.Path
-		actual, ok := faclsByPath[path]
-		if !ok {
+		abspath := filepath.Join(fs.Rootdir, tr.Path)
+		if !opts.selects(abspath) || !isDir(fsys, abspath) {
 			continue
 		}
-
-		actualAceStrs := make(map[string]struct{})
-		for _, ace := range actual.SelectNamedGroupNormalEntries() {
-			actualAceStrs[ace.String()] = struct{}{}
+		err := ensureTraversal(lg, opts, fs, tr, abspath)
+		if err := fails.check(abspath, err); err != nil {
+			return err
 		}
+	}
+	return fails.err()
+}
 
-		for _, ace := range tr.Acl {
-			fsGroup := fs.FsGroupOrgUnit(ace.Group)
-			aceStr := "group:" + fsGroup + ":--x"
-			if _, ok := actualAceStrs[aceStr]; !ok {
-				pathsByGroup[fsGroup] = append(
-					pathsByGroup[fsGroup], path,
-				)
-			}
-		}
+func ensureTraversal(
+	lg Logger,
+	opts *Options,
+	fs *bcpsharing.Bcpfs,
+	tr bcpsharing.ExportEntry,
+	abspath string,
+) error {
+	fsys := opts.fsys()
+	actual, err := fsys.GetACL(abspath)
+	if err != nil {
+		return err
 	}
 
-	for fsGroup, paths := range pathsByGroup {
-		if err := setfaclDirPathsTraversal(
-			root, paths, fsGroup,
-		); err != nil {
-			return err
+	// Only the gids matter.  Traversal uses `--x`.
+	entries, err := opts.namedGroupEntries(fs, tr.Acl)
+	if err != nil {
+		return fmt.Errorf("traversal `%s`: %v", tr.Path, err)
+	}
+	var add posixacl.FileACL
+	for _, e := range entries {
+		e.Perm = posixacl.PermExecute
+		if !actual.Access.Has(e) {
+			add.Access = append(add.Access, e)
 		}
+	}
+	if len(add.Access) == 0 {
+		return nil
+	}
+	add.Access = add.Access.Sorted()
 
-		for _, p := range paths {
-			lg.Info(fmt.Sprintf(
-				"Added sharing traversal ACL group %s/%s %s",
-				root, p, fsGroup,
-			))
-		}
+	if opts.isPlanning() {
+		opts.Plan.Add(fsplan.Op{
+			Action: fsplan.ActionSetfacl,
+			Path:   abspath,
+			Modify: add.Strings(),
+		})
+		return nil
+	}
+
+	if err := aclfs.UpdateACL(
+		fsys, abspath, actual, actual.Modify(add),
+	); err != nil {
+		return err
 	}
 
+	for _, e := range add.Access {
+		lg.Info(fmt.Sprintf(
+			"Added sharing traversal ACL group %s %d",
+			abspath, e.ID,
+		))
+	}
 	return nil
 }
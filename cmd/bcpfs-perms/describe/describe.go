@@ -1,7 +1,9 @@
 package describe
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
@@ -18,6 +20,16 @@ func MustDescribeConfig(cfg *bcpcfg.Root) string {
 	return string(d)
 }
 
+// `MustDescribeConfigSchema()` returns the JSON Schema `bcpcfg.Schema()` as
+// indented JSON.
+func MustDescribeConfigSchema() string {
+	d, err := json.MarshalIndent(bcpcfg.Schema(), "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("Failed to marshal: %v", err))
+	}
+	return string(d) + "\n"
+}
+
 func MustDescribeGroups(gs []grp.Group) string {
 	d, err := yaml.Marshal(&gs)
 	if err != nil {
@@ -33,3 +45,57 @@ func MustDescribeOrg(org *bcp.Organization) string {
 	}
 	return string(d)
 }
+
+// `memberList` is the YAML representation of `describe members`.
+type memberList struct {
+	OrgUnits []orgUnitMembers `yaml:"orgUnits"`
+	Services []serviceMembers `yaml:"services"`
+}
+
+type orgUnitMembers struct {
+	Name    string   `yaml:"name"`
+	Group   string   `yaml:"group"`
+	Members []string `yaml:"members"`
+}
+
+type serviceMembers struct {
+	Name       string   `yaml:"name"`
+	Group      string   `yaml:"group"`
+	Members    []string `yaml:"members"`
+	OpsGroup   string   `yaml:"opsGroup"`
+	OpsMembers []string `yaml:"opsMembers"`
+}
+
+// `MustDescribeMembers()` lists the members of the org unit groups and of the
+// service and ops groups, with members sorted by name.
+func MustDescribeMembers(org *bcp.Organization) string {
+	sorted := func(ms []string) []string {
+		s := append([]string{}, ms...)
+		sort.Strings(s)
+		return s
+	}
+
+	var lst memberList
+	for _, ou := range org.OrgUnits {
+		lst.OrgUnits = append(lst.OrgUnits, orgUnitMembers{
+			Name:    ou.Name,
+			Group:   ou.OrgUnitGroup.Name,
+			Members: sorted(ou.Members()),
+		})
+	}
+	for _, s := range org.Services {
+		lst.Services = append(lst.Services, serviceMembers{
+			Name:       s.Name,
+			Group:      s.ServiceGroup.Name,
+			Members:    sorted(s.Members()),
+			OpsGroup:   s.ServiceOpsGroup.Name,
+			OpsMembers: sorted(s.OpsMembers()),
+		})
+	}
+
+	d, err := yaml.Marshal(&lst)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to marshal: %v", err))
+	}
+	return string(d)
+}
@@ -16,20 +16,23 @@ var gs = []grp.Group{
 		Gid:  2,
 	},
 	grp.Group{
-		Name: "srv_lm-ops",
-		Gid:  3,
+		Name:    "srv_lm-ops",
+		Gid:     3,
+		Members: []string{"olaf"},
 	},
 	grp.Group{
-		Name: "srv_mic1",
-		Gid:  4,
+		Name:    "srv_mic1",
+		Gid:     4,
+		Members: []string{"bob", "alice"},
 	},
 	grp.Group{
 		Name: "srv_mic2",
 		Gid:  5,
 	},
 	grp.Group{
-		Name: "org_ag-foo",
-		Gid:  6,
+		Name:    "org_ag-foo",
+		Gid:     6,
+		Members: []string{"bob", "alice"},
 	},
 }
 
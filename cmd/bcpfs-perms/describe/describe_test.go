@@ -5,10 +5,13 @@ import (
 	"flag"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
 )
 
 var update = flag.Bool("update", false, "update .golden files")
@@ -33,6 +36,11 @@ func TestDescribeConfig(t *testing.T) {
 	compareToGoldenFile([]byte(got), testDataDir, t)
 }
 
+func TestDescribeConfigSchema(t *testing.T) {
+	got := MustDescribeConfigSchema()
+	compareToGoldenFile([]byte(got), testDataDir, t)
+}
+
 func TestDescribeOrgWithoutSuperGroup(t *testing.T) {
 	path := filepath.Join(testDataDir, configFile)
 	d, err := ioutil.ReadFile(path)
@@ -41,7 +49,7 @@ func TestDescribeOrgWithoutSuperGroup(t *testing.T) {
 		return
 	}
 	cfg, _ := bcpcfg.Parse(string(d))
-	org, _ := bcp.New(gs, cfg)
+	org, _, _ := bcp.New(gs, cfg)
 
 	got := MustDescribeOrg(org)
 	compareToGoldenFile([]byte(got), testDataDir, t)
@@ -56,12 +64,107 @@ func TestDescribeOrgWithSuperGroup(t *testing.T) {
 	}
 	cfg, _ := bcpcfg.Parse(string(d))
 	cfg.SuperGroup = "ag_org"
-	org, _ := bcp.New(gs, cfg)
+	org, _, _ := bcp.New(gs, cfg)
 
 	got := MustDescribeOrg(org)
 	compareToGoldenFile([]byte(got), testDataDir, t)
 }
 
+func TestDescribeMembers(t *testing.T) {
+	path := filepath.Join(testDataDir, configFile)
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cfg, _ := bcpcfg.Parse(string(d))
+	org, _, _ := bcp.New(gs, cfg)
+
+	got := MustDescribeMembers(org)
+	compareToGoldenFile([]byte(got), testDataDir, t)
+}
+
+func TestDescribeMatrixText(t *testing.T) {
+	testDescribeMatrix(t, "text")
+}
+
+func TestDescribeMatrixCSV(t *testing.T) {
+	testDescribeMatrix(t, "csv")
+}
+
+func testDescribeMatrix(t *testing.T, format string) {
+	path := filepath.Join(testDataDir, configFile)
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cfg, _ := bcpcfg.Parse(string(d))
+	org, _, _ := bcp.New(gs, cfg)
+	var deciders []bfilter.Decider
+	for _, decide := range cfg.Filter {
+		r, err := bfilter.NewRegexpDecider(decide)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deciders = append(deciders, r)
+	}
+	deciders = append(deciders, bfilter.NewSameFacilityDecider())
+	filter := &bfilter.DecidersFilter{Rules: deciders}
+
+	got := MustDescribeMatrix(org, filter, format)
+	compareToGoldenFile([]byte(got), testDataDir, t)
+}
+
+func TestDescribeConfigDiff(t *testing.T) {
+	path := filepath.Join(testDataDir, configFile)
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	state := func(cfgText string) *ConfigState {
+		cfg, err := bcpcfg.Parse(cfgText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		org, _, _ := bcp.New(gs, cfg)
+		var deciders []bfilter.Decider
+		for _, decide := range cfg.Filter {
+			r, err := bfilter.NewFilterRuleDecider(decide)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deciders = append(deciders, r)
+		}
+		deciders = append(deciders, bfilter.NewSameFacilityDecider())
+		filter := &bfilter.DecidersFilter{Rules: deciders}
+		st, err := NewConfigState(cfg, gs, org, filter, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	// The old config accepts `ag-foo`.  The new config rejects it, uses
+	// `allOrgUnits` for facility `lm`, moves the explicit symlink, and
+	// drops the extra dir `projects`, which does not lose access.
+	from := state(strings.Replace(string(d), `"ag_foo"`, `"ag-foo"`, 1))
+	to := strings.Replace(
+		string(d), `superGroup = ""`, `superGroup = "ag_org"`, 1,
+	)
+	to = strings.Replace(
+		to, `access = "perService"`, `access = "allOrgUnits"`, 1,
+	)
+	to = strings.Replace(
+		to, `path = "srv/mic1/guides"`, `path = "srv/mic2/guides"`, 1,
+	)
+	to = strings.Replace(to, `"projects",`, "", 1)
+
+	got := MustDescribeConfigDiff(DiffConfigStates(from, state(to)))
+	compareToGoldenFile([]byte(got), testDataDir, t)
+}
+
 func compareToGoldenFile(got []byte, testDataDir string, t *testing.T) {
 	golden := filepath.Join(testDataDir, t.Name()+".golden")
 	if *update {
@@ -77,3 +180,42 @@ func compareToGoldenFile(got []byte, testDataDir string, t *testing.T) {
 			t.Name(), got, expected)
 	}
 }
+
+func TestDescribeExpirations(t *testing.T) {
+	path := filepath.Join(testDataDir, configFile)
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cfg, _ := bcpcfg.Parse(string(d))
+	org, _, _ := bcp.New(gs, cfg)
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.Local)
+	rules := []bcpcfg.FilterRule{
+		{
+			Services:   []string{"mic1"},
+			OrgUnits:   []string{"ag-foo"},
+			Action:     "accept",
+			ValidUntil: "2019-12-31",
+		},
+		{
+			Services:   []string{"mic2"},
+			OrgUnits:   []string{"ag-foo"},
+			Action:     "accept",
+			ValidUntil: "2019-06-30",
+		},
+	}
+	var deciders []bfilter.Decider
+	for _, decide := range append(rules, cfg.Filter...) {
+		r, err := bfilter.NewFilterRuleDeciderAt(decide, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deciders = append(deciders, r)
+	}
+	deciders = append(deciders, bfilter.NewSameFacilityDecider())
+	filter := &bfilter.DecidersFilter{Rules: deciders}
+
+	got := MustDescribeExpirations(org, filter)
+	compareToGoldenFile([]byte(got), testDataDir, t)
+}
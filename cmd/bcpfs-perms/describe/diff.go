@@ -0,0 +1,348 @@
+package describe
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsck"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+)
+
+// Kinds of `Change`.
+const (
+	ChangeDir     = "dir"
+	ChangeSymlink = "symlink"
+	ChangeACL     = "acl"
+	ChangeShare   = "share"
+)
+
+// `Change` is a difference between the expected states of two configs.
+// `Added=false` indicates a removal.  `Path` is relative to the rootdir or,
+// for shares, a logical sharing path.  `Detail` is the symlink target, the
+// ACL entry, or the shared org unit and mode.  `LosesAccess=true` indicates
+// a removal that revokes access of an org unit: a removed service org unit
+// dir `<srv>/<ou>`, a removed symlink `<ou>/<srv>`, a removed or narrowed
+// named group ACL entry, or a removed or narrowed share.
+type Change struct {
+	Added       bool
+	Kind        string
+	Path        string
+	Detail      string
+	LosesAccess bool
+}
+
+// `ConfigState` is the expected filesystem state of a config, as determined
+// by `fsck.ExpectedEntries()`, and its compiled sharing.  See
+// `NewConfigState()`.  `access` contains the service org unit dirs and the
+// org unit service symlinks, whose removal revokes access of an org unit.
+type ConfigState struct {
+	dirs     map[string][]string
+	symlinks map[string]string
+	shares   map[string]map[string]bcpsharing.AceMode
+	access   map[string]bool
+}
+
+// `NewConfigState()` determines the expected state of a config with the Unix
+// groups `gs`, from which `org` has been created.  `sharing` may be nil if
+// the config has no sharing.  ACL entries use group names instead of gids.
+func NewConfigState(
+	cfg *bcpcfg.Root, gs []grp.Group, org *bcp.Organization,
+	filter bfilter.OrgServiceFilter,
+	sharing *bcpsharing.Sharing,
+) (*ConfigState, error) {
+	entries, err := fsck.ExpectedEntries(cfg, org, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(cfg.Rootdir)
+	if err != nil {
+		return nil, err
+	}
+	rel := func(path string) string {
+		r, err := filepath.Rel(root, path)
+		if err != nil {
+			return path
+		}
+		return r
+	}
+	names := make(map[string]string)
+	for _, g := range gs {
+		names[strconv.Itoa(g.Gid)] = g.Name
+	}
+
+	st := &ConfigState{
+		dirs:     make(map[string][]string),
+		symlinks: make(map[string]string),
+		shares:   make(map[string]map[string]bcpsharing.AceMode),
+		access:   make(map[string]bool),
+	}
+	for _, e := range entries {
+		// Expired dirs are kept without org unit access.
+		if e.OrgUnit != "" && e.Service != "" && !e.Optional {
+			st.access[rel(e.Path)] = true
+		}
+		if e.IsSymlink {
+			st.symlinks[rel(e.Path)] = e.LinkDest
+			continue
+		}
+		var acl []string
+		for _, line := range strings.Split(e.ACL.FACLString(), "\n") {
+			acl = append(acl, namedAclLine(line, names))
+		}
+		st.dirs[rel(e.Path)] = acl
+	}
+	for _, link := range cfg.Symlinks {
+		st.symlinks[filepath.Clean(link.Path)] = link.Target
+	}
+	if sharing != nil {
+		for _, exp := range sharing.Shares {
+			modes := make(map[string]bcpsharing.AceMode)
+			for _, ace := range exp.Acl {
+				modes[ace.Group] = ace.Mode
+			}
+			st.shares[exp.Path] = modes
+		}
+	}
+	return st, nil
+}
+
+// `namedAclLine()` replaces the gid in an ACL line, like `group:2001:rwx` or
+// `# group: 2001`, by the group name if it is known.
+func namedAclLine(line string, names map[string]string) string {
+	if strings.HasPrefix(line, "# group: ") {
+		gid := strings.TrimPrefix(line, "# group: ")
+		if name, ok := names[gid]; ok {
+			return "# group: " + name
+		}
+		return line
+	}
+	fields := strings.Split(line, ":")
+	i := 0
+	if fields[0] == "default" {
+		i = 1
+	}
+	if len(fields) != i+3 || fields[i] != "group" {
+		return line
+	}
+	if name, ok := names[fields[i+1]]; ok {
+		fields[i+1] = name
+	}
+	return strings.Join(fields, ":")
+}
+
+// `DiffConfigStates()` returns the changes from `from` to `to`, sorted by
+// path, with removals before additions.
+func DiffConfigStates(from, to *ConfigState) []Change {
+	var changes []Change
+	add := func(c Change) {
+		changes = append(changes, c)
+	}
+
+	for path, acl := range from.dirs {
+		newAcl, ok := to.dirs[path]
+		if !ok {
+			add(Change{
+				Kind:        ChangeDir,
+				Path:        path,
+				LosesAccess: from.access[path],
+			})
+			continue
+		}
+		changes = append(changes, diffAcl(path, acl, newAcl)...)
+	}
+	for path := range to.dirs {
+		if _, ok := from.dirs[path]; !ok {
+			add(Change{Added: true, Kind: ChangeDir, Path: path})
+		}
+	}
+
+	for path, target := range from.symlinks {
+		if t, ok := to.symlinks[path]; !ok || t != target {
+			add(Change{
+				Kind:        ChangeSymlink,
+				Path:        path,
+				Detail:      target,
+				LosesAccess: !ok && from.access[path],
+			})
+		}
+	}
+	for path, target := range to.symlinks {
+		if t, ok := from.symlinks[path]; !ok || t != target {
+			add(Change{
+				Added: true, Kind: ChangeSymlink,
+				Path: path, Detail: target,
+			})
+		}
+	}
+
+	shareDetail := func(group string, mode bcpsharing.AceMode) string {
+		return fmt.Sprintf("%s %s", group, mode)
+	}
+	for path, modes := range from.shares {
+		for group, mode := range modes {
+			newMode, ok := to.shares[path][group]
+			if ok && newMode == mode {
+				continue
+			}
+			covered := ok &&
+				modeCovers(string(newMode), string(mode))
+			add(Change{
+				Kind:        ChangeShare,
+				Path:        path,
+				Detail:      shareDetail(group, mode),
+				LosesAccess: !covered,
+			})
+		}
+	}
+	for path, modes := range to.shares {
+		for group, mode := range modes {
+			oldMode, ok := from.shares[path][group]
+			if ok && oldMode == mode {
+				continue
+			}
+			add(Change{
+				Added:  true,
+				Kind:   ChangeShare,
+				Path:   path,
+				Detail: shareDetail(group, mode),
+			})
+		}
+	}
+
+	kindOrder := map[string]int{
+		ChangeDir: 0, ChangeSymlink: 1, ChangeACL: 2, ChangeShare: 3,
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Kind != b.Kind {
+			return kindOrder[a.Kind] < kindOrder[b.Kind]
+		}
+		if a.Added != b.Added {
+			return !a.Added
+		}
+		return a.Detail < b.Detail
+	})
+	return changes
+}
+
+// `diffAcl()` compares the ACL lines of a dir.  A removed entry loses access
+// unless the new ACL has an entry for the same qualifier with at least the
+// same permissions.
+func diffAcl(path string, from, to []string) []Change {
+	has := func(lines []string) map[string]bool {
+		m := make(map[string]bool)
+		for _, l := range lines {
+			m[l] = true
+		}
+		return m
+	}
+	fromHas, toHas := has(from), has(to)
+
+	var changes []Change
+	for _, l := range from {
+		if toHas[l] {
+			continue
+		}
+		loses := aclLineGrants(l) && !aclLineCovered(l, to)
+		changes = append(changes, Change{
+			Kind:        ChangeACL,
+			Path:        path,
+			Detail:      l,
+			LosesAccess: loses,
+		})
+	}
+	for _, l := range to {
+		if fromHas[l] {
+			continue
+		}
+		changes = append(changes, Change{
+			Added: true, Kind: ChangeACL, Path: path, Detail: l,
+		})
+	}
+	return changes
+}
+
+// `aclLineGrants()` returns true for a named group entry, like
+// `group:org_ag-alice:rwx`, with permissions.  The owner, the owning group,
+// and the mask are reported as changes but are not considered access of an
+// org unit.
+func aclLineGrants(line string) bool {
+	fields := strings.Split(line, ":")
+	i := 0
+	if fields[0] == "default" {
+		i = 1
+	}
+	if len(fields) != i+3 || fields[i] != "group" || fields[i+1] == "" {
+		return false
+	}
+	return fields[i+2] != "---"
+}
+
+func aclLineCovered(line string, acl []string) bool {
+	k := strings.LastIndex(line, ":")
+	qualifier, perms := line[:k+1], line[k+1:]
+	for _, l := range acl {
+		if strings.HasPrefix(l, qualifier) &&
+			modeCovers(strings.TrimPrefix(l, qualifier), perms) {
+			return true
+		}
+	}
+	return false
+}
+
+// `modeCovers()` returns true if mode `a`, like `rwx`, grants at least the
+// permissions of mode `b`, like `r-x`.
+func modeCovers(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range b {
+		if b[i] != '-' && a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// `MustDescribeConfigDiff()` formats changes with one line per change, like
+// `- dir srv/ms-data/ag-bob  (loses access)`, followed by a summary.
+func MustDescribeConfigDiff(changes []Change) string {
+	if len(changes) == 0 {
+		return "No changes.\n"
+	}
+
+	var b strings.Builder
+	nLoses := 0
+	for _, c := range changes {
+		op := "-"
+		if c.Added {
+			op = "+"
+		}
+		fmt.Fprintf(&b, "%s %-7s %s", op, c.Kind, c.Path)
+		switch c.Kind {
+		case ChangeSymlink:
+			fmt.Fprintf(&b, " -> %s", c.Detail)
+		case ChangeACL, ChangeShare:
+			fmt.Fprintf(&b, ": %s", c.Detail)
+		}
+		if c.LosesAccess {
+			nLoses++
+			b.WriteString("  (loses access)")
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(
+		&b, "%d changes, %d lose access\n", len(changes), nLoses,
+	)
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package describe
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"gopkg.in/yaml.v2"
+)
+
+// `ExpirationActive` and `ExpirationExpired` are the `Status` of an
+// `expiration`.  Active combinations are accepted until `ValidUntil`.
+// Expired combinations are no longer accepted; their service dirs are kept
+// without org unit access.
+const (
+	ExpirationActive  = "active"
+	ExpirationExpired = "expired"
+)
+
+type expirationList struct {
+	Expirations []expiration `yaml:"expirations"`
+}
+
+type expiration struct {
+	Service    string `yaml:"service"`
+	OrgUnit    string `yaml:"orgUnit"`
+	ValidUntil string `yaml:"validUntil"`
+	Status     string `yaml:"status"`
+}
+
+// `MustDescribeExpirations()` lists the combinations of services and org units
+// that are accepted by filter rules with `validUntil` and the combinations
+// whose rules have expired, sorted by `validUntil`.
+func MustDescribeExpirations(
+	org *bcp.Organization, filter *bfilter.DecidersFilter,
+) string {
+	var lst expirationList
+	for _, s := range org.Services {
+		for _, ou := range org.OrgUnits {
+			if until := filter.Expiration(s, ou); until != "" {
+				lst.Expirations = append(lst.Expirations, expiration{
+					Service:    s.Name,
+					OrgUnit:    ou.Name,
+					ValidUntil: until,
+					Status:     ExpirationActive,
+				})
+				continue
+			}
+			if expired, until := filter.Expired(s, ou); expired {
+				lst.Expirations = append(lst.Expirations, expiration{
+					Service:    s.Name,
+					OrgUnit:    ou.Name,
+					ValidUntil: until,
+					Status:     ExpirationExpired,
+				})
+			}
+		}
+	}
+	sort.SliceStable(lst.Expirations, func(i, j int) bool {
+		return lst.Expirations[i].ValidUntil <
+			lst.Expirations[j].ValidUntil
+	})
+
+	d, err := yaml.Marshal(&lst)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to marshal: %v", err))
+	}
+	return string(d)
+}
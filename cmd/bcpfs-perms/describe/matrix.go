@@ -0,0 +1,67 @@
+package describe
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+)
+
+// `MustDescribeMatrix()` returns the filter decisions for all combinations of
+// services and org units as a table with one row per org unit and one column
+// per service.  `format` is `text` for an aligned table with `x` for accept
+// and `-` for reject, or `csv` with `accept` and `reject`.
+func MustDescribeMatrix(
+	org *bcp.Organization, filter bfilter.OrgServiceFilter, format string,
+) string {
+	header := []string{"orgUnit"}
+	for _, s := range org.Services {
+		header = append(header, s.Name)
+	}
+	rows := [][]string{header}
+	for _, ou := range org.OrgUnits {
+		row := []string{ou.Name}
+		for _, s := range org.Services {
+			ok, _ := filter.Accept(s, ou)
+			row = append(row, matrixCell(ok, format))
+		}
+		rows = append(rows, row)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "text":
+		w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		if err := w.Flush(); err != nil {
+			panic(fmt.Sprintf("Failed to format table: %v", err))
+		}
+	case "csv":
+		w := csv.NewWriter(&buf)
+		if err := w.WriteAll(rows); err != nil {
+			panic(fmt.Sprintf("Failed to format CSV: %v", err))
+		}
+	default:
+		panic(fmt.Sprintf("invalid matrix format `%s`", format))
+	}
+	return buf.String()
+}
+
+func matrixCell(ok bool, format string) string {
+	switch {
+	case format == "csv" && ok:
+		return "accept"
+	case format == "csv":
+		return "reject"
+	case ok:
+		return "x"
+	default:
+		return "-"
+	}
+}
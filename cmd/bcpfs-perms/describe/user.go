@@ -0,0 +1,209 @@
+package describe
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Access levels of `AccessEntry`.  `AccessBlocked` indicates that the ACL of
+// a path grants access but the user cannot traverse the service directory.
+const (
+	AccessRead    = "read"
+	AccessWrite   = "write"
+	AccessBlocked = "blocked"
+)
+
+// Mechanisms of `AccessEntry`, which describe why a group has access.
+const (
+	ViaOrgUnit    = "orgUnit"
+	ViaService    = "service"
+	ViaOps        = "ops"
+	ViaSuperGroup = "superGroup"
+	ViaSharing    = "sharing"
+)
+
+// `AccessEntry` describes that a user has `Access` to `Path` as a member of
+// `Group` through the mechanism `Via`.  `Note` explains blocked access.
+type AccessEntry struct {
+	Path   string `yaml:"path"`
+	Access string `yaml:"access"`
+	Group  string `yaml:"group"`
+	Via    string `yaml:"via"`
+	Note   string `yaml:"note,omitempty"`
+}
+
+// `UserAccess` is the YAML representation of `describe user`.
+type UserAccess struct {
+	User   string        `yaml:"user"`
+	Groups []string      `yaml:"groups"`
+	Paths  []AccessEntry `yaml:"paths"`
+}
+
+// `NewUserAccess()` determines the managed paths that `user` with the Unix
+// groups `groups` can read or write, based on the org, the filter, and the
+// optional `sharing`, which may be nil.  It evaluates the permissions that
+// `apply` sets, not the actual filesystem.  Files below the managed
+// directories may have different permissions.
+func NewUserAccess(
+	user string, groups []grp.Group,
+	cfg *bcpcfg.Root, org *bcp.Organization,
+	filter bfilter.OrgServiceFilter,
+	sharing *bcpsharing.Sharing,
+) *UserAccess {
+	ua := &UserAccess{User: user, Groups: make([]string, 0)}
+	isMember := make(map[string]bool)
+	for _, g := range groups {
+		isMember[g.Name] = true
+		ua.Groups = append(ua.Groups, g.Name)
+	}
+	add := func(path, access, group, via string) {
+		ua.Paths = append(ua.Paths, AccessEntry{
+			Path: path, Access: access, Group: group, Via: via,
+		})
+	}
+
+	// `blocked` records a path whose ACL grants access but whose service
+	// dir `srvDir` cannot be traversed without group `need`.
+	blocked := func(path, group, via, need, srvDir string) {
+		ua.Paths = append(ua.Paths, AccessEntry{
+			Path:   path,
+			Access: AccessBlocked,
+			Group:  group,
+			Via:    via,
+			Note: fmt.Sprintf(
+				"not a member of `%s`, which is required "+
+					"to traverse `%s`",
+				need, srvDir,
+			),
+		})
+	}
+
+	// `srvDir` is the traversal group of a `<srv>` dir.  `need` is the
+	// group that is missing if `group` is empty.
+	type srvDir struct {
+		path  string
+		group string
+		need  string
+	}
+	srvDirs := make(map[string]srvDir)
+
+	srvRoot := filepath.Join(cfg.Rootdir, cfg.ServiceDir)
+	for _, s := range org.Services {
+		sd := srvDir{
+			path: filepath.Join(srvRoot, s.Name),
+			need: s.ServiceGroup.Name,
+		}
+		var via string
+		switch {
+		case s.Access.IsAllOrgUnits():
+			sd.need = s.SuperGroup.Name
+			if isMember[s.SuperGroup.Name] {
+				sd.group, via = s.SuperGroup.Name, ViaSuperGroup
+			}
+		case isMember[s.ServiceGroup.Name]:
+			sd.group, via = s.ServiceGroup.Name, ViaService
+		case isMember[s.ServiceOpsGroup.Name]:
+			sd.group, via = s.ServiceOpsGroup.Name, ViaOps
+		}
+		srvDirs[s.Name] = sd
+		if sd.group != "" {
+			add(sd.path, AccessRead, sd.group, via)
+		}
+
+		for _, ou := range org.OrgUnits {
+			if ok, _ := filter.Accept(s, ou); !ok {
+				continue
+			}
+			var g string
+			switch {
+			case isMember[ou.OrgUnitGroup.Name]:
+				g, via = ou.OrgUnitGroup.Name, ViaOrgUnit
+			case isMember[s.ServiceOpsGroup.Name]:
+				g, via = s.ServiceOpsGroup.Name, ViaOps
+			default:
+				continue
+			}
+			path := filepath.Join(sd.path, ou.Name)
+			if sd.group == "" {
+				blocked(path, g, via, sd.need, sd.path)
+				continue
+			}
+			add(path, AccessWrite, g, via)
+		}
+	}
+
+	ouRoot := filepath.Join(cfg.Rootdir, cfg.OrgUnitDir)
+	for _, ou := range org.OrgUnits {
+		g := ou.OrgUnitGroup.Name
+		if !isMember[g] {
+			continue
+		}
+		ouDir := filepath.Join(ouRoot, ou.Name)
+		add(ouDir, AccessRead, g, ViaOrgUnit)
+		for _, d := range ou.Subdirs {
+			path := filepath.Join(ouDir, d.Name)
+			switch d.Policy {
+			case bcp.GroupPolicy, bcp.OwnerPolicy:
+				add(path, AccessWrite, g, ViaOrgUnit)
+			default:
+				add(path, AccessRead, g, ViaOrgUnit)
+			}
+			if d.MemberDirs {
+				add(
+					filepath.Join(path, user),
+					AccessWrite, g, ViaOrgUnit,
+				)
+			}
+		}
+	}
+
+	if sharing == nil {
+		return ua
+	}
+	// Sharing does not add traversal entries to `<srv>` dirs, so that
+	// shares below services require access to the service.
+	for _, rs := range sharing.RealShares {
+		path := filepath.Join(cfg.Rootdir, rs.Path)
+		var sd srvDir
+		if sharing.Bcpfs.IsServiceRealpath(rs.Path) {
+			parts := strings.Split(rs.Path, "/")
+			if len(parts) > 1 {
+				sd = srvDirs[parts[1]]
+			}
+		}
+		for _, ace := range rs.Acl {
+			g := sharing.Bcpfs.FsGroupOrgUnit(ace.Group)
+			if !isMember[g] {
+				continue
+			}
+			if sd.need != "" && sd.group == "" {
+				blocked(path, g, ViaSharing, sd.need, sd.path)
+				continue
+			}
+			access := AccessRead
+			if strings.Contains(string(ace.Mode), "w") {
+				access = AccessWrite
+			}
+			add(path, access, g, ViaSharing)
+		}
+	}
+	return ua
+}
+
+// `MustDescribeUser()` returns the YAML representation of `ua`.
+func MustDescribeUser(ua *UserAccess) string {
+	d, err := yaml.Marshal(ua)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to marshal: %v", err))
+	}
+	return string(d)
+}
@@ -0,0 +1,141 @@
+package describe
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+)
+
+const userAccessCfg = `
+rootdir = "/fsroot"
+serviceDir = "srv"
+orgUnitDir = "org"
+superGroup = "ag_org"
+orgUnitPrefix = "org"
+servicePrefix = "srv"
+opsSuffix = "ops"
+facilitySuffix = "facility"
+
+facility {
+    name = "em"
+    services = [ "tem-505" ]
+    access = "perService"
+}
+
+facility {
+    name = "lm"
+    services = [ "mic1" ]
+    access = "allOrgUnits"
+}
+
+orgUnit {
+    name = "ag-bob"
+    subdirs = [
+        { name = "people", policy = "owner", memberDirs = true },
+        { name = "shared", policy = "manager" },
+    ]
+}
+
+filter {
+    service = ".*"
+    orgUnit = "ag-.*"
+    action = "accept"
+}
+
+sharing {
+    namingPolicy { action = "allow", match = "ag-alice/tem-505(/.*)?" }
+
+    export {
+        path = "ag-alice/tem-505/foo"
+        acl = [ "group:ag-bob:r-x" ]
+    }
+
+    import { action = "accept", group = "ag-bob", match = "ag-alice/.*" }
+}
+`
+
+func ExampleNewUserAccess() {
+	gs := []grp.Group{
+		{Name: "ag_org", Gid: 1000, Members: []string{"alice"}},
+		{Name: "org_ag-alice", Gid: 1001, Members: []string{"alice"}},
+		{Name: "org_ag-bob", Gid: 1002, Members: []string{"bob"}},
+		{Name: "org_em-facility", Gid: 1003},
+		{Name: "org_lm-facility", Gid: 1004},
+		{Name: "srv_tem-505", Gid: 1011, Members: []string{"alice", "bob"}},
+		{Name: "srv_mic1", Gid: 1012},
+		{Name: "srv_em-ops", Gid: 1021},
+		{Name: "srv_lm-ops", Gid: 1022},
+	}
+	cfg, err := bcpcfg.Parse(userAccessCfg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	org, _, err := bcp.New(gs, cfg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var deciders []bfilter.Decider
+	for _, decide := range cfg.Filter {
+		r, _ := bfilter.NewRegexpDecider(decide)
+		deciders = append(deciders, r)
+	}
+	deciders = append(deciders, bfilter.NewSameFacilityDecider())
+	filter := &bfilter.DecidersFilter{Rules: deciders}
+	sharing, err := bcpsharing.Compile(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	ua := NewUserAccess(
+		"bob", grp.GroupsOfUser(gs, "bob", -1),
+		cfg, org, filter, sharing,
+	)
+	fmt.Print(MustDescribeUser(ua))
+
+	// Output:
+	// user: bob
+	// groups:
+	// - org_ag-bob
+	// - srv_tem-505
+	// paths:
+	// - path: /fsroot/srv/tem-505
+	//   access: read
+	//   group: srv_tem-505
+	//   via: service
+	// - path: /fsroot/srv/tem-505/ag-bob
+	//   access: write
+	//   group: org_ag-bob
+	//   via: orgUnit
+	// - path: /fsroot/srv/mic1/ag-bob
+	//   access: blocked
+	//   group: org_ag-bob
+	//   via: orgUnit
+	//   note: not a member of `ag_org`, which is required to traverse `/fsroot/srv/mic1`
+	// - path: /fsroot/org/ag-bob
+	//   access: read
+	//   group: org_ag-bob
+	//   via: orgUnit
+	// - path: /fsroot/org/ag-bob/people
+	//   access: write
+	//   group: org_ag-bob
+	//   via: orgUnit
+	// - path: /fsroot/org/ag-bob/people/bob
+	//   access: write
+	//   group: org_ag-bob
+	//   via: orgUnit
+	// - path: /fsroot/org/ag-bob/shared
+	//   access: read
+	//   group: org_ag-bob
+	//   via: orgUnit
+	// - path: /fsroot/srv/tem-505/ag-alice/foo
+	//   access: read
+	//   group: org_ag-bob
+	//   via: sharing
+}
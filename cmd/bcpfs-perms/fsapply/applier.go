@@ -0,0 +1,322 @@
+package fsapply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsplan"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+// `applier` applies `dirSpec`s, `treeSpec`s, and symlinks to the filesystem
+// `fs`.
+// It inspects the current state and only modifies what differs from the spec,
+// in order to avoid unnecessary ctime changes.  If `plan` is non-nil, it
+// records the operations in the plan instead of modifying the filesystem.
+// Paths that are not selected by `scope` are left alone.  Messages are
+// logged to `lg` or the package logger if `lg` is nil.  If `keepGoing`,
+// errors are recorded in `failures`, and processing continues; see `check()`.
+type applier struct {
+	fs        aclfs.FS
+	plan      *fsplan.Plan
+	scope     *bcpscope.Scope
+	lg        Logger
+	keepGoing bool
+	failures  Failures
+}
+
+func (a *applier) log() Logger {
+	if a.lg == nil {
+		return logger
+	}
+	return a.lg
+}
+
+func (a *applier) isPlanning() bool {
+	return a.plan != nil
+}
+
+// `do()` records `op` in the plan or runs `fn`.
+func (a *applier) do(op fsplan.Op, fn func() error) error {
+	if a.isPlanning() {
+		a.plan.Add(op)
+		return nil
+	}
+	return fn()
+}
+
+// `selects()` returns true if `path` is in scope.  It logs skipped paths.
+func (a *applier) selects(path string) bool {
+	if a.scope.Selects(path) {
+		return true
+	}
+	msg := fmt.Sprintf("Skipped out-of-scope `%s`.", path)
+	a.log().Debug(msg)
+	return false
+}
+
+// `dirIsMissing()` returns true if the path is missing.  It ignores errors; it
+// should be used for reporting.
+func (a *applier) dirIsMissing(path string) bool {
+	_, err := a.fs.Stat(path)
+	return os.IsNotExist(err)
+}
+
+// `isDestSymlink()` is `true` if `path` is a symlink to `dest`.  Errors are
+// reported as `false`.
+func (a *applier) isDestSymlink(dest string, path string) bool {
+	fi, err := a.fs.Lstat(path)
+	if err != nil {
+		return false
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	actual, err := a.fs.Readlink(path)
+	if err != nil {
+		return false
+	}
+	return (actual == dest)
+}
+
+// `ensureDir()` creates the directory if necessary and applies the `spec`.
+// It creates only a single directory level, so that a configuration that
+// points to a missing rootdir will fail.
+func (a *applier) ensureDir(spec dirSpec) error {
+	path := spec.Path
+	if !a.selects(path) {
+		return nil
+	}
+	fi, err := a.fs.Lstat(path)
+	if os.IsNotExist(err) {
+		if a.isPlanning() {
+			return a.planMissingDir(spec)
+		}
+		if err := a.fs.Mkdir(path, 0777); err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("Created `%s`.", path)
+		a.log().Info(msg)
+		fi, err = a.fs.Lstat(path)
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("`%s` is not a directory", path)
+	}
+
+	return a.ensurePerms(
+		path, fi, spec.Uid, spec.Gid, spec.Setgid,
+		spec.Modify, spec.Remove,
+	)
+}
+
+// `ensureTree()` applies the `spec` to the files below `spec.Path`.  The
+// toplevel directory itself is left unmodified.  Symlinks only get the owning
+// group.  If `a.keepGoing`, failed paths are recorded and skipped.
+func (a *applier) ensureTree(spec treeSpec) error {
+	if !a.selects(spec.Path) {
+		return nil
+	}
+	if a.isPlanning() && a.plan.Creates(spec.Path) {
+		return nil
+	}
+
+	walkFn := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return a.check(path, err)
+		}
+		if path == spec.Path {
+			return nil
+		}
+		return a.check(path, a.ensureTreePath(spec, path, fi))
+	}
+	return aclfs.Walk(a.fs, spec.Path, walkFn)
+}
+
+func (a *applier) ensureTreePath(
+	spec treeSpec, path string, fi os.FileInfo,
+) error {
+	switch {
+	case fi.IsDir():
+		return a.ensurePerms(
+			path, fi, -1, spec.Gid, true,
+			spec.DirAcl, posixacl.FileACL{},
+		)
+	case fi.Mode().IsRegular():
+		return a.ensurePerms(
+			path, fi, -1, spec.Gid, false,
+			spec.FileAcl, posixacl.FileACL{},
+		)
+	default:
+		return a.ensureOwner(path, fi, -1, spec.Gid)
+	}
+}
+
+// `ensurePerms()` sets the owner, see `ensureOwner()`, the SGID bit if
+// `setgid`, and modifies the ACLs if entries from `modify` are missing or
+// entries from `remove` are present.
+func (a *applier) ensurePerms(
+	path string, fi os.FileInfo,
+	uid, gid int, setgid bool,
+	modify, remove posixacl.FileACL,
+) error {
+	if err := a.ensureOwner(path, fi, uid, gid); err != nil {
+		return err
+	}
+
+	if setgid && fi.Mode()&os.ModeSetgid == 0 {
+		op := fsplan.Op{
+			Action: fsplan.ActionChmod, Path: path, Mode: "g+s",
+		}
+		mode := fi.Mode() &
+			(os.ModePerm | os.ModeSetuid | os.ModeSticky)
+		if err := a.do(op, func() error {
+			return a.fs.Chmod(path, mode|os.ModeSetgid)
+		}); err != nil {
+			return err
+		}
+	}
+
+	actual, err := a.fs.GetACL(path)
+	if err != nil {
+		return err
+	}
+	want := actual.Modify(modify).Remove(remove)
+	if want.Equal(actual) {
+		return nil
+	}
+	op := fsplan.Op{
+		Action: fsplan.ActionSetfacl,
+		Path:   path,
+		Modify: actual.Missing(modify).Strings(),
+		Remove: actual.Present(remove).Keys(),
+	}
+	return a.do(op, func() error {
+		return aclfs.UpdateACL(a.fs, path, actual, want)
+	})
+}
+
+// `ensureOwner()` changes the owner `uid` and group `gid` if necessary.  A
+// negative `uid` leaves the owner unmodified.  Symlinks are not followed.
+func (a *applier) ensureOwner(
+	path string, fi os.FileInfo, uid, gid int,
+) error {
+	curUid, curGid := aclfs.Owner(fi)
+	if (uid < 0 || curUid == uid) && curGid == gid {
+		return nil
+	}
+	owner := fmt.Sprintf("%d:%d", uid, gid)
+	if uid < 0 {
+		owner = fmt.Sprintf(":%d", gid)
+	}
+	op := fsplan.Op{Action: fsplan.ActionChown, Path: path, Owner: owner}
+	return a.do(op, func() error {
+		return a.fs.Lchown(path, uid, gid)
+	})
+}
+
+// `symlink()` creates a symlink.  `what` describes the kind of symlink for
+// logging.
+func (a *applier) symlink(dest, path, what string) error {
+	if !a.selects(path) {
+		return nil
+	}
+	if a.isPlanning() {
+		a.plan.Add(fsplan.Op{
+			Action: fsplan.ActionSymlink,
+			Path:   path,
+			Target: dest,
+		})
+		return nil
+	}
+	if err := a.fs.Symlink(dest, path); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("Created %s `%s`.", what, path)
+	a.log().Info(msg)
+	return nil
+}
+
+// `remove()` removes a file or an empty directory.  It returns `kept=true`
+// without error if `path` is a non-empty directory.
+func (a *applier) remove(path string) (kept bool, err error) {
+	if !a.selects(path) {
+		return false, nil
+	}
+	if a.isPlanning() {
+		if fi, err := a.fs.Lstat(path); err != nil {
+			return false, err
+		} else if fi.IsDir() {
+			children, err := a.fs.ReadDir(path)
+			if err != nil {
+				return false, err
+			}
+			if len(children) > 0 {
+				return true, nil
+			}
+		}
+		a.plan.Add(fsplan.Op{
+			Action: fsplan.ActionRemove,
+			Path:   path,
+		})
+		return false, nil
+	}
+
+	err = a.fs.Remove(path)
+	if err == nil {
+		msg := fmt.Sprintf("Removed `%s`.", path)
+		a.log().Info(msg)
+		return false, nil
+	}
+	if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.ENOTEMPTY {
+		return true, nil
+	}
+	return false, err
+}
+
+// `readDir()` lists a directory.  During planning, directories that would be
+// created are reported as empty.  Missing out-of-scope directories are
+// reported as empty, too.
+func (a *applier) readDir(path string) ([]os.FileInfo, error) {
+	if a.isPlanning() && a.plan.Creates(path) {
+		return nil, nil
+	}
+	if !a.scope.Selects(path) && a.dirIsMissing(path) {
+		return nil, nil
+	}
+	return a.fs.ReadDir(path)
+}
+
+// `planMissingDir()` records the operations to create and initialize a
+// directory that does not exist yet.
+func (a *applier) planMissingDir(spec dirSpec) error {
+	path := spec.Path
+	parent := filepath.Dir(path)
+	if !a.plan.Creates(parent) && a.dirIsMissing(parent) {
+		return fmt.Errorf("missing parent dir `%s`", parent)
+	}
+	a.plan.Add(fsplan.Op{Action: fsplan.ActionMkdir, Path: path})
+	a.plan.Add(fsplan.Op{
+		Action: fsplan.ActionChown,
+		Path:   path,
+		Owner:  fmt.Sprintf("%d:%d", spec.Uid, spec.Gid),
+	})
+	if spec.Setgid {
+		a.plan.Add(fsplan.Op{
+			Action: fsplan.ActionChmod, Path: path, Mode: "g+s",
+		})
+	}
+	a.plan.Add(fsplan.Op{
+		Action: fsplan.ActionSetfacl,
+		Path:   path,
+		Modify: spec.Modify.Strings(),
+		Remove: spec.Remove.Keys(),
+	})
+	return nil
+}
@@ -2,7 +2,6 @@ package fsapply
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -18,6 +17,9 @@ type OrgUnitTree struct {
 	orgUnits   []bcp.OrgUnit
 	filter     bfilter.OrgServiceFilter
 	recursive  bool
+	jobs       int
+	lookupUid  bcp.UidFunc
+	apply      *applier
 	err        error
 }
 
@@ -29,7 +31,9 @@ func (ot *OrgUnitTree) EnsureOrgUnitDirs() {
 	for _, o := range ot.orgUnits {
 		path := filepath.Join(ot.root, o.Name)
 		ouG := o.OrgUnitGroup
-		err := ensureOrgUnitDir(path, ouG.Gid)
+		err := ot.apply.check(
+			path, ot.apply.ensureDir(orgUnitSpec(path, ouG.Gid)),
+		)
 		if err != nil {
 			ot.err = fmt.Errorf(
 				"org unit dir `%s`: %v", o.Name, err,
@@ -39,57 +43,54 @@ func (ot *OrgUnitTree) EnsureOrgUnitDirs() {
 	}
 }
 
-func ensureOrgUnitDir(path string, gid int) (err error) {
-	if dirIsMissing(path) {
-		defer func() {
-			if err != nil {
-				return
-			}
-			msg := fmt.Sprintf("Created `%s`.", path)
-			logger.Info(msg)
-		}()
-	}
-	data := struct {
-		Path string
-		Gid  int
-	}{path, gid}
-	return runBash(ensureOrgUnitSh, data)
-}
-
 // `EnsureOrgUnitServiceLinks()` creates symlinks `/orgfs/org/<ou>/<service>`
-// from org units to services.  It leaves existing links alone.
+// from org units to services.  It leaves existing links alone.  The org units
+// are independent jobs, which run concurrently; see `runJobs()`.
 //
 // Symlinks point to the toplevel directories of a service if it is operated by
 // the facility.
 func (ot *OrgUnitTree) EnsureOrgUnitServiceLinks() {
+	if ot.err != nil {
+		return
+	}
+	var jobs []job
 	for _, ou := range ot.orgUnits {
-		ot.ensureOULinks(ou)
+		ou := ou
+		path := filepath.Join(ot.root, ou.Name)
+		jobs = append(jobs, job{path, func(a *applier) error {
+			return ot.ensureOULinks(a, ou)
+		}})
 	}
+	ot.err = ot.apply.runJobs(ot.jobs, jobs)
 }
 
-func (ot *OrgUnitTree) ensureOULinks(ou bcp.OrgUnit) {
+func (ot *OrgUnitTree) ensureOULinks(a *applier, ou bcp.OrgUnit) error {
 	logSkip := func(s bcp.Service, ou bcp.OrgUnit, reason string) {
 		msg := fmt.Sprintf(
 			"Skipped `service=%s orgUnit=%s`: %s",
 			s.Name, ou.Name, reason,
 		)
-		logger.Debug(msg)
+		a.log().Debug(msg)
 	}
 
 	expected := make(map[string]bool)
 	for _, s := range ot.services {
 		if ok, reason := ot.filter.Accept(s, ou); ok {
 			expected[s.Name] = true
-			ot.ensureOUSLn(ou, s)
+			if err := ot.ensureOUSLn(a, ou, s); err != nil {
+				return err
+			}
 		} else {
 			logSkip(s, ou, reason)
 		}
 	}
 
-	ot.rmUnexpectedLinks(ou, expected)
+	return ot.rmUnexpectedLinks(a, ou, expected)
 }
 
-func (ot *OrgUnitTree) ensureOUSLn(ou bcp.OrgUnit, s bcp.Service) {
+func (ot *OrgUnitTree) ensureOUSLn(
+	a *applier, ou bcp.OrgUnit, s bcp.Service,
+) error {
 	// Do not not use `ln -sf $dest $path`, since it is not atomic; see:
 	//
 	// ```
@@ -100,40 +101,30 @@ func (ot *OrgUnitTree) ensureOUSLn(ou bcp.OrgUnit, s bcp.Service) {
 		return ou.IsFacility && s.Facility == ou.Facility
 	}
 
-	if ot.err != nil {
-		return
-	}
 	path := filepath.Join(ot.root, ou.Name, s.Name)
 	dest := filepath.Join("../..", ot.serviceDir, s.Name)
 	if !serviceIsOfFacility(ou, s) {
 		dest = filepath.Join(dest, ou.Name)
 	}
-	if isDestSymlink(dest, path) {
-		return
+	if a.isDestSymlink(dest, path) {
+		return nil
 	}
-	if err := os.Symlink(dest, path); err != nil {
-		ot.err = fmt.Errorf(
+	if err := a.symlink(dest, path, "symlink"); err != nil {
+		return fmt.Errorf(
 			"failed to create service symlink `%s`: %v",
 			path, err,
 		)
-		return
 	}
-	msg := fmt.Sprintf("Created symlink `%s`.", path)
-	logger.Info(msg)
+	return nil
 }
 
 func (ot *OrgUnitTree) rmUnexpectedLinks(
-	ou bcp.OrgUnit, expected map[string]bool,
-) {
-	if ot.err != nil {
-		return
-	}
-
+	a *applier, ou bcp.OrgUnit, expected map[string]bool,
+) error {
 	ouDir := filepath.Join(ot.root, ou.Name)
-	children, err := ioutil.ReadDir(ouDir)
+	children, err := a.readDir(ouDir)
 	if err != nil {
-		ot.err = err
-		return
+		return err
 	}
 
 	for _, child := range children {
@@ -148,112 +139,118 @@ func (ot *OrgUnitTree) rmUnexpectedLinks(
 		}
 
 		path := filepath.Join(ouDir, name)
-		err := os.Remove(path)
-		if err != nil {
-			ot.err = err
-			return
+		if _, err := a.remove(path); err != nil {
+			return err
 		}
-		msg := fmt.Sprintf("Removed `%s`.", path)
-		logger.Info(msg)
 	}
+	return nil
 }
 
-// `isDestSymlink()` is `true` if `path` is a symlink to `dest`.  Errors are
-// reported as `false`.
-func isDestSymlink(dest string, path string) bool {
-	fi, err := os.Lstat(path)
-	if err != nil {
-		return false
-	}
-	if fi.Mode()&os.ModeSymlink == 0 {
-		return false
-	}
-	actual, err := os.Readlink(path)
-	if err != nil {
-		return false
-	}
-	return (actual == dest)
-}
-
-// `EnsureOrgUnitSubdirs` manages `/orgfs/org/<ou>/<dir>` directories.
+// `EnsureOrgUnitSubdirs` manages `/orgfs/org/<ou>/<dir>` directories and, if
+// enabled, the per-member directories below them.  Each directory is an
+// independent job; see `runJobs()`.
 func (ot *OrgUnitTree) EnsureOrgUnitSubdirs() {
-	ensureSubdir := func(o bcp.OrgUnit, d bcp.DirWithPolicy) {
-		if ot.err != nil {
-			return
-		}
+	ensureSubdir := func(
+		a *applier, o bcp.OrgUnit, d bcp.DirWithPolicy,
+	) error {
 		path := filepath.Join(ot.root, o.Name, d.Name)
 		ouG := o.OrgUnitGroup
-		err := ensureOrgUnitSubdir(path, ouG.Gid, d.Policy)
+		err := a.ensureDir(
+			orgUnitSubdirSpec(path, ouG.Gid, d.Policy),
+		)
 		if err != nil {
-			ot.err = fmt.Errorf(
+			return fmt.Errorf(
 				"org unit dir `%s`: %v", o.Name, err,
 			)
-			return
+		}
+		if d.MemberDirs {
+			if err := ot.ensureMemberDirs(a, o, path); err != nil {
+				return fmt.Errorf(
+					"org unit dir `%s`: %v", o.Name, err,
+				)
+			}
 		}
 		if ot.recursive {
-			err := ensureOrgUnitSubdirRecursive(
-				path, ouG.Gid, d.Policy,
+			err := a.ensureTree(
+				orgUnitSubdirTreeSpec(path, ouG.Gid, d.Policy),
 			)
 			if err != nil {
-				ot.err = fmt.Errorf(
+				return fmt.Errorf(
 					"org unit dir `%s`: %v", o.Name, err,
 				)
-				return
 			}
 		}
+		return nil
 	}
 
+	if ot.err != nil {
+		return
+	}
+	var jobs []job
 	for _, o := range ot.orgUnits {
 		for _, d := range o.Subdirs {
-			ensureSubdir(o, d)
+			o, d := o, d
+			path := filepath.Join(ot.root, o.Name, d.Name)
+			jobs = append(jobs, job{path, func(a *applier) error {
+				return ensureSubdir(a, o, d)
+			}})
 		}
 	}
+	ot.err = ot.apply.runJobs(ot.jobs, jobs)
 }
 
-func ensureOrgUnitSubdir(
-	path string, gid int, policy bcp.DirPolicy,
-) (err error) {
-	if dirIsMissing(path) {
-		defer func() {
-			if err != nil {
-				return
-			}
-			msg := fmt.Sprintf("Created `%s`.", path)
-			logger.Info(msg)
-		}()
+// `ensureMemberDirs()` creates `<subdir>/<user>` for the members of the org
+// unit group if it is missing.  Existing member dirs belong to the member and
+// are not modified.  Directories of other users, like users who left the
+// group, are reported but kept.  Directories whose name is not a user name,
+// like `project-x`, are ignored.
+func (ot *OrgUnitTree) ensureMemberDirs(
+	a *applier, o bcp.OrgUnit, subdir string,
+) error {
+	lookupUid := ot.lookupUid
+	if lookupUid == nil {
+		lookupUid = bcp.LookupUid
 	}
-
-	data := struct {
-		Path string
-		Gid  int
-	}{path, gid}
-	switch policy {
-	case bcp.GroupPolicy:
-		return runBash(ensureOrgUnitGroupSubdirSh, data)
-	case bcp.OwnerPolicy:
-		return runBash(ensureOrgUnitOwnerSubdirSh, data)
-	case bcp.ManagerPolicy:
-		return runBash(ensureOrgUnitManagerSubdirSh, data)
-	default:
-		panic(fmt.Sprintf("unsupported dir policy `%s`", policy))
+	dirs, unknown := o.MemberDirs(lookupUid)
+	expected := make(map[string]bool)
+	for _, u := range unknown {
+		expected[u] = true
+		msg := fmt.Sprintf(
+			"Skipped member dir in `%s` of unknown user `%s`.",
+			subdir, u,
+		)
+		a.log().Info(msg)
+	}
+	for _, d := range dirs {
+		expected[d.User] = true
+		path := filepath.Join(subdir, d.User)
+		if _, err := a.fs.Lstat(path); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		spec := memberDirSpec(path, d.Uid, o.OrgUnitGroup.Gid)
+		if err := a.ensureDir(spec); err != nil {
+			return err
+		}
 	}
-}
 
-func ensureOrgUnitSubdirRecursive(
-	path string, gid int, policy bcp.DirPolicy,
-) (err error) {
-	data := struct {
-		Path string
-		Gid  int
-	}{path, gid}
-	switch policy {
-	case bcp.GroupPolicy:
-		return runBash(ensureOrgUnitGroupSubdirRecursiveSh, data)
-	case bcp.OwnerPolicy:
-		return runBash(ensureOrgUnitOwnerSubdirRecursiveSh, data)
-	case bcp.ManagerPolicy:
-		return runBash(ensureOrgUnitManagerSubdirRecursiveSh, data)
-	default:
-		panic(fmt.Sprintf("unsupported dir policy `%s`", policy))
+	children, err := a.readDir(subdir)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if !child.IsDir() || expected[child.Name()] {
+			continue
+		}
+		if _, err := lookupUid(child.Name()); err != nil {
+			continue
+		}
+		msg := fmt.Sprintf(
+			"Kept member dir `%s` of non-member `%s`.",
+			filepath.Join(subdir, child.Name()), child.Name(),
+		)
+		a.log().Info(msg)
 	}
+	return nil
 }
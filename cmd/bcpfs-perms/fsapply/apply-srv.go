@@ -2,10 +2,8 @@ package fsapply
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"syscall"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
 	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
@@ -18,6 +16,8 @@ type ServiceTree struct {
 	orgUnits  []bcp.OrgUnit
 	filter    bfilter.OrgServiceFilter
 	recursive bool
+	jobs      int
+	apply     *applier
 	err       error
 }
 
@@ -32,9 +32,9 @@ func (st *ServiceTree) EnsureServiceDirs() {
 		opsG := s.ServiceOpsGroup
 		superG := s.SuperGroup
 		access := s.Access
-		err := ensureServiceDir(
+		err := st.apply.check(path, st.apply.ensureDir(serviceSpec(
 			path, srvG.Gid, opsG.Gid, superG.Gid, access,
-		)
+		)))
 		if err != nil {
 			st.err = fmt.Errorf(
 				"service dir `%s`: %v", s.Name, err,
@@ -45,83 +45,122 @@ func (st *ServiceTree) EnsureServiceDirs() {
 }
 
 // `EnsureServiceOrgUnitDirs()` manages the `/orgfs/srv/<service>/<ou>` dirs.
+// Each dir and the removal of unexpected dirs of a service are independent
+// jobs, which run concurrently; see `runJobs()`.
 func (st *ServiceTree) EnsureServiceOrgUnitDirs() {
-	for _, s := range st.services {
-		st.ensureSrvSubdirs(s)
-	}
-}
-
-func (st *ServiceTree) ensureSrvSubdirs(s bcp.Service) {
 	if st.err != nil {
 		return
 	}
+	var jobs []job
+	for _, s := range st.services {
+		jobs = append(jobs, st.srvSubdirsJobs(s)...)
+	}
+	st.err = st.apply.runJobs(st.jobs, jobs)
+}
 
-	logSkip := func(s bcp.Service, ou bcp.OrgUnit, reason string) {
+func (st *ServiceTree) srvSubdirsJobs(s bcp.Service) []job {
+	logSkip := func(
+		a *applier, s bcp.Service, ou bcp.OrgUnit, reason string,
+	) {
 		msg := fmt.Sprintf(
 			"Skipped `service=%s orgUnit=%s`: %s",
 			s.Name, ou.Name, reason,
 		)
-		logger.Debug(msg)
+		a.log().Debug(msg)
 	}
 
+	var jobs []job
 	expected := make(map[string]bool)
 	for _, ou := range st.orgUnits {
-		if ok, reason := st.filter.Accept(s, ou); ok {
+		ou := ou
+		ok, reason := st.filter.Accept(s, ou)
+		expired, validUntil := false, ""
+		if ok {
 			expected[ou.Name] = true
-			st.ensureSOU(s, ou)
 		} else {
-			logSkip(s, ou, reason)
+			expired, validUntil = bfilter.IsExpired(st.filter, s, ou)
 		}
+		if expired {
+			// Keep the data; `ensureExpiredSOU()` revokes access.
+			expected[ou.Name] = true
+		}
+		path := filepath.Join(st.root, s.Name, ou.Name)
+		jobs = append(jobs, job{path, func(a *applier) error {
+			if expired {
+				return st.ensureExpiredSOU(a, s, ou, validUntil)
+			}
+			if !ok {
+				logSkip(a, s, ou, reason)
+				return nil
+			}
+			return st.ensureSOU(a, s, ou)
+		}})
 	}
 
-	st.rmUnexpectedSubdirs(s, expected)
+	srvDir := filepath.Join(st.root, s.Name)
+	jobs = append(jobs, job{srvDir, func(a *applier) error {
+		return st.rmUnexpectedSubdirs(a, s, expected)
+	}})
+	return jobs
 }
 
-func (st *ServiceTree) ensureSOU(s bcp.Service, ou bcp.OrgUnit) {
-	if st.err != nil {
-		return
-	}
+func (st *ServiceTree) ensureSOU(
+	a *applier, s bcp.Service, ou bcp.OrgUnit,
+) error {
 	path := filepath.Join(st.root, s.Name, ou.Name)
-	wasMissing := dirIsMissing(path)
 	ouG := ou.OrgUnitGroup
 	srvG := s.ServiceGroup
 	opsG := s.ServiceOpsGroup
 	superG := s.SuperGroup
-	data := struct {
-		Path     string
-		Gid      int
-		SrvGid   int
-		OpsGid   int
-		SuperGid int
-	}{path, ouG.Gid, srvG.Gid, opsG.Gid, superG.Gid}
-	if err := runBash(ensureSOUSh, data); err != nil {
-		st.err = err
-		return
-	}
-	if wasMissing {
-		msg := fmt.Sprintf("Created `%s`.", path)
-		logger.Info(msg)
+	if err := a.ensureDir(serviceOrgUnitSpec(
+		path, ouG.Gid, srvG.Gid, opsG.Gid, superG.Gid,
+	)); err != nil {
+		return err
 	}
 	if st.recursive {
-		if err := runBash(ensureSOURecursiveSh, data); err != nil {
-			st.err = err
-			return
+		if err := a.ensureTree(serviceOrgUnitTreeSpec(
+			path, ouG.Gid, opsG.Gid,
+		)); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func (st *ServiceTree) rmUnexpectedSubdirs(
-	s bcp.Service, expected map[string]bool,
-) {
-	if st.err != nil {
-		return
-	}
+// `ensureExpiredSOU()` revokes the org unit access to an existing
+// `/orgfs/srv/<service>/<ou>` dir whose filter rule has expired.  The dir and
+// its content are kept, so that access can be restored by extending the rule.
+// Missing dirs are not created.
+func (st *ServiceTree) ensureExpiredSOU(
+	a *applier, s bcp.Service, ou bcp.OrgUnit, validUntil string,
+) error {
+	path := filepath.Join(st.root, s.Name, ou.Name)
+	if a.dirIsMissing(path) {
+		msg := fmt.Sprintf(
+			"Skipped `service=%s orgUnit=%s`: expired after %s",
+			s.Name, ou.Name, validUntil,
+		)
+		a.log().Debug(msg)
+		return nil
+	}
+	msg := fmt.Sprintf(
+		"Revoking access to `%s`, which expired after %s.",
+		path, validUntil,
+	)
+	a.log().Debug(msg)
+	return a.ensureDir(expiredServiceOrgUnitSpec(
+		path, ou.OrgUnitGroup.Gid,
+		s.ServiceGroup.Gid, s.ServiceOpsGroup.Gid, s.SuperGroup.Gid,
+	))
+}
 
+func (st *ServiceTree) rmUnexpectedSubdirs(
+	a *applier, s bcp.Service, expected map[string]bool,
+) error {
 	srvDir := filepath.Join(st.root, s.Name)
-	children, err := ioutil.ReadDir(srvDir)
+	children, err := a.readDir(srvDir)
 	if err != nil {
-		st.err = err
-		return
+		return err
 	}
 
 	for _, child := range children {
@@ -138,47 +177,16 @@ func (st *ServiceTree) rmUnexpectedSubdirs(
 		// Non-empty directories as logged as info.  Other
 		// errors stop processing.
 		path := filepath.Join(srvDir, name)
-		err := os.Remove(path)
-		if err == nil {
-			msg := fmt.Sprintf("Removed `%s`.", path)
-			logger.Info(msg)
-			continue
+		kept, err := a.remove(path)
+		if err != nil {
+			return err
 		}
-		if err.(*os.PathError).Err != syscall.ENOTEMPTY {
-			st.err = err
-			return
+		if kept {
+			msg := fmt.Sprintf(
+				"Kept unexpected directory `%s`.", path,
+			)
+			a.log().Info(msg)
 		}
-		msg := fmt.Sprintf(
-			"Kept unexpected directory `%s`.", path,
-		)
-		logger.Info(msg)
-	}
-}
-
-func ensureServiceDir(
-	path string, gid int, opsGid int, superGid int, access bcp.AccessPolicy,
-) (err error) {
-	if dirIsMissing(path) {
-		defer func() {
-			if err != nil {
-				return
-			}
-			msg := fmt.Sprintf("Created `%s`.", path)
-			logger.Info(msg)
-		}()
-	}
-	data := struct {
-		Path     string
-		Gid      int
-		OpsGid   int
-		SuperGid int
-	}{path, gid, opsGid, superGid}
-
-	if access.IsAllOrgUnits() {
-		return runBash(ensureServiceAllOrgUnitsSh, data)
-	}
-	if access.IsPerService() {
-		return runBash(ensureServiceSh, data)
 	}
-	panic("Invalid Access policy")
+	return nil
 }
@@ -1,21 +1,41 @@
 /*
 Package `fsapply` provides `EnsurePermissions()` to create directories with the
 expected permissions.
+
+If `Options.Plan` is set, `EnsurePermissions()` does not modify the filesystem
+but records the necessary operations in the plan; see package `fsplan`.
 */
 package fsapply
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
 	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsplan"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
 )
 
+// `Options` control `EnsurePermissions()`.  `Recursive` applies permissions
+// to sub-directories.  A non-nil `Plan` enables dry-run mode, which records
+// operations in the plan instead of modifying the filesystem.  `FS` is the
+// filesystem; nil uses the real filesystem `aclfs.OS`.  A non-nil `Scope`
+// restricts changes to the selected paths.  `Jobs` limits the number of
+// service and org unit subtrees that are processed concurrently; values less
+// than 2 process them serially.  `KeepGoing` continues after errors; see
+// `EnsurePermissions()`.  `LookupUid` determines the owners of per-member
+// directories; nil uses `bcp.LookupUid()`.
 type Options struct {
 	Recursive bool
+	Plan      *fsplan.Plan
+	FS        aclfs.FS
+	Scope     *bcpscope.Scope
+	Jobs      int
+	KeepGoing bool
+	LookupUid bcp.UidFunc
 }
 
 // `EnsurePermissions()` iterates over the toplevel directories, creating
@@ -23,6 +43,10 @@ type Options struct {
 // only created if they pass the `filter`.
 //
 // It delegates to `ServiceTree` and `OrgUnitDir` for the respective subtrees.
+//
+// By default, it stops at the first error.  With `opts.KeepGoing`, it records
+// the error for the failed path, continues with the remaining paths, and
+// returns all failures as `Failures` at the end.
 func EnsurePermissions(
 	cfg *bcpcfg.Root,
 	org *bcp.Organization,
@@ -35,8 +59,19 @@ func EnsurePermissions(
 	}
 	serviceRoot := filepath.Join(root, cfg.ServiceDir)
 	orgUnitRoot := filepath.Join(root, cfg.OrgUnitDir)
+	apply := &applier{
+		fs:        opts.FS,
+		plan:      opts.Plan,
+		scope:     opts.Scope,
+		keepGoing: opts.KeepGoing,
+	}
+	if apply.fs == nil {
+		apply.fs = aclfs.OS{}
+	}
 
-	if err := ensureRootDir(serviceRoot); err != nil {
+	if err := apply.check(
+		serviceRoot, apply.ensureDir(toplevelSpec(serviceRoot)),
+	); err != nil {
 		return fmt.Errorf("dir `%s`: %v", serviceRoot, err)
 	}
 
@@ -46,6 +81,8 @@ func EnsurePermissions(
 		orgUnits:  org.OrgUnits,
 		filter:    filter,
 		recursive: opts.Recursive,
+		jobs:      opts.Jobs,
+		apply:     apply,
 	}
 	sTree.EnsureServiceDirs()
 	sTree.EnsureServiceOrgUnitDirs()
@@ -53,7 +90,9 @@ func EnsurePermissions(
 		return fmt.Errorf("service dirs: %v", err)
 	}
 
-	if err := ensureRootDir(orgUnitRoot); err != nil {
+	if err := apply.check(
+		orgUnitRoot, apply.ensureDir(toplevelSpec(orgUnitRoot)),
+	); err != nil {
 		return fmt.Errorf("dir `%s`: %v", orgUnitRoot, err)
 	}
 	ouTree := OrgUnitTree{
@@ -63,6 +102,9 @@ func EnsurePermissions(
 		orgUnits:   org.OrgUnits,
 		filter:     filter,
 		recursive:  opts.Recursive,
+		jobs:       opts.Jobs,
+		lookupUid:  opts.LookupUid,
+		apply:      apply,
 	}
 	ouTree.EnsureOrgUnitDirs()
 	ouTree.EnsureOrgUnitServiceLinks()
@@ -72,53 +114,29 @@ func EnsurePermissions(
 	}
 
 	for _, link := range cfg.Symlinks {
-		if err := ensureSymlink(
-			link.Target, filepath.Join(root, link.Path),
+		path := filepath.Join(root, link.Path)
+		if err := apply.check(
+			path, ensureSymlink(apply, link.Target, path),
 		); err != nil {
 			return fmt.Errorf("symlink: %v", err)
 		}
 	}
 
-	return nil
-}
-
-func ensureRootDir(path string) (err error) {
-	if dirIsMissing(path) {
-		defer func() {
-			if err != nil {
-				return
-			}
-			msg := fmt.Sprintf("Created `%s`.", path)
-			logger.Info(msg)
-		}()
-	}
-	return runBash(ensureToplevelSh, struct{ Path string }{path})
-}
-
-// `dirIsMissing()` returns true if the path is missing.  It ignores errors; it
-// should be used for reporting.
-func dirIsMissing(path string) bool {
-	_, err := os.Stat(path)
-	if err == nil {
-		return false
-	}
-	if os.IsNotExist(err) {
-		return true
+	if len(apply.failures) > 0 {
+		return apply.failures
 	}
-	return false
+	return nil
 }
 
-func ensureSymlink(dest, path string) error {
-	if isDestSymlink(dest, path) {
+func ensureSymlink(apply *applier, dest, path string) error {
+	if apply.isDestSymlink(dest, path) {
 		return nil
 	}
-	if err := os.Symlink(dest, path); err != nil {
+	if err := apply.symlink(dest, path, "explicit symlink"); err != nil {
 		return fmt.Errorf(
 			"failed to create explicit symlink `%s`: %v",
 			path, err,
 		)
 	}
-	msg := fmt.Sprintf("Created explicit symlink `%s`.", path)
-	logger.Info(msg)
 	return nil
 }
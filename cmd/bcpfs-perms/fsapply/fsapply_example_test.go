@@ -0,0 +1,298 @@
+package fsapply_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsplan"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+)
+
+const rootdir = "/orgfs/data"
+
+// `config` is a minimal config with one service and one lab.  The examples
+// append further org units.
+const config = `
+rootdir = "/orgfs/data"
+serviceDir = "srv"
+orgUnitDir = "org"
+superGroup = "ag_org"
+orgUnitPrefix = "org"
+servicePrefix = "srv"
+opsSuffix = "ops"
+facilitySuffix = "facility"
+
+facility {
+    name = "lm"
+    services = [ "mic1" ]
+    access = "perService"
+}
+
+orgUnit {
+    name = "ag-foo"
+    subdirs = [
+        { name = "people", policy = "owner" },
+    ]
+}
+
+filter {
+    service = ".*"
+    orgUnit = "ag-.*"
+    action = "accept"
+}
+`
+
+var groups = []grp.Group{
+	{Name: "ag_org", Gid: 2000},
+	{Name: "org_ag-foo", Gid: 2001},
+	{Name: "org_lm-facility", Gid: 2002},
+	{Name: "srv_mic1", Gid: 2011},
+	{Name: "srv_lm-ops", Gid: 2021},
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string) {}
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+func (nopLogger) Panic(msg string) {
+	panic(msg)
+}
+
+func init() {
+	bcp.SetLogger(nopLogger{})
+	fsapply.SetLogger(nopLogger{})
+}
+
+// `example` holds the arguments for `EnsurePermissions()` with an in-memory
+// filesystem.
+type example struct {
+	cfg    *bcpcfg.Root
+	org    *bcp.Organization
+	filter bfilter.OrgServiceFilter
+	fs     *aclfs.MemFS
+}
+
+// `newExample()` parses `config` followed by `extraCfg` and creates an empty
+// `rootdir`.
+func newExample(extraCfg string, gs []grp.Group) *example {
+	cfg, err := bcpcfg.Parse(config + extraCfg)
+	if err != nil {
+		panic(err)
+	}
+	org, _, err := bcp.New(gs, cfg)
+	if err != nil {
+		panic(err)
+	}
+	var deciders []bfilter.Decider
+	for _, rule := range cfg.Filter {
+		d, err := bfilter.NewRegexpDecider(rule)
+		if err != nil {
+			panic(err)
+		}
+		deciders = append(deciders, d)
+	}
+	deciders = append(deciders, bfilter.NewSameFacilityDecider())
+	fs := aclfs.NewMemFS()
+	if err := fs.MkdirAll(rootdir, 0755); err != nil {
+		panic(err)
+	}
+	return &example{
+		cfg:    cfg,
+		org:    org,
+		filter: &bfilter.DecidersFilter{Rules: deciders},
+		fs:     fs,
+	}
+}
+
+func (x *example) apply(opts fsapply.Options) error {
+	opts.FS = x.fs
+	return fsapply.EnsurePermissions(x.cfg, x.org, x.filter, &opts)
+}
+
+// `plan()` returns the dry-run plan as text.
+func (x *example) plan(opts fsapply.Options) string {
+	opts.Plan = fsplan.New()
+	if err := x.apply(opts); err != nil {
+		return err.Error()
+	}
+	return opts.Plan.Text()
+}
+
+// `printTree()` prints the paths below `rootdir` with owner and, for
+// symlinks, the target.
+func (x *example) printTree() {
+	_ = aclfs.Walk(x.fs, rootdir, func(
+		path string, fi os.FileInfo, err error,
+	) error {
+		if err != nil {
+			fmt.Println(path, err)
+			return nil
+		}
+		rel := strings.TrimPrefix(path, rootdir)
+		if rel == "" {
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, _ := x.fs.Readlink(path)
+			fmt.Println(rel, "->", target)
+			return nil
+		}
+		uid, gid := aclfs.Owner(fi)
+		fmt.Printf("%s %d:%d\n", rel, uid, gid)
+		return nil
+	})
+}
+
+func ExampleEnsurePermissions() {
+	x := newExample("", groups)
+	fmt.Println(x.apply(fsapply.Options{}))
+	x.printTree()
+
+	// A second apply has nothing to do.
+	fmt.Printf("%q\n", x.plan(fsapply.Options{}))
+
+	// Output:
+	// <nil>
+	// /org 0:0
+	// /org/ag-foo 0:2001
+	// /org/ag-foo/mic1 -> ../../srv/mic1/ag-foo
+	// /org/ag-foo/people 0:2001
+	// /org/lm-facility 0:2002
+	// /org/lm-facility/mic1 -> ../../srv/mic1
+	// /srv 0:0
+	// /srv/mic1 0:2011
+	// /srv/mic1/ag-foo 0:2001
+	// /srv/mic1/lm-facility 0:2002
+	// ""
+}
+
+func ExampleEnsurePermissions_recursive() {
+	x := newExample("", groups)
+	_ = x.apply(fsapply.Options{})
+
+	dir := rootdir + "/srv/mic1/ag-foo/data"
+	file := dir + "/img.tif"
+	_ = x.fs.Mkdir(dir, 0700)
+	_ = x.fs.CreateFile(file, 0600)
+	_ = x.fs.SetDefaultACL(dir, nil)
+	_ = x.fs.Lchown(file, 1000, 1000)
+
+	fmt.Println(x.apply(fsapply.Options{Recursive: true}))
+	fi, _ := x.fs.Lstat(file)
+	fmt.Println(aclfs.Owner(fi))
+	acl, _ := x.fs.GetACL(file)
+	fmt.Println(strings.Replace(acl.String(), ",", "\n", -1))
+	acl, _ = x.fs.GetACL(dir)
+	fmt.Println(len(acl.Default) > 0)
+	fmt.Printf("%q\n", x.plan(fsapply.Options{Recursive: true}))
+
+	// Output:
+	// <nil>
+	// 1000 2001
+	// user::rw-
+	// group::---
+	// group:2001:rwx
+	// group:2021:rwx
+	// mask::rw-
+	// other::---
+	// true
+	// ""
+}
+
+func ExampleEnsurePermissions_scope() {
+	x := newExample(`
+orgUnit {
+    name = "ag-bar"
+    subdirs = []
+}
+`, append(groups, grp.Group{Name: "org_ag-bar", Gid: 2004}))
+	_ = x.apply(fsapply.Options{})
+	for _, p := range []string{"/srv/mic1/ag-foo", "/srv/mic1/ag-bar"} {
+		_ = x.fs.Lchown(rootdir+p, -1, 0)
+	}
+
+	for _, sel := range [][2]string{
+		{"ag-bar", ""},
+		{"", "mic1"},
+		{"ag-baz", ""},
+	} {
+		scope, _ := bcpscope.New(x.cfg, x.org, sel[0], sel[1])
+		fmt.Println(scope)
+		fmt.Print(x.plan(fsapply.Options{Scope: scope}))
+	}
+
+	// Output:
+	// orgUnit=~/^(?:ag-bar)$/
+	// chown 0:2004 /orgfs/data/srv/mic1/ag-bar
+	// service=~/^(?:mic1)$/
+	// chown 0:2001 /orgfs/data/srv/mic1/ag-foo
+	// chown 0:2004 /orgfs/data/srv/mic1/ag-bar
+	// orgUnit=~/^(?:ag-baz)$/
+}
+
+func ExampleEnsurePermissions_memberDirs() {
+	x := newExample(`
+orgUnit {
+    name = "ag-bar"
+    subdirs = [
+        { name = "people", policy = "owner", memberDirs = true },
+    ]
+}
+`, append(groups, grp.Group{
+		Name:    "org_ag-bar",
+		Gid:     2004,
+		Members: []string{"bob", "alice", "carol"},
+	}))
+	uids := map[string]int{"alice": 1001, "bob": 1002}
+	lookup := func(name string) (int, error) {
+		if uid, ok := uids[name]; ok {
+			return uid, nil
+		}
+		return -1, fmt.Errorf("unknown user `%s`", name)
+	}
+	people := rootdir + "/org/ag-bar/people"
+
+	list := func() {
+		names, _ := x.fs.ReadDir(people)
+		for _, fi := range names {
+			uid, gid := aclfs.Owner(fi)
+			fmt.Printf("%s %d:%d %v\n", fi.Name(), uid, gid, fi.Mode())
+		}
+	}
+
+	// Unknown users are skipped.
+	fmt.Println(x.apply(fsapply.Options{LookupUid: lookup}))
+	list()
+
+	// Existing member dirs are not modified, and directories of
+	// non-members are kept.
+	_ = x.fs.Chmod(filepath.Join(people, "alice"), 0755)
+	_ = x.fs.Mkdir(filepath.Join(people, "dave"), 0700)
+	_ = x.fs.Mkdir(filepath.Join(people, "project-x"), 0770)
+	fmt.Printf("%q\n", x.plan(fsapply.Options{LookupUid: lookup}))
+
+	// Missing member dirs are created.
+	_ = x.fs.Remove(filepath.Join(people, "bob"))
+	fmt.Println(x.apply(fsapply.Options{LookupUid: lookup}))
+	list()
+
+	// Output:
+	// <nil>
+	// alice 1001:2004 dgrwxr-x---
+	// bob 1002:2004 dgrwxr-x---
+	// ""
+	// <nil>
+	// alice 1001:2004 drwxr-xr-x
+	// bob 1002:2004 dgrwxr-x---
+	// dave 0:2004 dgrwx------
+	// project-x 0:2004 dgrwxr-x---
+}
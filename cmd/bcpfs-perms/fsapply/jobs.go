@@ -0,0 +1,186 @@
+package fsapply
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// `job` is an independent unit of work for `path`, like a single service org
+// unit directory.  Jobs must only modify paths that are not touched by other
+// jobs of the same `runJobs()` call.  `run` must use the applier `a` for
+// filesystem access and logging.
+type job struct {
+	path string
+	run  func(a *applier) error
+}
+
+// `runJobs()` runs `jobs` with at most `n` jobs concurrently.  With `n <= 1`,
+// jobs run serially with `a`.
+//
+// Concurrent jobs use forks of `a` with a separate plan and a buffered
+// logger.  The messages, planned operations, and failures of the jobs are
+// forwarded in the order of `jobs` after all jobs have completed, so that the
+// output does not depend on scheduling.
+//
+// If `a.keepGoing`, errors are recorded as failures of the job paths, and all
+// jobs run.  Otherwise, no further jobs are started after a job has failed.
+// Jobs that are already running complete, and their errors are combined into
+// a `Failures` error in the order of `jobs`.
+func (a *applier) runJobs(n int, jobs []job) error {
+	if n <= 1 {
+		for _, j := range jobs {
+			if err := a.check(j.path, j.run(a)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	forks := make([]*applier, len(jobs))
+	errs := make([]error, len(jobs))
+	var mu sync.Mutex
+	failed := false
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		sem <- struct{}{}
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		forks[i] = a.fork()
+		wg.Add(1)
+		go func(i int, j job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := forks[i].check(j.path, j.run(forks[i]))
+			if err != nil {
+				errs[i] = err
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}(i, j)
+	}
+	wg.Wait()
+
+	var failures Failures
+	for i, f := range forks {
+		if f == nil {
+			continue
+		}
+		a.join(f)
+		if errs[i] != nil {
+			failures = append(failures, Failure{
+				Path: jobs[i].path, Err: errs[i],
+			})
+		}
+	}
+	switch len(failures) {
+	case 0:
+		return nil
+	case 1:
+		return failures[0].Err
+	default:
+		return failures
+	}
+}
+
+// `check()` returns `err` or, if `a.keepGoing`, records a failure of `path`
+// and returns nil.
+func (a *applier) check(path string, err error) error {
+	if err == nil || !a.keepGoing {
+		return err
+	}
+	a.failures = append(a.failures, Failure{Path: path, Err: err})
+	msg := fmt.Sprintf("Failed `%s`: %v; continuing.", path, err)
+	a.log().Info(msg)
+	return nil
+}
+
+// `fork()` returns an applier for a concurrent job; see `runJobs()`.
+func (a *applier) fork() *applier {
+	f := &applier{
+		fs:        a.fs,
+		scope:     a.scope,
+		keepGoing: a.keepGoing,
+		lg:        &bufferedLogger{},
+	}
+	if a.isPlanning() {
+		f.plan = a.plan.Fork()
+	}
+	return f
+}
+
+// `join()` forwards the buffered messages, planned operations, and failures of
+// the fork `f` to `a`.
+func (a *applier) join(f *applier) {
+	f.lg.(*bufferedLogger).replay(a.log())
+	if a.isPlanning() {
+		a.plan.Merge(f.plan)
+	}
+	a.failures = append(a.failures, f.failures...)
+}
+
+// `Failure` is an error that occurred when applying permissions to `Path`.
+type Failure struct {
+	Path string
+	Err  error
+}
+
+func (f Failure) Error() string {
+	return fmt.Sprintf("`%s`: %v", f.Path, f.Err)
+}
+
+// `Failures` combines several failures.  It is returned by
+// `EnsurePermissions()` with `Options.KeepGoing` and if several concurrent
+// jobs failed.
+type Failures []Failure
+
+func (fs Failures) Error() string {
+	msgs := make([]string, 0, len(fs))
+	for _, f := range fs {
+		msgs = append(msgs, f.Error())
+	}
+	return fmt.Sprintf(
+		"%d paths failed: %s", len(fs), strings.Join(msgs, "; "),
+	)
+}
+
+// `bufferedLogger` records messages for `replay()`.  `Panic()` is not
+// buffered.
+type bufferedLogger struct {
+	msgs []bufferedMsg
+}
+
+type bufferedMsg struct {
+	debug bool
+	msg   string
+}
+
+func (l *bufferedLogger) Debug(msg string) {
+	l.msgs = append(l.msgs, bufferedMsg{debug: true, msg: msg})
+}
+
+func (l *bufferedLogger) Info(msg string) {
+	l.msgs = append(l.msgs, bufferedMsg{msg: msg})
+}
+
+func (l *bufferedLogger) Panic(msg string) {
+	logger.Panic(msg)
+}
+
+func (l *bufferedLogger) replay(to Logger) {
+	for _, m := range l.msgs {
+		if m.debug {
+			to.Debug(m.msg)
+		} else {
+			to.Info(m.msg)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package fsapply_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+)
+
+// `jobsCfg` adds org units and services, so that there are several subtrees
+// to process concurrently.
+const jobsCfg = `
+facility {
+    name = "em"
+    services = [ "tem1" ]
+    access = "allOrgUnits"
+}
+
+orgUnit {
+    name = "ag-bar"
+    subdirs = [
+        { name = "people", policy = "owner" },
+        { name = "shared", policy = "manager" },
+    ]
+}
+`
+
+var jobsGroups = append(append([]grp.Group{}, groups...),
+	grp.Group{Name: "org_ag-bar", Gid: 2004},
+	grp.Group{Name: "org_em-facility", Gid: 2003},
+	grp.Group{Name: "srv_tem1", Gid: 2013},
+	grp.Group{Name: "srv_em-ops", Gid: 2022},
+)
+
+// `breakPath()` replaces the directory `path` with a regular file, so that
+// applying permissions to it fails.
+func (x *example) breakPath(path string) {
+	if err := x.fs.Remove(path); err != nil {
+		panic(err)
+	}
+	if err := x.fs.CreateFile(path, 0644); err != nil {
+		panic(err)
+	}
+}
+
+func ExampleOptions_jobs() {
+	x := newExample(jobsCfg, jobsGroups)
+	serial := x.plan(fsapply.Options{})
+	same := true
+	for i := 0; i < 3; i++ {
+		same = same && x.plan(fsapply.Options{Jobs: 8}) == serial
+	}
+	fmt.Println(same)
+
+	fmt.Println(x.apply(fsapply.Options{Recursive: true, Jobs: 8}))
+	fmt.Printf("%q\n", x.plan(fsapply.Options{}))
+
+	// The first error stops apply.
+	x.breakPath(rootdir + "/srv/mic1/ag-foo")
+	fmt.Println(x.apply(fsapply.Options{Jobs: 8}))
+
+	// Output:
+	// true
+	// <nil>
+	// ""
+	// service dirs: `/orgfs/data/srv/mic1/ag-foo` is not a directory
+}
+
+func ExampleOptions_keepGoing() {
+	drifted := rootdir + "/org/lm-facility"
+	gid := func(x *example) int {
+		fi, _ := x.fs.Lstat(drifted)
+		_, gid := aclfs.Owner(fi)
+		return gid
+	}
+	for _, jobs := range []int{1, 8} {
+		x := newExample(jobsCfg, jobsGroups)
+		_ = x.apply(fsapply.Options{})
+		x.breakPath(rootdir + "/srv/mic1/ag-foo")
+		x.breakPath(rootdir + "/org/ag-bar/people")
+		_ = x.fs.Lchown(drifted, -1, 0)
+
+		fmt.Println("jobs", jobs)
+		err := x.apply(fsapply.Options{Jobs: jobs})
+		fmt.Println(err != nil, gid(x))
+
+		err = x.apply(fsapply.Options{Jobs: jobs, KeepGoing: true})
+		for _, f := range err.(fsapply.Failures) {
+			fmt.Println(f.Path)
+		}
+		fmt.Println(gid(x))
+	}
+
+	// Output:
+	// jobs 1
+	// true 0
+	// /orgfs/data/srv/mic1/ag-foo
+	// /orgfs/data/org/ag-bar/people
+	// 2002
+	// jobs 8
+	// true 0
+	// /orgfs/data/srv/mic1/ag-foo
+	// /orgfs/data/org/ag-bar/people
+	// 2002
+}
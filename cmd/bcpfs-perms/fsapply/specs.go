@@ -0,0 +1,326 @@
+package fsapply
+
+// See design document for permissions.
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+// `dirSpec` describes the desired state of a managed directory: the owner
+// `Uid` and `Gid`, whether the SGID bit is set, ACL entries `Modify` that are
+// added or replaced like `setfacl -M`, and ACL entries `Remove` that are
+// removed like `setfacl -X`.
+//
+// Entries are modified, so that other named group entries, like `--x`
+// traversal, are preserved.
+type dirSpec struct {
+	Path   string
+	Uid    int
+	Gid    int
+	Setgid bool
+	Modify posixacl.FileACL
+	Remove posixacl.FileACL
+}
+
+// `treeSpec` describes the desired state of the files below a managed
+// directory, which is used with `--recursive`.  The toplevel directory itself
+// is left unmodified.
+//
+// `DirAcl` has normal and default entries:
+//
+//  - the toplevel default ACL becomes the normal ACL;
+//  - the toplevel default ACL is propagated.
+//
+// `FileAcl` has only normal entries:
+//
+//  - based on the `DirAcl` normal entries;
+//  - without x-bit for user, mask, and other entries; but keeping the x-bit
+//    for group entries, so that the effective group permissions are only
+//    restricted via mask.
+//
+// See `applier.ensureTree()`.
+type treeSpec struct {
+	Path    string
+	Gid     int
+	DirAcl  posixacl.FileACL
+	FileAcl posixacl.FileACL
+}
+
+func toplevelSpec(path string) dirSpec {
+	return dirSpec{
+		Path: path,
+		Uid:  0,
+		Gid:  0,
+		Modify: facl([]string{
+			"user::rwx",
+			"group::r-x",
+			"other::r-x",
+		}),
+	}
+}
+
+// `serviceSpec()` for `<srv>` dirs.  The facility `access` policy determines
+// whether the service and ops groups or the super group have access.
+func serviceSpec(
+	path string, gid int, opsGid int, superGid int, access bcp.AccessPolicy,
+) dirSpec {
+	if access.IsAllOrgUnits() {
+		return dirSpec{
+			Path: path,
+			Uid:  0,
+			Gid:  gid,
+			Modify: facl(withDefaultEntries([]string{
+				"user::rwx",
+				"group::---",
+				fmt.Sprintf("group:%d:r-x", superGid),
+				"mask::r-x",
+				"other::---",
+			})),
+			Remove: facl(withDefaultEntries([]string{
+				fmt.Sprintf("group:%d", gid),
+				fmt.Sprintf("group:%d", opsGid),
+			})),
+		}
+	}
+	if access.IsPerService() {
+		return dirSpec{
+			Path: path,
+			Uid:  0,
+			Gid:  gid,
+			Modify: facl(withDefaultEntries([]string{
+				"user::rwx",
+				"group::---",
+				fmt.Sprintf("group:%d:r-x", gid),
+				fmt.Sprintf("group:%d:r-x", opsGid),
+				"mask::r-x",
+				"other::---",
+			})),
+			Remove: facl(withDefaultEntries([]string{
+				fmt.Sprintf("group:%d", superGid),
+			})),
+		}
+	}
+	panic("Invalid Access policy")
+}
+
+// `serviceOrgUnitSpec()` for `<srv>/<ou>` dirs adds ou `gid` and ops `opsGid`
+// ACL entries and removes parent srv `srvGid` or `superGid` ACL entries, which
+// propagated during `mkdir`.
+func serviceOrgUnitSpec(
+	path string, gid int, srvGid int, opsGid int, superGid int,
+) dirSpec {
+	return dirSpec{
+		Path:   path,
+		Uid:    0,
+		Gid:    gid,
+		Setgid: true,
+		Modify: facl(withDefaultEntries([]string{
+			"user::rwx",
+			"group::---",
+			fmt.Sprintf("group:%d:rwx", gid),
+			fmt.Sprintf("group:%d:rwx", opsGid),
+			"mask::rwx",
+			"other::---",
+		})),
+		Remove: facl(withDefaultEntries([]string{
+			fmt.Sprintf("group:%d", srvGid),
+			fmt.Sprintf("group:%d", superGid),
+		})),
+	}
+}
+
+// `expiredServiceOrgUnitSpec()` for `<srv>/<ou>` dirs whose filter rule has
+// expired changes the owning group to ops `opsGid` and removes the ou `gid`
+// ACL entries, so that the org unit can no longer access the data, while
+// operators can.
+func expiredServiceOrgUnitSpec(
+	path string, gid int, srvGid int, opsGid int, superGid int,
+) dirSpec {
+	return dirSpec{
+		Path:   path,
+		Uid:    0,
+		Gid:    opsGid,
+		Setgid: true,
+		Modify: facl(withDefaultEntries([]string{
+			"user::rwx",
+			"group::---",
+			fmt.Sprintf("group:%d:rwx", opsGid),
+			"mask::rwx",
+			"other::---",
+		})),
+		Remove: facl(withDefaultEntries([]string{
+			fmt.Sprintf("group:%d", gid),
+			fmt.Sprintf("group:%d", srvGid),
+			fmt.Sprintf("group:%d", superGid),
+		})),
+	}
+}
+
+func serviceOrgUnitTreeSpec(path string, gid int, opsGid int) treeSpec {
+	return treeSpec{
+		Path: path,
+		Gid:  gid,
+		DirAcl: facl(withDefaultEntries([]string{
+			"user::rwx",
+			"group::---",
+			fmt.Sprintf("group:%d:rwx", gid),
+			fmt.Sprintf("group:%d:rwx", opsGid),
+			"mask::rwx",
+			"other::---",
+		})),
+		FileAcl: facl([]string{
+			"user::rw-",
+			"group::---",
+			fmt.Sprintf("group:%d:rwx", gid),
+			fmt.Sprintf("group:%d:rwx", opsGid),
+			"mask::rw-",
+			"other::---",
+		}),
+	}
+}
+
+func orgUnitSpec(path string, gid int) dirSpec {
+	return dirSpec{
+		Path:   path,
+		Uid:    0,
+		Gid:    gid,
+		Setgid: true,
+		Modify: facl(withDefaultEntries([]string{
+			"user::rwx",
+			"group::---",
+			fmt.Sprintf("group:%d:r-x", gid),
+			"mask::r-x",
+			"other::---",
+		})),
+	}
+}
+
+// `orgUnitSubdirSpec()` for `<ou>/<subdir>` dirs.  See NOE-11 policies
+// `group`, `owner`, and `manager`.
+func orgUnitSubdirSpec(path string, gid int, policy bcp.DirPolicy) dirSpec {
+	spec := dirSpec{
+		Path:   path,
+		Uid:    0,
+		Gid:    gid,
+		Setgid: true,
+	}
+	switch policy {
+	case bcp.GroupPolicy:
+		spec.Modify = facl(withDefaultEntries([]string{
+			"user::rwx",
+			"group::---",
+			fmt.Sprintf("group:%d:rwx", gid),
+			"mask::rwx",
+			"other::---",
+		}))
+	case bcp.OwnerPolicy:
+		spec.Modify = facl([]string{
+			"user::rwx",
+			"group::---",
+			fmt.Sprintf("group:%d:rwx", gid),
+			"mask::rwx",
+			"other::---",
+			"default:user::rwx",
+			"default:group::---",
+			fmt.Sprintf("default:group:%d:r-x", gid),
+			"default:mask::r-x",
+			"default:other::---",
+		})
+	case bcp.ManagerPolicy:
+		spec.Modify = facl(withDefaultEntries([]string{
+			"user::rwx",
+			"group::---",
+			fmt.Sprintf("group:%d:r-x", gid),
+			"mask::r-x",
+			"other::---",
+		}))
+	default:
+		panic(fmt.Sprintf("unsupported dir policy `%s`", policy))
+	}
+	return spec
+}
+
+// `orgUnitSubdirTreeSpec()` for the files below `<ou>/<subdir>`.  With policy
+// `owner` and `manager`, the group can only read.
+func orgUnitSubdirTreeSpec(path string, gid int, policy bcp.DirPolicy) treeSpec {
+	switch policy {
+	case bcp.GroupPolicy:
+		return treeSpec{
+			Path: path,
+			Gid:  gid,
+			DirAcl: facl(withDefaultEntries([]string{
+				"user::rwx",
+				"group::---",
+				fmt.Sprintf("group:%d:rwx", gid),
+				"mask::rwx",
+				"other::---",
+			})),
+			FileAcl: facl([]string{
+				"user::rw-",
+				"group::---",
+				fmt.Sprintf("group:%d:rwx", gid),
+				"mask::rw-",
+				"other::---",
+			}),
+		}
+	case bcp.OwnerPolicy, bcp.ManagerPolicy:
+		return treeSpec{
+			Path: path,
+			Gid:  gid,
+			DirAcl: facl(withDefaultEntries([]string{
+				"user::rwx",
+				"group::---",
+				fmt.Sprintf("group:%d:r-x", gid),
+				"mask::r-x",
+				"other::---",
+			})),
+			FileAcl: facl([]string{
+				"user::rw-",
+				"group::---",
+				fmt.Sprintf("group:%d:r-x", gid),
+				"mask::r--",
+				"other::---",
+			}),
+		}
+	default:
+		panic(fmt.Sprintf("unsupported dir policy `%s`", policy))
+	}
+}
+
+// `memberDirSpec()` for `<ou>/<subdir>/<user>` dirs below an `owner` subdir.
+// The member `uid` owns the directory, and the ACL is the same as for a
+// directory that the member created.
+func memberDirSpec(path string, uid int, gid int) dirSpec {
+	return dirSpec{
+		Path:   path,
+		Uid:    uid,
+		Gid:    gid,
+		Setgid: true,
+		Modify: facl(withDefaultEntries([]string{
+			"user::rwx",
+			"group::---",
+			fmt.Sprintf("group:%d:r-x", gid),
+			"mask::r-x",
+			"other::---",
+		})),
+	}
+}
+
+// `withDefaultEntries()` returns the `entries` followed by the same entries
+// with prefix `default:`.
+func withDefaultEntries(entries []string) []string {
+	res := make([]string, 0, 2*len(entries))
+	res = append(res, entries...)
+	for _, e := range entries {
+		res = append(res, "default:"+e)
+	}
+	return res
+}
+
+// `facl()` parses ACL entries, which are constructed by the program.
+func facl(entries []string) posixacl.FileACL {
+	return posixacl.MustParseFileACL(entries...)
+}
@@ -2,12 +2,14 @@ package fsck
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
-	"github.com/nogproject/bcpfs/pkg/execx"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
 )
 
 // `ACL` provides access to the information necessary to verify current
@@ -18,109 +20,187 @@ import (
 // used to ignore unrelated named group entries when comparing current
 // filesystem ACLs.
 //
-// `FACLString()` returns a string representation that can be compared to the
-// output of the command line tool `getfacl`.
+// `FACLString()` returns the expected owner, group, flags, and ACL entries in
+// the text format of `getfacl -E -n` without the `# file:` header.  It is
+// parsed with `parseFACLString()` for comparison.
 type ACL interface {
 	NamedGids() []int
 	FACLString() string
 }
 
 // `CheckACLs()` checks whether the POSIX ACLs match for the non-symlink
-// `entries`.
-func CheckACLs(entries []Entry) (ok bool, err error) {
+// `entries`.  Mismatches are added to `rep` if it is non-nil.
+func CheckACLs(
+	fs aclfs.FS, entries []Entry, rep *Report,
+) (ok bool, err error) {
 	ok = true
 	for _, p := range entries {
 		if p.IsSymlink {
 			continue
 		}
 
-		facl, err := getfacl(p.Path)
+		expected, err := parseFACLString(p.ACL.FACLString())
+		if err != nil {
+			return false, fmt.Errorf(
+				"invalid expected ACL for `%s`: %v", p.Path, err,
+			)
+		}
+
+		actual, err := readFilePerms(fs, p.Path)
+		if p.Optional && os.IsNotExist(err) {
+			continue
+		}
 		if err != nil {
 			ok = false
 			msg := fmt.Sprintf(
-				"failed to getfacl `%s`: %v", p.Path, err,
+				"failed to read ACL `%s`: %v", p.Path, err,
 			)
 			logger.Error(msg)
+			rep.add(entries, Finding{
+				Category: CategoryACL,
+				Path:     p.Path,
+				Expected: expected.String(),
+				Actual:   err.Error(),
+			})
+			continue
+		}
+		if p.CreateOnly {
 			continue
 		}
 
 		// Remove unrelated named group entries before comparison.
-		facl = rejectOtherNamedGroupEntries(facl, p.ACL.NamedGids())
-
-		expected := fmt.Sprintf(
-			"# file: %s\n%s", p.Path, p.ACL.FACLString(),
-		)
+		actual = actual.onlyNamedGroups(p.ACL.NamedGids())
 
-		if facl != expected {
+		if !actual.equal(expected) {
 			ok = false
 			msg := fmt.Sprintf(
 				"wrong ACL; ...\n"+
-					"    expected `%s`; ...\n"+
-					"    got      `%s`.",
-				strings.Replace(expected, "\n", ", ", -1),
-				strings.Replace(facl, "\n", ", ", -1),
+					"    expected `# file: %s, %s`; ...\n"+
+					"    got      `# file: %s, %s`.",
+				p.Path, expected, p.Path, actual,
 			)
 			logger.Error(msg)
+			rep.add(entries, Finding{
+				Category: CategoryACL,
+				Path:     p.Path,
+				Expected: expected.String(),
+				Actual:   actual.String(),
+			})
 		}
 	}
 	return ok, nil
 }
 
-// `rejectOtherNamedGroupEntries(facl, gids)` returns a filter version of
-// `facl` with named group entries other than for `gids` removed.
-func rejectOtherNamedGroupEntries(facl string, gids []int) string {
-	var keep []string
-	for _, line := range strings.Split(facl, "\n") {
-		if isOtherNamedGroupEntry(line, gids) {
-			continue
-		}
-		keep = append(keep, line)
+// `filePerms` is the owner, group, flags, and ACLs of a path.  `Flags` uses
+// the `getfacl` format, like `-s-` for SGID, or is empty if no flag is set.
+type filePerms struct {
+	Uid   int
+	Gid   int
+	Flags string
+	ACL   posixacl.FileACL
+}
+
+func readFilePerms(fs aclfs.FS, path string) (filePerms, error) {
+	var p filePerms
+	fi, err := fs.Lstat(path)
+	if err != nil {
+		return p, err
 	}
-	return strings.Join(keep, "\n")
+	p.Uid, p.Gid = aclfs.Owner(fi)
+	p.Flags = fileFlags(fi.Mode())
+	p.ACL, err = fs.GetACL(path)
+	return p, err
 }
 
-func isOtherNamedGroupEntry(l string, gids []int) bool {
-	if !isNamedGroupEntry(l) {
-		return false
+func fileFlags(mode os.FileMode) string {
+	if mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) == 0 {
+		return ""
 	}
-	for _, gid := range gids {
-		tag := fmt.Sprintf("group:%d:", gid)
-		if strings.Contains(l, tag) {
-			return false
+	flags := []byte("---")
+	if mode&os.ModeSetuid != 0 {
+		flags[0] = 's'
+	}
+	if mode&os.ModeSetgid != 0 {
+		flags[1] = 's'
+	}
+	if mode&os.ModeSticky != 0 {
+		flags[2] = 't'
+	}
+	return string(flags)
+}
+
+// `parseFACLString()` parses the `getfacl` text format with header lines
+// `# owner:`, `# group:`, and optionally `# flags:`.
+func parseFACLString(s string) (filePerms, error) {
+	var p filePerms
+	var entries []string
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		var err error
+		switch {
+		case strings.HasPrefix(line, "# owner: "):
+			p.Uid, err = strconv.Atoi(line[len("# owner: "):])
+		case strings.HasPrefix(line, "# group: "):
+			p.Gid, err = strconv.Atoi(line[len("# group: "):])
+		case strings.HasPrefix(line, "# flags: "):
+			p.Flags = line[len("# flags: "):]
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		default:
+			entries = append(entries, line)
+		}
+		if err != nil {
+			return p, fmt.Errorf("malformed line `%s`", line)
 		}
 	}
-	return true
+
+	var err error
+	p.ACL, err = posixacl.ParseFileACL(entries)
+	return p, err
 }
 
-func isNamedGroupEntry(l string) bool {
-	if strings.Contains(l, "# ") {
-		return false
+// `onlyNamedGroups()` returns a copy without named group entries other than
+// for `gids`.
+func (p filePerms) onlyNamedGroups(gids []int) filePerms {
+	keep := make(map[int]bool)
+	for _, gid := range gids {
+		keep[gid] = true
+	}
+	reject := func(a posixacl.ACL) posixacl.ACL {
+		res := make(posixacl.ACL, 0, len(a))
+		for _, e := range a {
+			if e.Tag == posixacl.TagGroup && !keep[e.ID] {
+				continue
+			}
+			res = append(res, e)
+		}
+		return res
 	}
-	if strings.Contains(l, "group::") {
-		return false
+	p.ACL = posixacl.FileACL{
+		Access:  reject(p.ACL.Access),
+		Default: reject(p.ACL.Default),
 	}
-	return strings.Contains(l, "group:")
+	return p
 }
 
-func getfacl(path string) (string, error) {
-	out, err := exec.Command(
-		getfaclTool.Path,
-		"-p", // absolute names.
-		"-E", // no effective rights.
-		"-n", // numeric ids.
-		path,
-	).Output()
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(out)), nil
+func (p filePerms) equal(o filePerms) bool {
+	return p.Uid == o.Uid &&
+		p.Gid == o.Gid &&
+		p.Flags == o.Flags &&
+		p.ACL.Equal(o.ACL)
 }
 
-var getfaclTool = execx.MustLookTool(execx.ToolSpec{
-	Program:   "getfacl",
-	CheckArgs: []string{"--version"},
-	CheckText: "getfacl 2",
-})
+// `String()` returns the `getfacl` text format with lines joined by comma.
+func (p filePerms) String() string {
+	lines := []string{
+		fmt.Sprintf("# owner: %d", p.Uid),
+		fmt.Sprintf("# group: %d", p.Gid),
+	}
+	if p.Flags != "" {
+		lines = append(lines, fmt.Sprintf("# flags: %s", p.Flags))
+	}
+	lines = append(lines, p.ACL.Strings()...)
+	return strings.Join(lines, ", ")
+}
 
 // `SimpleACL` is an `ACL` for simple, traditional Unix permissions.
 // Permissions are represented as strings like `User=rwx`.
@@ -252,6 +332,43 @@ default:other::---
 	))
 }
 
+// `ExpiredServiceOrgUnitACL` for `/orgfs/srv/*/<ou>` directories whose filter
+// rule has expired.  The ops group owns the directory, and there must be no
+// ou ACL entry.
+type ExpiredServiceOrgUnitACL struct {
+	Uid           int
+	OrgUnitGid    int
+	ServiceOpsGid int
+	ServiceGid    int
+	SuperGid      int
+}
+
+func (a ExpiredServiceOrgUnitACL) NamedGids() []int {
+	return []int{a.OrgUnitGid, a.ServiceGid, a.ServiceOpsGid, a.SuperGid}
+}
+
+func (a ExpiredServiceOrgUnitACL) FACLString() string {
+	return strings.TrimSpace(fmt.Sprintf(`
+# owner: %d
+# group: %d
+# flags: -s-
+user::rwx
+group::---
+group:%d:rwx
+mask::rwx
+other::---
+default:user::rwx
+default:group::---
+default:group:%d:rwx
+default:mask::rwx
+default:other::---
+`,
+		a.Uid, a.ServiceOpsGid, // header
+		a.ServiceOpsGid, // group:...
+		a.ServiceOpsGid, // default:group:...
+	))
+}
+
 // `OrgUnitACL` for `/orgfs/org/<ou>` directories.  See NOE-10.
 type OrgUnitACL struct {
 	Uid int
@@ -348,6 +465,39 @@ default:other::---
 	))
 }
 
+// `MemberDirACL` for `/orgfs/org/<ou>/<subdir>/<user>` directories.  It is
+// the ACL that a member gets when creating a directory in an `owner` subdir.
+type MemberDirACL struct {
+	Uid int
+	Gid int
+}
+
+func (a MemberDirACL) NamedGids() []int {
+	return []int{a.Gid}
+}
+
+func (a MemberDirACL) FACLString() string {
+	return strings.TrimSpace(fmt.Sprintf(`
+# owner: %d
+# group: %d
+# flags: -s-
+user::rwx
+group::---
+group:%d:r-x
+mask::r-x
+other::---
+default:user::rwx
+default:group::---
+default:group:%d:r-x
+default:mask::r-x
+default:other::---
+`,
+		a.Uid, a.Gid, // header
+		a.Gid, // group:...
+		a.Gid, // default:group:...
+	))
+}
+
 // `SubdirManagerACL` for `/orgfs/org/<ou>/<subdir>` directories.  See NOE-11.
 type SubdirManagerACL struct {
 	Uid int
@@ -14,16 +14,49 @@ import (
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
 	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
 )
 
 // `Entry` represents a desired `Path` on disk.  It is a symlink if
 // `IsSymlink=true`; `LinkDest` contains the symlink target.  For
-// `IsSymlink=false`, `ACL` contains the expected POSIX ACL.
+// `IsSymlink=false`, `ACL` contains the expected POSIX ACL.  `Recursive=true`
+// indicates that the files below `Path` are managed by `apply --recursive`;
+// see `CheckTrees()`.  `OrgUnit` and `Service` are the names of the related
+// org unit and service, if any; they are used for reporting.
+// `MemberDirs=true` indicates that `Path` contains per-member directories for
+// the users in `Members`; see `CheckMemberDirs()`.  `Optional=true` indicates
+// that `Path` may be missing, like a dir whose filter rule has expired.
+// `CreateOnly=true` indicates that `apply` creates `Path` if it is missing but
+// does not modify it later, like a per-member dir; `CheckACLs()` only verifies
+// that it exists.
 type Entry struct {
-	Path      string
-	IsSymlink bool
-	ACL       ACL
-	LinkDest  string
+	Path       string
+	IsSymlink  bool
+	ACL        ACL
+	LinkDest   string
+	Recursive  bool
+	OrgUnit    string
+	Service    string
+	MemberDirs bool
+	Members    []string
+	Optional   bool
+	CreateOnly bool
+}
+
+// `Options` control `CheckPermissions()`.  `FS` is the filesystem; nil uses
+// the real filesystem `aclfs.OS`.  If `Report` is non-nil, findings are added
+// to it.  `Recursive` enables `CheckTrees()` with sampling `Sample`.  A
+// non-nil `Scope` restricts the checks to the selected paths.  `LookupUid`
+// determines the owners of per-member directories; nil uses
+// `bcp.LookupUid()`.
+type Options struct {
+	FS        aclfs.FS
+	Report    *Report
+	Recursive bool
+	Sample    int
+	Scope     *bcpscope.Scope
+	LookupUid bcp.UidFunc
 }
 
 // `CheckPermissions()` verifies the toplevel filesystem structure.  It returns
@@ -33,13 +66,111 @@ type Entry struct {
 func CheckPermissions(
 	cfg *bcpcfg.Root, org *bcp.Organization,
 	filter bfilter.OrgServiceFilter,
+	opts *Options,
 ) (reason string, err error) {
 	var failures []string
+	fs := opts.FS
+	if fs == nil {
+		fs = aclfs.OS{}
+	}
+	rep := opts.Report
 
 	root, err := filepath.Abs(cfg.Rootdir)
 	if err != nil {
 		return "", err
 	}
+	entries, err := ExpectedEntries(cfg, org, filter, opts.LookupUid)
+	if err != nil {
+		return "", err
+	}
+	serviceRoot := filepath.Join(root, cfg.ServiceDir)
+	orgUnitRoot := filepath.Join(root, cfg.OrgUnitDir)
+
+	explicitSymlinks := make(map[string]string)
+	for _, link := range cfg.Symlinks {
+		path := filepath.Join(root, link.Path)
+		if !opts.Scope.Selects(path) {
+			continue
+		}
+		explicitSymlinks[path] = link.Target
+	}
+
+	entries = selectEntries(entries, opts.Scope)
+
+	if ok, err := CheckNoUnexpected(
+		fs, serviceRoot, entries, explicitSymlinks, opts.Scope, rep,
+	); err != nil {
+		return "", err
+	} else if !ok {
+		failures = append(failures, "no-unexpected-srv")
+	}
+
+	if ok, err := CheckNoUnexpected(
+		fs, orgUnitRoot, entries, explicitSymlinks, opts.Scope, rep,
+	); err != nil {
+		return "", err
+	} else if !ok {
+		failures = append(failures, "no-unexpected-ou")
+	}
+
+	if ok, err := CheckSymlinks(fs, entries, rep); err != nil {
+		return "", err
+	} else if !ok {
+		failures = append(failures, "symlinks")
+	}
+
+	if ok, err := CheckExplicitSymlinks(
+		fs, entries, explicitSymlinks, rep,
+	); err != nil {
+		return "", err
+	} else if !ok {
+		failures = append(failures, "explicit-symlinks")
+	}
+
+	if ok, err := CheckACLs(fs, entries, rep); err != nil {
+		return "", err
+	} else if !ok {
+		failures = append(failures, "acls")
+	}
+
+	if ok, err := CheckMemberDirs(
+		fs, entries, opts.LookupUid, rep,
+	); err != nil {
+		return "", err
+	} else if !ok {
+		failures = append(failures, "member-dirs")
+	}
+
+	if opts.Recursive {
+		if ok, err := CheckTrees(
+			fs, entries, opts.Sample, rep,
+		); err != nil {
+			return "", err
+		} else if !ok {
+			failures = append(failures, "tree-acls")
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Sprintf("checks failed: %s", failures), nil
+	}
+
+	return "", nil
+}
+
+// `ExpectedEntries()` returns the entries that `CheckPermissions()` expects
+// for the config, the org, and the filter without restricting them to a
+// scope.  The paths are absolute.  `lookupUid` determines the owners of
+// per-member directories; nil uses `bcp.LookupUid()`.
+func ExpectedEntries(
+	cfg *bcpcfg.Root, org *bcp.Organization,
+	filter bfilter.OrgServiceFilter,
+	lookupUid bcp.UidFunc,
+) ([]Entry, error) {
+	root, err := filepath.Abs(cfg.Rootdir)
+	if err != nil {
+		return nil, err
+	}
 	serviceRoot := filepath.Join(root, cfg.ServiceDir)
 	orgUnitRoot := filepath.Join(root, cfg.OrgUnitDir)
 
@@ -94,53 +225,26 @@ func CheckPermissions(
 		services:   org.Services,
 		orgUnits:   org.OrgUnits,
 		filter:     filter,
+		lookupUid:  lookupUid,
 	}
 	entries = append(entries, ouTree.OrgUnitDirsList()...)
 	entries = append(entries, ouTree.OrgUnitServiceLinksList()...)
 	entries = append(entries, ouTree.OrgUnitSubdirsList()...)
+	entries = append(entries, ouTree.MemberDirsList()...)
 
-	explicitSymlinks := make(map[string]string)
-	for _, link := range cfg.Symlinks {
-		explicitSymlinks[filepath.Join(root, link.Path)] = link.Target
-	}
-
-	if ok, err := CheckNoUnexpected(
-		serviceRoot, entries, explicitSymlinks,
-	); err != nil {
-		return "", err
-	} else if !ok {
-		failures = append(failures, "no-unexpected-srv")
-	}
-
-	if ok, err := CheckNoUnexpected(
-		orgUnitRoot, entries, explicitSymlinks,
-	); err != nil {
-		return "", err
-	} else if !ok {
-		failures = append(failures, "no-unexpected-ou")
-	}
-
-	if ok, err := CheckSymlinks(entries); err != nil {
-		return "", err
-	} else if !ok {
-		failures = append(failures, "symlinks")
-	}
-
-	if ok, err := CheckExplicitSymlinks(explicitSymlinks); err != nil {
-		return "", err
-	} else if !ok {
-		failures = append(failures, "explicit-symlinks")
-	}
+	return entries, nil
+}
 
-	if ok, err := CheckACLs(entries); err != nil {
-		return "", err
-	} else if !ok {
-		failures = append(failures, "acls")
+// `selectEntries()` returns the entries that are selected by `scope`.
+func selectEntries(entries []Entry, scope *bcpscope.Scope) []Entry {
+	if scope.IsAll() {
+		return entries
 	}
-
-	if len(failures) > 0 {
-		return fmt.Sprintf("checks failed: %s", failures), nil
+	selected := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if scope.Selects(e.Path) {
+			selected = append(selected, e)
+		}
 	}
-
-	return "", nil
+	return selected
 }
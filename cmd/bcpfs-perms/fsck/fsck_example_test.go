@@ -0,0 +1,320 @@
+package fsck_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsck"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+)
+
+const rootdir = "/orgfs/data"
+
+// `config` has a `perService` and an `allOrgUnits` facility, a filter that
+// rejects `ag-bar` for `mic2`, and an explicit symlink.  The examples append
+// further config.
+const config = `
+rootdir = "/orgfs/data"
+serviceDir = "srv"
+orgUnitDir = "org"
+superGroup = "ag_org"
+orgUnitPrefix = "org"
+servicePrefix = "srv"
+opsSuffix = "ops"
+facilitySuffix = "facility"
+
+facility {
+    name = "lm"
+    services = [ "mic1", "mic2" ]
+    access = "perService"
+}
+
+facility {
+    name = "em"
+    services = [ "tem1" ]
+    access = "allOrgUnits"
+}
+
+orgUnit {
+    name = "ag-foo"
+    subdirs = [
+        { name = "people", policy = "owner" },
+        { name = "service", policy = "group" },
+        { name = "shared", policy = "manager" },
+    ]
+    extraDirs = [ "projects" ]
+}
+
+orgUnit {
+    name = "lm-facility"
+    subdirs = [
+        { name = "service", policy = "group" },
+    ]
+}
+
+symlink {
+    target = "org/lm-facility/service/guides"
+    path = "srv/mic1/guides"
+}
+
+filter {
+    service = "mic2"
+    orgUnit = "ag-bar"
+    action = "reject"
+}
+
+filter {
+    service = ".*"
+    orgUnit = "ag-.*"
+    action = "accept"
+}
+`
+
+var groups = []grp.Group{
+	{Name: "ag_org", Gid: 2000},
+	{Name: "org_ag-foo", Gid: 2001},
+	{Name: "org_lm-facility", Gid: 2002},
+	{Name: "org_em-facility", Gid: 2003},
+	{Name: "org_ag-bar", Gid: 2004},
+	{Name: "srv_mic1", Gid: 2011},
+	{Name: "srv_mic2", Gid: 2012},
+	{Name: "srv_tem1", Gid: 2013},
+	{Name: "srv_lm-ops", Gid: 2021},
+	{Name: "srv_em-ops", Gid: 2022},
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string) {}
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+func (nopLogger) Panic(msg string) {
+	panic(msg)
+}
+
+func init() {
+	bcp.SetLogger(nopLogger{})
+	fsapply.SetLogger(nopLogger{})
+	fsck.SetLogger(nopLogger{})
+}
+
+// `example` is an in-memory filesystem to which the permissions for `cfg` have
+// been applied.
+type example struct {
+	cfg    *bcpcfg.Root
+	org    *bcp.Organization
+	groups []grp.Group
+	filter bfilter.OrgServiceFilter
+	fs     *aclfs.MemFS
+}
+
+// `newExample()` parses `config` followed by `extraCfg` and applies the
+// permissions with `opts` to an empty `rootdir`.
+func newExample(
+	extraCfg string, gs []grp.Group, opts fsapply.Options,
+) *example {
+	cfg, err := bcpcfg.Parse(config + extraCfg)
+	if err != nil {
+		panic(err)
+	}
+	org, _, err := bcp.New(gs, cfg)
+	if err != nil {
+		panic(err)
+	}
+	var deciders []bfilter.Decider
+	for _, rule := range cfg.Filter {
+		d, err := bfilter.NewRegexpDecider(rule)
+		if err != nil {
+			panic(err)
+		}
+		deciders = append(deciders, d)
+	}
+	deciders = append(deciders, bfilter.NewSameFacilityDecider())
+	x := &example{
+		cfg:    cfg,
+		org:    org,
+		groups: gs,
+		filter: &bfilter.DecidersFilter{Rules: deciders},
+		fs:     aclfs.NewMemFS(),
+	}
+	if err := x.fs.MkdirAll(rootdir, 0755); err != nil {
+		panic(err)
+	}
+	if err := x.apply(opts); err != nil {
+		panic(err)
+	}
+	return x
+}
+
+func (x *example) apply(opts fsapply.Options) error {
+	opts.FS = x.fs
+	return fsapply.EnsurePermissions(x.cfg, x.org, x.filter, &opts)
+}
+
+// `check()` runs `CheckPermissions()` and prints the reason and the findings.
+func (x *example) check(opts fsck.Options) {
+	opts.FS = x.fs
+	opts.Report = fsck.NewReport()
+	reason, err := fsck.CheckPermissions(x.cfg, x.org, x.filter, &opts)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("reason: %q\n", reason)
+	printFindings(opts.Report)
+}
+
+// `printFindings()` prints the findings with `Expected` and `Actual` on
+// separate lines.  ACL texts are omitted, because they are too long to be
+// readable in examples.
+func printFindings(rep *fsck.Report) {
+	for _, f := range rep.Findings {
+		line := string(f.Category)
+		add := func(k, v string) {
+			if v != "" {
+				line += fmt.Sprintf(" %s=%s", k, v)
+			}
+		}
+		add("path", f.Path)
+		add("group", f.Group)
+		add("user", f.User)
+		add("ou", f.OrgUnit)
+		add("srv", f.Service)
+		fmt.Println(line)
+		switch f.Category {
+		case fsck.CategoryACL, fsck.CategoryTreeACL:
+			continue
+		}
+		if f.Expected != "" {
+			fmt.Println("    expected:", f.Expected)
+		}
+		if f.Actual != "" {
+			fmt.Println("    actual:", f.Actual)
+		}
+	}
+}
+
+func ExampleCheckPermissions() {
+	x := newExample("", groups, fsapply.Options{})
+	x.check(fsck.Options{})
+
+	// Output:
+	// reason: ""
+}
+
+func ExampleCheckPermissions_drift() {
+	cases := []struct {
+		name   string
+		modify func(fs *aclfs.MemFS) error
+	}{
+		{
+			name: "mode",
+			modify: func(fs *aclfs.MemFS) error {
+				return fs.Chmod(rootdir+"/srv/mic1", 0777)
+			},
+		},
+		{
+			name: "group",
+			modify: func(fs *aclfs.MemFS) error {
+				return fs.Lchown(rootdir+"/org/ag-foo", -1, 0)
+			},
+		},
+		{
+			name: "unexpected",
+			modify: func(fs *aclfs.MemFS) error {
+				return fs.Mkdir(rootdir+"/srv/mic1/foo", 0755)
+			},
+		},
+		{
+			name: "symlink",
+			modify: func(fs *aclfs.MemFS) error {
+				return fs.Remove(rootdir + "/org/ag-foo/mic1")
+			},
+		},
+		{
+			name: "explicit symlink",
+			modify: func(fs *aclfs.MemFS) error {
+				p := rootdir + "/srv/mic1/guides"
+				if err := fs.Remove(p); err != nil {
+					return err
+				}
+				return fs.Symlink("/tmp", p)
+			},
+		},
+	}
+	for _, c := range cases {
+		fmt.Println("#", c.name)
+		x := newExample("", groups, fsapply.Options{})
+		if err := c.modify(x.fs); err != nil {
+			panic(err)
+		}
+		x.check(fsck.Options{})
+		// Apply repairs all but the explicit symlink, which it
+		// refuses to replace.
+		if err := x.apply(fsapply.Options{}); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		x.check(fsck.Options{})
+	}
+
+	// Output:
+	// # mode
+	// reason: "checks failed: [acls]"
+	// acl path=/orgfs/data/srv/mic1 srv=mic1
+	// reason: ""
+	// # group
+	// reason: "checks failed: [acls]"
+	// acl path=/orgfs/data/org/ag-foo ou=ag-foo
+	// reason: ""
+	// # unexpected
+	// reason: "checks failed: [no-unexpected-srv]"
+	// unexpected path=/orgfs/data/srv/mic1/foo srv=mic1
+	//     actual: dir
+	// reason: ""
+	// # symlink
+	// reason: "checks failed: [symlinks]"
+	// symlink path=/orgfs/data/org/ag-foo/mic1 ou=ag-foo srv=mic1
+	//     expected: ../../srv/mic1/ag-foo
+	//     actual: lstat /orgfs/data/org/ag-foo/mic1: no such file or directory
+	// reason: ""
+	// # explicit symlink
+	// reason: "checks failed: [explicit-symlinks]"
+	// explicit-symlink path=/orgfs/data/srv/mic1/guides srv=mic1
+	//     expected: org/lm-facility/service/guides
+	//     actual: /tmp
+	// symlink: failed to create explicit symlink `/orgfs/data/srv/mic1/guides`: symlink /orgfs/data/srv/mic1/guides: file exists
+}
+
+func ExampleCheckPermissions_scope() {
+	x := newExample("", groups, fsapply.Options{})
+	for _, p := range []string{"/srv/mic1/ag-foo", "/srv/mic1/ag-bar"} {
+		_ = x.fs.Lchown(rootdir+p, -1, 0)
+	}
+	scope := func(orgUnit, service string) *bcpscope.Scope {
+		s, err := bcpscope.New(x.cfg, x.org, orgUnit, service)
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+
+	x.check(fsck.Options{Scope: scope("", "mic2")})
+	x.check(fsck.Options{Scope: scope("ag-bar", "")})
+
+	_ = x.apply(fsapply.Options{Scope: scope("ag-(bar|baz)", "mic.*")})
+	x.check(fsck.Options{Scope: scope("ag-bar", "")})
+	x.check(fsck.Options{})
+
+	// Output:
+	// reason: ""
+	// reason: "checks failed: [acls]"
+	// acl path=/orgfs/data/srv/mic1/ag-bar ou=ag-bar srv=mic1
+	// reason: ""
+	// reason: "checks failed: [acls]"
+	// acl path=/orgfs/data/srv/mic1/ag-foo ou=ag-foo srv=mic1
+}
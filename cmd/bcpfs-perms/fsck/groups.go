@@ -0,0 +1,173 @@
+package fsck
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+)
+
+// `CheckGroups()` verifies the consistency of the Unix group memberships that
+// the permissions rely on:
+//
+//  - members of org unit groups must be members of the super group
+//    `superGroup`, which is used for `allOrgUnits` services; the check is
+//    skipped if `superGroup` is empty;
+//  - members of facility ops groups must be members of the facility org unit
+//    group;
+//  - service groups must not be empty.
+//
+// It returns `reason=""` if all checks passed.  Findings are logged and added
+// to `opts.Report` if it is non-nil.  The checks use the members that are
+// listed in the Unix groups; users whose primary group is a group are usually
+// not listed and may cause false findings.  `opts.Scope` is ignored.
+func CheckGroups(
+	superGroup string, org *bcp.Organization, groups []grp.Group,
+	opts *Options,
+) (reason string) {
+	var failures []string
+	gc := &groupChecker{rep: opts.Report}
+
+	if superGroup != "" {
+		var super *grp.Group
+		for i := range groups {
+			if groups[i].Name == superGroup {
+				super = &groups[i]
+			}
+		}
+		if super == nil {
+			msg := fmt.Sprintf(
+				"Skipped super group check: "+
+					"missing group `%s`.", superGroup,
+			)
+			logger.Info(msg)
+		} else if !gc.checkSuperGroup(*super, org.OrgUnits) {
+			failures = append(failures, "group-super")
+		}
+	}
+
+	if !gc.checkOpsGroups(org) {
+		failures = append(failures, "group-ops")
+	}
+
+	if !gc.checkServiceGroups(org.Services) {
+		failures = append(failures, "group-empty-service")
+	}
+
+	if len(failures) > 0 {
+		return fmt.Sprintf("checks failed: %s", failures)
+	}
+	return ""
+}
+
+type groupChecker struct {
+	rep *Report
+}
+
+func (gc *groupChecker) add(f Finding) {
+	gc.rep.add(nil, f)
+}
+
+func memberSet(g grp.Group) map[string]bool {
+	set := make(map[string]bool)
+	for _, m := range g.Members {
+		set[m] = true
+	}
+	return set
+}
+
+func (gc *groupChecker) checkSuperGroup(
+	super grp.Group, ous []bcp.OrgUnit,
+) (ok bool) {
+	ok = true
+	supers := memberSet(super)
+	for _, ou := range ous {
+		for _, m := range ou.Members() {
+			if supers[m] {
+				continue
+			}
+			ok = false
+			msg := fmt.Sprintf(
+				"Member `%s` of `%s` is not in super group `%s`",
+				m, ou.OrgUnitGroup.Name, super.Name,
+			)
+			logger.Error(msg)
+			gc.add(Finding{
+				Category: CategoryGroupSuper,
+				Group:    ou.OrgUnitGroup.Name,
+				User:     m,
+				Expected: super.Name,
+				OrgUnit:  ou.Name,
+			})
+		}
+	}
+	return ok
+}
+
+// `checkOpsGroups()` checks each ops group once, together with the first
+// service of the facility for reporting.
+func (gc *groupChecker) checkOpsGroups(org *bcp.Organization) (ok bool) {
+	ok = true
+	facilityOUs := make(map[string]bcp.OrgUnit)
+	for _, ou := range org.OrgUnits {
+		if ou.IsFacility {
+			facilityOUs[ou.Facility] = ou
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range org.Services {
+		ops := s.ServiceOpsGroup
+		if ops.Name == "" || seen[ops.Name] {
+			continue
+		}
+		seen[ops.Name] = true
+
+		ou, found := facilityOUs[s.Facility]
+		members := memberSet(ou.OrgUnitGroup)
+		for _, m := range ops.Members {
+			if members[m] {
+				continue
+			}
+			ok = false
+			expected := ou.OrgUnitGroup.Name
+			if !found {
+				expected = fmt.Sprintf("%s facility", s.Facility)
+			}
+			msg := fmt.Sprintf(
+				"Member `%s` of ops group `%s` is not in `%s`",
+				m, ops.Name, expected,
+			)
+			logger.Error(msg)
+			gc.add(Finding{
+				Category: CategoryGroupOps,
+				Group:    ops.Name,
+				User:     m,
+				Expected: expected,
+				OrgUnit:  ou.Name,
+			})
+		}
+	}
+	return ok
+}
+
+func (gc *groupChecker) checkServiceGroups(srvs []bcp.Service) (ok bool) {
+	ok = true
+	for _, s := range srvs {
+		if len(s.Members()) > 0 {
+			continue
+		}
+		ok = false
+		msg := fmt.Sprintf(
+			"Service group `%s` has no members",
+			s.ServiceGroup.Name,
+		)
+		logger.Error(msg)
+		gc.add(Finding{
+			Category: CategoryGroupEmptyService,
+			Group:    s.ServiceGroup.Name,
+			Service:  s.Name,
+		})
+	}
+	return ok
+}
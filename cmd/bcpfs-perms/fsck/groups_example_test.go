@@ -0,0 +1,43 @@
+package fsck_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsck"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+)
+
+func ExampleCheckGroups() {
+	gs := []grp.Group{
+		{Name: "ag_org", Gid: 2000, Members: []string{"alice"}},
+		{Name: "org_ag-foo", Gid: 2001, Members: []string{"alice", "bob"}},
+		{Name: "org_lm-facility", Gid: 2002, Members: []string{"lisa"}},
+		{Name: "org_em-facility", Gid: 2003},
+		{Name: "srv_mic1", Gid: 2011, Members: []string{"alice"}},
+		{Name: "srv_mic2", Gid: 2012, Members: []string{"bob"}},
+		{Name: "srv_tem1", Gid: 2013},
+		{Name: "srv_lm-ops", Gid: 2021, Members: []string{"lisa"}},
+		{Name: "srv_em-ops", Gid: 2022, Members: []string{"emil"}},
+	}
+	cfg, _ := bcpcfg.Parse(config)
+	org, _, _ := bcp.New(gs, cfg)
+
+	rep := fsck.NewReport()
+	reason := fsck.CheckGroups(
+		cfg.SuperGroup, org, gs, &fsck.Options{Report: rep},
+	)
+	fmt.Printf("reason: %q\n", reason)
+	printFindings(rep)
+
+	// Output:
+	// reason: "checks failed: [group-super group-ops group-empty-service]"
+	// group-super group=org_ag-foo user=bob ou=ag-foo
+	//     expected: ag_org
+	// group-super group=org_lm-facility user=lisa ou=lm-facility
+	//     expected: ag_org
+	// group-ops group=srv_em-ops user=emil ou=em-facility
+	//     expected: org_em-facility
+	// group-empty-service group=srv_tem1 srv=tem1
+}
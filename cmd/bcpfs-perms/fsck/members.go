@@ -0,0 +1,67 @@
+package fsck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+)
+
+// `CheckMemberDirs()` checks the entries with `MemberDirs=true` for
+// directories of users who are not in `Members`, like users who left the org
+// unit group.  Only directories whose name is a user name according to
+// `lookupUid` are considered; other directories, like `project-x`, may be
+// created by the members.  Stale directories are reported, but `apply` does
+// not remove them.  Missing member directories are reported by `CheckACLs()`.
+// A nil `lookupUid` uses `bcp.LookupUid()`.  Findings are added to `rep` if
+// it is non-nil.
+func CheckMemberDirs(
+	fs aclfs.FS, entries []Entry, lookupUid bcp.UidFunc, rep *Report,
+) (ok bool, err error) {
+	if lookupUid == nil {
+		lookupUid = bcp.LookupUid
+	}
+	ok = true
+	for _, e := range entries {
+		if !e.MemberDirs {
+			continue
+		}
+		members := make(map[string]bool)
+		for _, m := range e.Members {
+			members[m] = true
+		}
+
+		children, err := fs.ReadDir(e.Path)
+		if os.IsNotExist(err) {
+			continue // Reported by `CheckACLs()`.
+		}
+		if err != nil {
+			return false, fmt.Errorf(
+				"failed to list `%s`: %v", e.Path, err,
+			)
+		}
+		for _, c := range children {
+			if !c.IsDir() || members[c.Name()] {
+				continue
+			}
+			if _, err := lookupUid(c.Name()); err != nil {
+				continue // Not a user dir.
+			}
+			ok = false
+			path := filepath.Join(e.Path, c.Name())
+			msg := fmt.Sprintf(
+				"Stale member dir `%s` of non-member `%s`",
+				path, c.Name(),
+			)
+			logger.Error(msg)
+			rep.add(entries, Finding{
+				Category: CategoryStaleMemberDir,
+				Path:     path,
+				Actual:   c.Name(),
+			})
+		}
+	}
+	return ok, nil
+}
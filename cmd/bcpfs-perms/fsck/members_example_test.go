@@ -0,0 +1,66 @@
+package fsck_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsck"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+)
+
+func ExampleCheckMemberDirs() {
+	gs := append([]grp.Group{}, groups...)
+	for i := range gs {
+		if gs[i].Name == "org_ag-bar" {
+			gs[i].Members = []string{"bob", "alice", "carol"}
+		}
+	}
+	uids := map[string]int{"alice": 1001, "bob": 1002, "dave": 1004}
+	lookup := func(name string) (int, error) {
+		if uid, ok := uids[name]; ok {
+			return uid, nil
+		}
+		return -1, fmt.Errorf("unknown user `%s`", name)
+	}
+	people := rootdir + "/org/ag-bar/people"
+
+	x := newExample(`
+orgUnit {
+    name = "ag-bar"
+    subdirs = [
+        { name = "people", policy = "owner", memberDirs = true },
+    ]
+}
+`, gs, fsapply.Options{LookupUid: lookup})
+	x.check(fsck.Options{LookupUid: lookup})
+
+	// Members may modify their dirs and create other dirs.
+	_ = x.fs.Chmod(people+"/alice", 0755)
+	_ = x.fs.Mkdir(people+"/project-x", 0770)
+	x.check(fsck.Options{LookupUid: lookup})
+
+	// Directories of users who left the group are reported but kept.
+	_ = x.fs.Mkdir(people+"/dave", 0700)
+	_ = x.fs.Lchown(people+"/dave", 1004, 2004)
+	x.check(fsck.Options{LookupUid: lookup})
+	_ = x.apply(fsapply.Options{LookupUid: lookup})
+	x.check(fsck.Options{LookupUid: lookup})
+
+	// Missing member dirs are reported.
+	_ = x.fs.Remove(people + "/bob")
+	x.check(fsck.Options{LookupUid: lookup})
+
+	// Output:
+	// reason: ""
+	// reason: ""
+	// reason: "checks failed: [member-dirs]"
+	// stale-member-dir path=/orgfs/data/org/ag-bar/people/dave ou=ag-bar
+	//     actual: dave
+	// reason: "checks failed: [member-dirs]"
+	// stale-member-dir path=/orgfs/data/org/ag-bar/people/dave ou=ag-bar
+	//     actual: dave
+	// reason: "checks failed: [acls member-dirs]"
+	// acl path=/orgfs/data/org/ag-bar/people/bob ou=ag-bar
+	// stale-member-dir path=/orgfs/data/org/ag-bar/people/dave ou=ag-bar
+	//     actual: dave
+}
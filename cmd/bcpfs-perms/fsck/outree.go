@@ -15,6 +15,7 @@ type OrgUnitTreePaths struct {
 	services   []bcp.Service
 	orgUnits   []bcp.OrgUnit
 	filter     bfilter.OrgServiceFilter
+	lookupUid  bcp.UidFunc
 }
 
 // `OrgUnitDirsList()` lists expected paths `/orgfs/org/<ou>`.
@@ -25,6 +26,7 @@ func (ot *OrgUnitTreePaths) OrgUnitDirsList() (list []Entry) {
 		list = append(list, Entry{
 			Path:      path,
 			IsSymlink: false,
+			OrgUnit:   o.Name,
 			ACL: OrgUnitACL{
 				Uid: 0,
 				Gid: ouG.Gid,
@@ -52,6 +54,8 @@ func (ot *OrgUnitTreePaths) OrgUnitServiceLinksList() (list []Entry) {
 			Path:      path,
 			IsSymlink: true,
 			LinkDest:  dest,
+			OrgUnit:   ou.Name,
+			Service:   s.Name,
 		})
 	}
 
@@ -83,6 +87,12 @@ func (ot *OrgUnitTreePaths) OrgUnitSubdirsList() (list []Entry) {
 		ent := Entry{
 			Path:      path,
 			IsSymlink: false,
+			Recursive: true,
+			OrgUnit:   o.Name,
+		}
+		if d.MemberDirs {
+			ent.MemberDirs = true
+			ent.Members = o.Members()
 		}
 		switch d.Policy {
 		case bcp.GroupPolicy:
@@ -104,3 +114,38 @@ func (ot *OrgUnitTreePaths) OrgUnitSubdirsList() (list []Entry) {
 	}
 	return
 }
+
+// `MemberDirsList()` lists expected per-member dirs
+// `/orgfs/org/<ou>/<subdir>/<user>`.  Members whose uid is unknown are
+// skipped.
+func (ot *OrgUnitTreePaths) MemberDirsList() (list []Entry) {
+	for _, o := range ot.orgUnits {
+		for _, d := range o.Subdirs {
+			if !d.MemberDirs {
+				continue
+			}
+			subdir := filepath.Join(ot.root, o.Name, d.Name)
+			dirs, unknown := o.MemberDirs(ot.lookupUid)
+			for _, u := range unknown {
+				msg := fmt.Sprintf(
+					"Skipped member dir in `%s` "+
+						"of unknown user `%s`.",
+					subdir, u,
+				)
+				logger.Info(msg)
+			}
+			for _, md := range dirs {
+				list = append(list, Entry{
+					Path:    filepath.Join(subdir, md.User),
+					OrgUnit: o.Name,
+					ACL: MemberDirACL{
+						Uid: md.Uid,
+						Gid: o.OrgUnitGroup.Gid,
+					},
+					CreateOnly: true,
+				})
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package fsck
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// `Category` enumerates the kinds of findings.
+type Category string
+
+const (
+	CategoryUnexpected      Category = "unexpected"
+	CategorySymlink         Category = "symlink"
+	CategoryExplicitSymlink Category = "explicit-symlink"
+	CategoryACL             Category = "acl"
+
+	CategorySharingACL       Category = "sharing-acl"
+	CategorySharingTraversal Category = "sharing-traversal"
+	CategorySharingTree      Category = "sharing-tree"
+	CategorySharingDangling  Category = "sharing-dangling"
+
+	CategoryTreeACL   Category = "tree-acl"
+	CategoryTreeError Category = "tree-error"
+
+	CategoryStaleMemberDir Category = "stale-member-dir"
+
+	CategoryGroupSuper        Category = "group-super"
+	CategoryGroupOps          Category = "group-ops"
+	CategoryGroupEmptyService Category = "group-empty-service"
+)
+
+// `Finding` is a single deviation of `Path` from the expected state.
+// `Expected` and `Actual` describe the deviation in a category-specific text
+// format:
+//
+//  - `CategoryUnexpected`: `Actual` is the file type, like `dir`.
+//  - `CategorySymlink`, `CategoryExplicitSymlink`: `Expected` is the symlink
+//    target; `Actual` is the actual target or the reason why it could not be
+//    determined, like `not a symlink`.
+//  - `CategoryACL`, `CategoryTreeACL`: `Expected` and `Actual` are the owner,
+//    group, flags, and ACL entries in the `getfacl` text format with lines
+//    joined by comma.
+//  - `CategorySharingACL`, `CategorySharingTraversal`: `Expected` is a
+//    missing ACL entry, like `default:group:1001:r-x`; `Actual` is the
+//    actual entry for the same group if any.  For unexpected entries,
+//    `Expected` is empty.
+//  - `CategorySharingTree`: `Expected` and `Actual` are the symlink target or
+//    the file type, like `dir`; a missing path has an empty `Actual`, an
+//    unexpected path an empty `Expected`.
+//  - `CategorySharingDangling`: `Expected` is the symlink target; `Actual` is
+//    the error when resolving it.
+//  - `CategoryTreeError`: `Actual` is the error that prevented checking the
+//    path.
+//  - `CategoryStaleMemberDir`: `Actual` is the name of the user who is no
+//    longer a member of the org unit group.
+//  - `CategoryGroupSuper`, `CategoryGroupOps`: `User` is a member of `Group`
+//    but not of the group `Expected`.  `Path` is empty.
+//  - `CategoryGroupEmptyService`: `Group` is the empty service group.  `Path`
+//    is empty.
+//
+// `OrgUnit` and `Service` are the names of the related org unit and service if
+// they can be determined from the path.
+type Finding struct {
+	Category Category `json:"category"`
+	Path     string   `json:"path,omitempty"`
+	Group    string   `json:"group,omitempty"`
+	User     string   `json:"user,omitempty"`
+	Expected string   `json:"expected,omitempty"`
+	Actual   string   `json:"actual,omitempty"`
+	OrgUnit  string   `json:"orgUnit,omitempty"`
+	Service  string   `json:"service,omitempty"`
+}
+
+// `Report` collects findings.  `CheckPermissions()` adds findings to a
+// non-nil report in addition to logging them.  `Trees` contains summaries of
+// recursive checks, see `CheckTrees()`.
+type Report struct {
+	Findings []Finding     `json:"findings"`
+	Trees    []TreeSummary `json:"trees,omitempty"`
+}
+
+func NewReport() *Report {
+	return &Report{Findings: make([]Finding, 0)}
+}
+
+// `add()` appends `f` if the report is non-nil.  The related org unit and
+// service are taken from the nearest entry at or above the path.
+func (r *Report) add(entries []Entry, f Finding) {
+	if r == nil {
+		return
+	}
+	if e, ok := nearestEntry(entries, f.Path); ok {
+		f.OrgUnit = e.OrgUnit
+		f.Service = e.Service
+	}
+	r.Findings = append(r.Findings, f)
+}
+
+func (r *Report) IsEmpty() bool {
+	return len(r.Findings) == 0
+}
+
+// `JSON()` returns the report as indented JSON.
+func (r *Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// `nearestEntry()` returns the entry for `path` or its closest ancestor.
+func nearestEntry(entries []Entry, path string) (Entry, bool) {
+	var best Entry
+	found := false
+	for _, e := range entries {
+		if e.Path != path && !strings.HasPrefix(path, e.Path+"/") {
+			continue
+		}
+		if !found || len(e.Path) > len(best.Path) {
+			best = e
+			found = true
+		}
+	}
+	return best, found
+}
+
+// `fileType()` returns a short description of the file type for reporting.
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode.IsDir():
+		return "dir"
+	case mode.IsRegular():
+		return "file"
+	default:
+		return "other"
+	}
+}
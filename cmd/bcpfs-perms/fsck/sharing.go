@@ -0,0 +1,431 @@
+package fsck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+// `CheckSharing()` verifies the NOE-9 sharing specification `sharing` that
+// has been compiled with `bcpsharing.Compile()`.  `groups` are used to map
+// group names to gids.  Like `CheckPermissions()`, it returns `reason=""` if
+// all checks passed and logs failures and returns a `reason` otherwise.
+//
+// `CheckSharing()` is independent from `bcpsharingapply`.  It verifies:
+//
+//  - that real share directories have the named group entries of the share
+//    in the access and default ACL and no named group entries other than for
+//    the share and the managing groups;
+//  - that traversal directories have `--x` entries for the share groups;
+//  - that the `<ou>/shared` trees contain exactly the expected directories
+//    and symlinks and that the symlinks are not dangling.
+//
+// Only the toplevel directory of a real share is inspected.  Real shares and
+// traversal directories that do not exist are skipped, like in
+// `bcpsharingapply`.  Paths that are not selected by `opts.Scope` are skipped,
+// too.
+func CheckSharing(
+	sharing *bcpsharing.Sharing, groups []grp.Group, opts *Options,
+) (reason string, err error) {
+	var failures []string
+	fs := opts.FS
+	if fs == nil {
+		fs = aclfs.OS{}
+	}
+	rep := opts.Report
+
+	gids := make(map[string]int)
+	for _, g := range groups {
+		gids[g.Name] = g.Gid
+	}
+	sc := &sharingChecker{
+		fs:    fs,
+		bcpfs: sharing.Bcpfs,
+		gids:  gids,
+		scope: opts.Scope,
+		rep:   rep,
+	}
+
+	if ok, err := sc.checkRealShares(sharing.RealShares); err != nil {
+		return "", err
+	} else if !ok {
+		failures = append(failures, "sharing-acls")
+	}
+
+	if ok, err := sc.checkTraversal(sharing.Traversal); err != nil {
+		return "", err
+	} else if !ok {
+		failures = append(failures, "sharing-traversal")
+	}
+
+	if ok, err := sc.checkShareTrees(sharing.ShareTrees); err != nil {
+		return "", err
+	} else if !ok {
+		failures = append(failures, "sharing-trees")
+	}
+
+	if len(failures) > 0 {
+		return fmt.Sprintf("checks failed: %s", failures), nil
+	}
+
+	return "", nil
+}
+
+type sharingChecker struct {
+	fs    aclfs.FS
+	bcpfs *bcpsharing.Bcpfs
+	gids  map[string]int
+	scope *bcpscope.Scope
+	rep   *Report
+}
+
+// `selects()` tells whether the realpath `relpath` is in scope.
+func (sc *sharingChecker) selects(relpath string) bool {
+	return sc.scope.Selects(sc.abspath(relpath))
+}
+
+// `fail()` logs `msg` and adds `f` to the report with the org unit and
+// service that are related to the realpath `relpath`.
+func (sc *sharingChecker) fail(relpath, msg string, f Finding) {
+	logger.Error(msg)
+	f.OrgUnit, f.Service = sc.related(relpath)
+	sc.rep.add(nil, f)
+}
+
+// `related()` returns the org unit and the service of a realpath relative to
+// the root dir, that is `<orgUnitDir>/<ou>/...` or `<serviceDir>/<srv>/<ou>`.
+func (sc *sharingChecker) related(relpath string) (ou, srv string) {
+	parts := strings.Split(relpath, "/")
+	switch {
+	case len(parts) >= 2 && parts[0] == sc.bcpfs.OrgUnitDir:
+		return parts[1], ""
+	case len(parts) >= 3 && parts[0] == sc.bcpfs.ServiceDir:
+		return parts[2], parts[1]
+	case len(parts) >= 2 && parts[0] == sc.bcpfs.ServiceDir:
+		return "", parts[1]
+	}
+	return "", ""
+}
+
+func (sc *sharingChecker) abspath(relpath string) string {
+	return filepath.Join(sc.bcpfs.Rootdir, relpath)
+}
+
+// `groupEntries()` maps the ACL of a share to named group entries.
+func (sc *sharingChecker) groupEntries(
+	acl bcpsharing.Acl,
+) (posixacl.ACL, error) {
+	entries := make(posixacl.ACL, 0, len(acl))
+	for _, ace := range acl {
+		name := sc.bcpfs.FsGroupOrgUnit(ace.Group)
+		gid, ok := sc.gids[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown group `%s`", name)
+		}
+		perm, err := posixacl.ParsePerm(string(ace.Mode))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, posixacl.Entry{
+			Tag: posixacl.TagGroup, ID: gid, Perm: perm,
+		})
+	}
+	return entries, nil
+}
+
+// `existingDirACL()` returns the ACL of a directory.  It returns `ok=false`
+// without error if the directory does not exist.
+func (sc *sharingChecker) existingDirACL(
+	relpath string,
+) (acl posixacl.FileACL, ok bool, err error) {
+	path := sc.abspath(relpath)
+	fi, err := sc.fs.Stat(path)
+	if os.IsNotExist(err) {
+		msg := fmt.Sprintf("Skipped missing sharing dir `%s`.", path)
+		logger.Debug(msg)
+		return acl, false, nil
+	}
+	if err != nil {
+		return acl, false, err
+	}
+	if !fi.IsDir() {
+		msg := fmt.Sprintf("Skipped sharing non-dir `%s`.", path)
+		logger.Debug(msg)
+		return acl, false, nil
+	}
+	acl, err = sc.fs.GetACL(path)
+	return acl, err == nil, err
+}
+
+func (sc *sharingChecker) checkRealShares(
+	realShares bcpsharing.RealExports,
+) (ok bool, err error) {
+	ok = true
+	for _, rs := range realShares {
+		if !sc.selects(rs.Path) {
+			continue
+		}
+		actual, exists, err := sc.existingDirACL(rs.Path)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			continue
+		}
+
+		want, err := sc.groupEntries(rs.Acl)
+		if err != nil {
+			return false, fmt.Errorf(
+				"real share `%s`: %v", rs.Path, err,
+			)
+		}
+		allowed := make(map[int]bool)
+		for _, e := range want {
+			allowed[e.ID] = true
+		}
+		for _, name := range sc.bcpfs.FsGroups(rs.ManagingGroups) {
+			if gid, ok := sc.gids[name]; ok {
+				allowed[gid] = true
+			}
+		}
+
+		path := sc.abspath(rs.Path)
+		check := func(prefix string, acl posixacl.ACL) {
+			for _, e := range want {
+				if acl.Has(e) {
+					continue
+				}
+				ok = false
+				got := ""
+				if a, found := acl.Find(e); found {
+					got = prefix + a.String()
+				}
+				msg := fmt.Sprintf(
+					"sharing ACL `%s` failure; "+
+						"expected `%s%s`, got `%s`",
+					path, prefix, e, got,
+				)
+				sc.fail(rs.Path, msg, Finding{
+					Category: CategorySharingACL,
+					Path:     path,
+					Expected: prefix + e.String(),
+					Actual:   got,
+				})
+			}
+			for _, e := range acl.NamedGroups() {
+				if allowed[e.ID] {
+					continue
+				}
+				ok = false
+				msg := fmt.Sprintf(
+					"sharing ACL `%s` failure; "+
+						"unexpected `%s%s`",
+					path, prefix, e,
+				)
+				sc.fail(rs.Path, msg, Finding{
+					Category: CategorySharingACL,
+					Path:     path,
+					Actual:   prefix + e.String(),
+				})
+			}
+		}
+		check("", actual.Access)
+		check("default:", actual.Default)
+	}
+	return ok, nil
+}
+
+func (sc *sharingChecker) checkTraversal(
+	traversal bcpsharing.RealExports,
+) (ok bool, err error) {
+	ok = true
+	for _, tr := range traversal {
+		if !sc.selects(tr.Path) {
+			continue
+		}
+		actual, exists, err := sc.existingDirACL(tr.Path)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			continue
+		}
+
+		entries, err := sc.groupEntries(tr.Acl)
+		if err != nil {
+			return false, fmt.Errorf(
+				"traversal `%s`: %v", tr.Path, err,
+			)
+		}
+
+		path := sc.abspath(tr.Path)
+		for _, e := range entries {
+			e.Perm = posixacl.PermExecute
+			got := ""
+			if a, found := actual.Access.Find(e); found {
+				if a.Perm&posixacl.PermExecute != 0 {
+					continue
+				}
+				got = a.String()
+			}
+			ok = false
+			msg := fmt.Sprintf(
+				"sharing traversal `%s` failure; "+
+					"expected `%s`, got `%s`",
+				path, e, got,
+			)
+			sc.fail(tr.Path, msg, Finding{
+				Category: CategorySharingTraversal,
+				Path:     path,
+				Expected: e.String(),
+				Actual:   got,
+			})
+		}
+	}
+	return ok, nil
+}
+
+func (sc *sharingChecker) checkShareTrees(
+	trees bcpsharing.ShareTrees,
+) (ok bool, err error) {
+	ok = true
+	for _, tree := range trees {
+		treeOk, err := sc.checkShareTree(tree)
+		if err != nil {
+			return false, err
+		}
+		ok = ok && treeOk
+	}
+	return ok, nil
+}
+
+// `checkShareTree()` compares the `<ou>/shared` tree with the expected files.
+// Expected symlinks are also resolved to detect dangling targets.
+func (sc *sharingChecker) checkShareTree(
+	tree bcpsharing.ShareTree,
+) (ok bool, err error) {
+	treeRel := filepath.Join(sc.bcpfs.OrgUnitDir, tree.OrgUnit, "shared")
+	treeRoot := sc.abspath(treeRel)
+	if !sc.selects(treeRel) {
+		return true, nil
+	}
+
+	expected := make(map[string]string)
+	for _, f := range tree.Files {
+		expected[f.Path] = f.Target
+	}
+
+	// `actual` maps realpaths to symlink targets or `dir`, `file`, or
+	// `other` for non-symlinks.
+	actual := make(map[string]string)
+	isLink := make(map[string]bool)
+	walkFn := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == treeRoot {
+			return nil
+		}
+		rel, err := filepath.Rel(sc.bcpfs.Rootdir, path)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			actual[rel] = fileType(fi.Mode())
+			return nil
+		}
+		target, err := sc.fs.Readlink(path)
+		if err != nil {
+			return err
+		}
+		actual[rel] = target
+		isLink[rel] = true
+		return nil
+	}
+	err = aclfs.Walk(sc.fs, treeRoot, walkFn)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ok = true
+	fail := func(relpath, msg string, f Finding) {
+		ok = false
+		f.Path = sc.abspath(relpath)
+		f.Category = CategorySharingTree
+		logger.Error(fmt.Sprintf(
+			"sharing tree `%s` failure; %s", f.Path, msg,
+		))
+		f.OrgUnit = tree.OrgUnit
+		sc.rep.add(nil, f)
+	}
+
+	for _, f := range tree.Files {
+		want := f.Target
+		if f.IsDir() {
+			want = "dir"
+		}
+		got, found := actual[f.Path]
+		if !found {
+			fail(f.Path, fmt.Sprintf("missing `%s`", want), Finding{
+				Expected: want,
+			})
+			continue
+		}
+		if f.IsDir() == isLink[f.Path] || got != want {
+			fail(f.Path, fmt.Sprintf(
+				"expected `%s`, got `%s`", want, got,
+			), Finding{
+				Expected: want,
+				Actual:   got,
+			})
+			continue
+		}
+
+		if f.IsSymlink() {
+			path := sc.abspath(f.Path)
+			if _, err := sc.fs.Stat(path); err != nil {
+				ok = false
+				msg := fmt.Sprintf(
+					"dangling sharing symlink `%s`: %v",
+					path, err,
+				)
+				logger.Error(msg)
+				sc.rep.add(nil, Finding{
+					Category: CategorySharingDangling,
+					Path:     path,
+					Expected: f.Target,
+					Actual:   err.Error(),
+					OrgUnit:  tree.OrgUnit,
+				})
+			}
+		}
+	}
+
+	var unexpected []string
+	for rel := range actual {
+		if _, ok := expected[rel]; !ok {
+			unexpected = append(unexpected, rel)
+		}
+	}
+	sort.Strings(unexpected)
+	for _, rel := range unexpected {
+		got := actual[rel]
+		if isLink[rel] {
+			got = "symlink"
+		}
+		fail(rel, "unexpected path", Finding{Actual: got})
+	}
+
+	return ok, nil
+}
@@ -0,0 +1,177 @@
+package fsck_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharingapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsck"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+// `sharingCfg` shares a directory of `ag-foo` in `mic1` with `ag-bar`.
+const sharingCfg = `
+orgUnit {
+    name = "ag-bar"
+    subdirs = [
+        { name = "shared", policy = "manager" },
+    ]
+}
+
+sharing {
+    namingPolicy { action = "allow", match = "ag-foo/mic1(/.*)?" }
+
+    export {
+        path = "ag-foo/mic1/data"
+        acl = [ "group:ag-bar:r-x" ]
+    }
+
+    import { action = "accept", group = "ag-bar", match = "ag-foo/.*" }
+}
+`
+
+// `applySharing()` applies the sharing config like `apply-sharing`.
+func (x *example) applySharing() *bcpsharing.Sharing {
+	sharing, err := bcpsharing.Compile(x.cfg)
+	if err != nil {
+		panic(err)
+	}
+	opts := &bcpsharingapply.Options{Groups: x.groups, FS: x.fs}
+	lg := nopLogger{}
+	if err := bcpsharingapply.EnsureRealShares(
+		lg, opts, sharing.Bcpfs, sharing.RealShares,
+	); err != nil {
+		panic(err)
+	}
+	if err := bcpsharingapply.EnsureTraversal(
+		lg, opts, sharing.Bcpfs, sharing.Traversal,
+	); err != nil {
+		panic(err)
+	}
+	if err := bcpsharingapply.EnsureShareTrees(
+		lg, opts, sharing.Bcpfs, sharing.ShareTrees,
+	); err != nil {
+		panic(err)
+	}
+	return sharing
+}
+
+func ExampleCheckSharing() {
+	const (
+		data    = rootdir + "/srv/mic1/ag-foo/data"
+		fooTree = rootdir + "/org/ag-foo/shared/ag-foo/mic1/data"
+		barTree = rootdir + "/org/ag-bar/shared/ag-foo/mic1/data"
+	)
+	modifyACL := func(path string, entries ...string) func(*aclfs.MemFS) {
+		return func(fs *aclfs.MemFS) {
+			acl, _ := fs.GetACL(path)
+			m := posixacl.MustParseFileACL(entries...)
+			_ = aclfs.UpdateACL(fs, path, acl, acl.Modify(m))
+		}
+	}
+	removeACL := func(path string, entries ...string) func(*aclfs.MemFS) {
+		return func(fs *aclfs.MemFS) {
+			acl, _ := fs.GetACL(path)
+			r := posixacl.MustParseFileACL(entries...)
+			_ = aclfs.UpdateACL(fs, path, acl, acl.Remove(r))
+		}
+	}
+
+	cases := []struct {
+		name   string
+		modify func(fs *aclfs.MemFS)
+	}{
+		{
+			name:   "unmodified",
+			modify: func(fs *aclfs.MemFS) {},
+		},
+		{
+			name:   "missing ACL",
+			modify: removeACL(data, "default:group:2004"),
+		},
+		{
+			name:   "extra ACL",
+			modify: modifyACL(data, "group:2005:rwx"),
+		},
+		{
+			name:   "traversal",
+			modify: modifyACL(rootdir+"/org/ag-foo", "group:2004:r--"),
+		},
+		{
+			name: "stale symlink",
+			modify: func(fs *aclfs.MemFS) {
+				_ = fs.Remove(barTree)
+				_ = fs.Symlink("../data", barTree)
+			},
+		},
+		{
+			name: "unexpected and missing",
+			modify: func(fs *aclfs.MemFS) {
+				_ = fs.Remove(fooTree)
+				_ = fs.Mkdir(fooTree+"2", 0755)
+			},
+		},
+		{
+			name: "dangling",
+			modify: func(fs *aclfs.MemFS) {
+				_ = fs.Remove(data)
+			},
+		},
+	}
+	for _, c := range cases {
+		fmt.Println("#", c.name)
+		x := newExample(sharingCfg, groups, fsapply.Options{})
+		_ = x.fs.Mkdir(data, 0755)
+		_ = x.apply(fsapply.Options{Recursive: true})
+		sharing := x.applySharing()
+		c.modify(x.fs)
+
+		rep := fsck.NewReport()
+		reason, err := fsck.CheckSharing(
+			sharing, x.groups, &fsck.Options{FS: x.fs, Report: rep},
+		)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("reason: %q\n", reason)
+		printFindings(rep)
+	}
+
+	// Output:
+	// # unmodified
+	// reason: ""
+	// # missing ACL
+	// reason: "checks failed: [sharing-acls]"
+	// sharing-acl path=/orgfs/data/srv/mic1/ag-foo/data ou=ag-foo srv=mic1
+	//     expected: default:group:2004:r-x
+	// # extra ACL
+	// reason: "checks failed: [sharing-acls]"
+	// sharing-acl path=/orgfs/data/srv/mic1/ag-foo/data ou=ag-foo srv=mic1
+	//     actual: group:2005:rwx
+	// # traversal
+	// reason: "checks failed: [sharing-traversal]"
+	// sharing-traversal path=/orgfs/data/org/ag-foo ou=ag-foo
+	//     expected: group:2004:--x
+	//     actual: group:2004:r--
+	// # stale symlink
+	// reason: "checks failed: [sharing-trees]"
+	// sharing-tree path=/orgfs/data/org/ag-bar/shared/ag-foo/mic1/data ou=ag-bar
+	//     expected: ../../../../ag-foo/mic1/data
+	//     actual: ../data
+	// # unexpected and missing
+	// reason: "checks failed: [sharing-trees]"
+	// sharing-tree path=/orgfs/data/org/ag-foo/shared/ag-foo/mic1/data ou=ag-foo
+	//     expected: ../../../../ag-foo/mic1/data
+	// sharing-tree path=/orgfs/data/org/ag-foo/shared/ag-foo/mic1/data2 ou=ag-foo
+	//     actual: dir
+	// # dangling
+	// reason: "checks failed: [sharing-trees]"
+	// sharing-dangling path=/orgfs/data/org/ag-bar/shared/ag-foo/mic1/data ou=ag-bar
+	//     expected: ../../../../ag-foo/mic1/data
+	//     actual: stat /orgfs/data/org/ag-bar/shared/ag-foo/mic1/data: no such file or directory
+	// sharing-dangling path=/orgfs/data/org/ag-foo/shared/ag-foo/mic1/data ou=ag-foo
+	//     expected: ../../../../ag-foo/mic1/data
+	//     actual: stat /orgfs/data/org/ag-foo/shared/ag-foo/mic1/data: no such file or directory
+}
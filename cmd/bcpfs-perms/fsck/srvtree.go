@@ -27,6 +27,7 @@ func (st *ServiceTreePaths) ServiceDirsList() (list []Entry) {
 		entry := Entry{
 			Path:      path,
 			IsSymlink: false,
+			Service:   s.Name,
 			ACL: ServiceACL{
 				Uid:           0,
 				ServiceGid:    srvG.Gid,
@@ -53,6 +54,9 @@ func (st *ServiceTreePaths) ServiceOrgUnitDirsList() (list []Entry) {
 		list = append(list, Entry{
 			Path:      path,
 			IsSymlink: false,
+			Recursive: true,
+			OrgUnit:   ou.Name,
+			Service:   s.Name,
 			ACL: ServiceOrgUnitACL{
 				Uid:           0,
 				OrgUnitGid:    ouG.Gid,
@@ -71,10 +75,32 @@ func (st *ServiceTreePaths) ServiceOrgUnitDirsList() (list []Entry) {
 		logger.Debug(msg)
 	}
 
+	// Dirs whose filter rule has expired may be kept without ou access.
+	appendExpiredSOU := func(s bcp.Service, ou bcp.OrgUnit) {
+		list = append(list, Entry{
+			Path:      filepath.Join(st.root, s.Name, ou.Name),
+			IsSymlink: false,
+			OrgUnit:   ou.Name,
+			Service:   s.Name,
+			Optional:  true,
+			ACL: ExpiredServiceOrgUnitACL{
+				Uid:           0,
+				OrgUnitGid:    ou.OrgUnitGroup.Gid,
+				ServiceGid:    s.ServiceGroup.Gid,
+				ServiceOpsGid: s.ServiceOpsGroup.Gid,
+				SuperGid:      s.SuperGroup.Gid,
+			},
+		})
+	}
+
 	for _, s := range st.services {
 		for _, ou := range st.orgUnits {
 			if ok, reason := st.filter.Accept(s, ou); ok {
 				appendSOU(s, ou)
+			} else if expired, _ := bfilter.IsExpired(
+				st.filter, s, ou,
+			); expired {
+				appendExpiredSOU(s, ou)
 			} else {
 				logSkip(s, ou, reason)
 			}
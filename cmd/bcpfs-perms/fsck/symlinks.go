@@ -3,16 +3,22 @@ package fsck
 import (
 	"fmt"
 	"os"
+	"sort"
+
+	"github.com/nogproject/bcpfs/pkg/aclfs"
 )
 
-// `CheckSymlinks()` verifies the symlink `entries`.
-func CheckSymlinks(entries []Entry) (ok bool, err error) {
+// `CheckSymlinks()` verifies the symlink `entries`.  Failures are added to
+// `rep` if it is non-nil.
+func CheckSymlinks(
+	fs aclfs.FS, entries []Entry, rep *Report,
+) (ok bool, err error) {
 	ok = true
 	for _, p := range entries {
 		if !p.IsSymlink {
 			continue
 		}
-		reason, err := checkSymlink(p.LinkDest, p.Path)
+		actual, reason, err := checkSymlink(fs, p.LinkDest, p.Path)
 		if err != nil {
 			return false, err
 		}
@@ -25,16 +31,33 @@ func CheckSymlinks(entries []Entry) (ok bool, err error) {
 			p.Path, p.LinkDest, reason,
 		)
 		logger.Error(msg)
+		rep.add(entries, Finding{
+			Category: CategorySymlink,
+			Path:     p.Path,
+			Expected: p.LinkDest,
+			Actual:   actual,
+		})
 	}
 	return ok, nil
 }
 
 // `CheckExplicitSymlinks()` verifies `symlinks`, where the keys are symlink
-// paths and the values are symlink targets.
-func CheckExplicitSymlinks(symlinks map[string]string) (ok bool, err error) {
+// paths and the values are symlink targets.  Failures are added to `rep` if it
+// is non-nil; `entries` are used to determine the related org unit and
+// service.
+func CheckExplicitSymlinks(
+	fs aclfs.FS, entries []Entry, symlinks map[string]string, rep *Report,
+) (ok bool, err error) {
+	paths := make([]string, 0, len(symlinks))
+	for path := range symlinks {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
 	ok = true
-	for path, target := range symlinks {
-		reason, err := checkSymlink(target, path)
+	for _, path := range paths {
+		target := symlinks[path]
+		actual, reason, err := checkSymlink(fs, target, path)
 		if err != nil {
 			return false, err
 		}
@@ -47,24 +70,35 @@ func CheckExplicitSymlinks(symlinks map[string]string) (ok bool, err error) {
 			path, target, reason,
 		)
 		logger.Error(msg)
+		rep.add(entries, Finding{
+			Category: CategoryExplicitSymlink,
+			Path:     path,
+			Expected: target,
+			Actual:   actual,
+		})
 	}
 	return ok, nil
 }
 
-func checkSymlink(dest string, path string) (reason string, err error) {
-	st, err := os.Lstat(path)
+// `checkSymlink()` returns `reason=""` if `path` is a symlink to `dest`.
+// Otherwise, `actual` is the actual symlink target or the same as `reason` if
+// `path` is not a symlink.
+func checkSymlink(
+	fs aclfs.FS, dest string, path string,
+) (actual, reason string, err error) {
+	st, err := fs.Lstat(path)
 	if err != nil {
-		return err.Error(), nil
+		return err.Error(), err.Error(), nil
 	}
 	if st.Mode()&os.ModeSymlink == 0 {
-		return "not a symlink", nil
+		return "not a symlink", "not a symlink", nil
 	}
-	actual, err := os.Readlink(path)
+	actual, err = fs.Readlink(path)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	if actual != dest {
-		return fmt.Sprintf("got `%s`", actual), nil
+		return actual, fmt.Sprintf("got `%s`", actual), nil
 	}
-	return "", nil
+	return actual, "", nil
 }
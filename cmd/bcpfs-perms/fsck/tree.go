@@ -0,0 +1,263 @@
+package fsck
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+// `maxTreeFindings` limits the number of reported deviations and the number of
+// reported errors per tree.  Further ones are only counted in the
+// `TreeSummary`.
+const maxTreeFindings = 20
+
+// `TreeSummary` summarizes the recursive check of the tree below `Path`.
+// `Dirs`, `Files`, and `Others` count the inspected paths, `Skipped` the
+// files that were skipped due to sampling, `Deviations` the inspected paths
+// that differ from the expected state, and `Errors` the paths that could not
+// be inspected, like directories that could not be listed.
+type TreeSummary struct {
+	Path       string `json:"path"`
+	OrgUnit    string `json:"orgUnit,omitempty"`
+	Service    string `json:"service,omitempty"`
+	Dirs       int    `json:"dirs"`
+	Files      int    `json:"files"`
+	Others     int    `json:"others"`
+	Skipped    int    `json:"skipped"`
+	Deviations int    `json:"deviations"`
+	Errors     int    `json:"errors"`
+}
+
+// `treeExpectation` is the expected state below a tree root that is derived
+// from the expected toplevel ACL, following the rules of `apply --recursive`:
+//
+//  - The group is the toplevel group.
+//  - Directories have the SGID bit.  The toplevel default ACL becomes the
+//    access ACL, and the toplevel default ACL is propagated.
+//  - Regular files have the toplevel default ACL as the access ACL but without
+//    x-bit for user, mask, and other entries.  Named group entries keep the
+//    x-bit, so that the effective permissions are only restricted via mask.
+//  - Other files, like symlinks, have only the group checked.
+//
+// The owner is not checked.  Named group entries other than `namedGids` are
+// ignored, like for the toplevel directories.
+type treeExpectation struct {
+	gid       int
+	dirACL    posixacl.FileACL
+	fileACL   posixacl.FileACL
+	namedGids []int
+}
+
+func newTreeExpectation(acl ACL) (treeExpectation, error) {
+	top, err := parseFACLString(acl.FACLString())
+	if err != nil {
+		return treeExpectation{}, err
+	}
+	dflt := top.ACL.Default
+	file := make(posixacl.ACL, 0, len(dflt))
+	for _, e := range dflt {
+		switch e.Tag {
+		case posixacl.TagUserObj, posixacl.TagMask, posixacl.TagOther:
+			e.Perm &^= posixacl.PermExecute
+		}
+		file = append(file, e)
+	}
+	return treeExpectation{
+		gid:       top.Gid,
+		dirACL:    posixacl.FileACL{Access: dflt, Default: dflt},
+		fileACL:   posixacl.FileACL{Access: file},
+		namedGids: acl.NamedGids(),
+	}, nil
+}
+
+// `expected()` returns the expected perms for a path with the actual perms
+// `actual` and mode `mode`.  Fields that are not checked are copied from
+// `actual`.
+func (x treeExpectation) expected(
+	actual filePerms, mode os.FileMode,
+) filePerms {
+	exp := actual
+	exp.Gid = x.gid
+	switch {
+	case mode.IsDir():
+		exp.Flags = fileFlags(mode | os.ModeSetgid)
+		exp.ACL = x.dirACL
+	case mode.IsRegular():
+		exp.ACL = x.fileACL
+	}
+	return exp
+}
+
+// `CheckTrees()` recursively checks the files below the non-symlink `entries`
+// with `Recursive=true`.  If `sample` is greater than 1, only every
+// `sample`-th non-directory is inspected.  Directories are always inspected.
+// Deviations are logged and added to `rep` if it is non-nil, up to
+// `maxTreeFindings` per tree.  Paths that cannot be inspected, for example due
+// to permission errors or because they have been removed during the walk, are
+// reported like deviations with category `CategoryTreeError`, and the walk
+// continues.  A summary is logged and added to `rep` for each tree.  Trees that
+// do not exist are skipped; they are reported by `CheckACLs()`.
+func CheckTrees(
+	fs aclfs.FS, entries []Entry, sample int, rep *Report,
+) (ok bool, err error) {
+	if sample < 1 {
+		sample = 1
+	}
+	ok = true
+	for _, e := range entries {
+		if e.IsSymlink || !e.Recursive {
+			continue
+		}
+		sum, err := checkTree(fs, e, sample, rep)
+		if err != nil {
+			return false, err
+		}
+		if sum == nil {
+			continue
+		}
+		if sum.Deviations > 0 || sum.Errors > 0 {
+			ok = false
+		}
+		msg := fmt.Sprintf(
+			"Checked tree `%s`: %d dirs, %d files, %d others, "+
+				"%d skipped, %d deviations, %d errors.",
+			sum.Path, sum.Dirs, sum.Files, sum.Others,
+			sum.Skipped, sum.Deviations, sum.Errors,
+		)
+		logger.Info(msg)
+		if rep != nil {
+			rep.Trees = append(rep.Trees, *sum)
+		}
+	}
+	return ok, nil
+}
+
+// `checkTree()` returns nil if the tree root does not exist.
+func checkTree(
+	fs aclfs.FS, root Entry, sample int, rep *Report,
+) (*TreeSummary, error) {
+	if fi, err := fs.Lstat(root.Path); err != nil || !fi.IsDir() {
+		msg := fmt.Sprintf("Skipped missing tree `%s`.", root.Path)
+		logger.Debug(msg)
+		return nil, nil
+	}
+
+	x, err := newTreeExpectation(root.ACL)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"invalid expected ACL for `%s`: %v", root.Path, err,
+		)
+	}
+
+	sum := &TreeSummary{
+		Path:    root.Path,
+		OrgUnit: root.OrgUnit,
+		Service: root.Service,
+	}
+	nonDirs := 0
+	// `walkErr()` records that `path` could not be inspected and continues
+	// the walk.  If `path` is a directory that could not be listed, the
+	// walk skips its children.
+	walkErr := func(path string, err error) error {
+		sum.Errors++
+		if sum.Errors > maxTreeFindings {
+			return nil
+		}
+		msg := fmt.Sprintf(
+			"Failed to check tree path `%s`: %v; continuing.",
+			path, err,
+		)
+		logger.Error(msg)
+		rep.add(nil, Finding{
+			Category: CategoryTreeError,
+			Path:     path,
+			Actual:   err.Error(),
+			OrgUnit:  root.OrgUnit,
+			Service:  root.Service,
+		})
+		if sum.Errors == maxTreeFindings {
+			msg := fmt.Sprintf(
+				"Further errors below `%s` are only counted.",
+				root.Path,
+			)
+			logger.Error(msg)
+		}
+		return nil
+	}
+	walkFn := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return walkErr(path, err)
+		}
+		if path == root.Path {
+			return nil
+		}
+		mode := fi.Mode()
+		if !mode.IsDir() {
+			nonDirs++
+			if (nonDirs-1)%sample != 0 {
+				sum.Skipped++
+				return nil
+			}
+		}
+
+		actual := filePerms{Flags: fileFlags(mode)}
+		actual.Uid, actual.Gid = aclfs.Owner(fi)
+		if mode.IsDir() || mode.IsRegular() {
+			acl, err := fs.GetACL(path)
+			if err != nil {
+				return walkErr(path, err)
+			}
+			actual.ACL = acl
+			actual = actual.onlyNamedGroups(x.namedGids)
+		}
+		switch {
+		case mode.IsDir():
+			sum.Dirs++
+		case mode.IsRegular():
+			sum.Files++
+		default:
+			sum.Others++
+		}
+
+		expected := x.expected(actual, mode)
+		if actual.equal(expected) {
+			return nil
+		}
+		sum.Deviations++
+		if sum.Deviations > maxTreeFindings {
+			return nil
+		}
+		msg := fmt.Sprintf(
+			"wrong tree ACL; ...\n"+
+				"    expected `# file: %s, %s`; ...\n"+
+				"    got      `# file: %s, %s`.",
+			path, expected, path, actual,
+		)
+		logger.Error(msg)
+		rep.add(nil, Finding{
+			Category: CategoryTreeACL,
+			Path:     path,
+			Expected: expected.String(),
+			Actual:   actual.String(),
+			OrgUnit:  root.OrgUnit,
+			Service:  root.Service,
+		})
+		if sum.Deviations == maxTreeFindings {
+			msg := fmt.Sprintf(
+				"Further deviations below `%s` "+
+					"are only counted.",
+				root.Path,
+			)
+			logger.Error(msg)
+		}
+		return nil
+	}
+	if err := aclfs.Walk(fs, root.Path, walkFn); err != nil {
+		return nil, fmt.Errorf(
+			"failed to walk `%s`: %v", root.Path, err,
+		)
+	}
+	return sum, nil
+}
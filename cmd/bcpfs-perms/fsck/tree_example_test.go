@@ -0,0 +1,154 @@
+package fsck_test
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsapply"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsck"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+// `failingFS` fails to list or read the ACLs of the paths in `fail`, like
+// paths without read permission.
+type failingFS struct {
+	*aclfs.MemFS
+	fail map[string]bool
+}
+
+func (fs failingFS) ReadDir(path string) ([]os.FileInfo, error) {
+	if fs.fail[path] {
+		return nil, &os.PathError{
+			Op: "open", Path: path, Err: syscall.EACCES,
+		}
+	}
+	return fs.MemFS.ReadDir(path)
+}
+
+func (fs failingFS) GetACL(path string) (posixacl.FileACL, error) {
+	if fs.fail[path] {
+		return posixacl.FileACL{}, &os.PathError{
+			Op: "getxattr", Path: path, Err: syscall.EACCES,
+		}
+	}
+	return fs.MemFS.GetACL(path)
+}
+
+// `checkTrees()` runs a recursive check on `fs` and prints the reason, the findings,
+// and the summary of the tree `root`.
+func (x *example) checkTrees(fs aclfs.FS, root string, sample int) {
+	rep := fsck.NewReport()
+	reason, err := fsck.CheckPermissions(
+		x.cfg, x.org, x.filter, &fsck.Options{
+			FS:        fs,
+			Report:    rep,
+			Recursive: true,
+			Sample:    sample,
+		},
+	)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("reason: %q\n", reason)
+	printFindings(rep)
+	for _, s := range rep.Trees {
+		if s.Path == root {
+			fmt.Printf(
+				"%s: %d dirs, %d files, %d others, "+
+					"%d skipped, %d deviations, %d errors\n",
+				s.Path, s.Dirs, s.Files, s.Others,
+				s.Skipped, s.Deviations, s.Errors,
+			)
+		}
+	}
+}
+
+func ExampleCheckTrees() {
+	const (
+		tree = rootdir + "/srv/mic1/ag-foo"
+		dir  = tree + "/data"
+		sub  = dir + "/sub"
+		file = dir + "/img.tif"
+	)
+	x := newExample("", groups, fsapply.Options{})
+	for _, d := range []string{dir, sub} {
+		_ = x.fs.Mkdir(d, 0777)
+	}
+	for _, p := range []string{file, dir + "/a.txt", dir + "/b.txt"} {
+		_ = x.fs.CreateFile(p, 0666)
+	}
+
+	fmt.Println("# inherited")
+	x.checkTrees(x.fs, tree, 1)
+
+	// Named user entry, wrong group, missing default ACL, narrowed mask.
+	acl, _ := x.fs.GetACL(file)
+	withUser := acl.Modify(posixacl.MustParseFileACL("user:1000:rw-"))
+	_ = aclfs.UpdateACL(x.fs, file, acl, withUser)
+	_ = x.fs.Lchown(dir+"/a.txt", -1, 0)
+	_ = x.fs.SetDefaultACL(sub, nil)
+	_ = x.fs.Chmod(dir, os.ModeSetgid|0750)
+	fmt.Println("# modified")
+	x.checkTrees(x.fs, tree, 1)
+	fmt.Println("# sampled")
+	x.checkTrees(x.fs, tree, 2)
+
+	// Apply repairs everything but the named user entry, which must be
+	// removed manually.
+	_ = x.apply(fsapply.Options{Recursive: true})
+	fmt.Println("# repaired")
+	x.check(fsck.Options{Recursive: true})
+
+	// Output:
+	// # inherited
+	// reason: ""
+	// /orgfs/data/srv/mic1/ag-foo: 2 dirs, 3 files, 0 others, 0 skipped, 0 deviations, 0 errors
+	// # modified
+	// reason: "checks failed: [tree-acls]"
+	// tree-acl path=/orgfs/data/srv/mic1/ag-foo/data ou=ag-foo srv=mic1
+	// tree-acl path=/orgfs/data/srv/mic1/ag-foo/data/a.txt ou=ag-foo srv=mic1
+	// tree-acl path=/orgfs/data/srv/mic1/ag-foo/data/img.tif ou=ag-foo srv=mic1
+	// tree-acl path=/orgfs/data/srv/mic1/ag-foo/data/sub ou=ag-foo srv=mic1
+	// /orgfs/data/srv/mic1/ag-foo: 2 dirs, 3 files, 0 others, 0 skipped, 4 deviations, 0 errors
+	// # sampled
+	// reason: "checks failed: [tree-acls]"
+	// tree-acl path=/orgfs/data/srv/mic1/ag-foo/data ou=ag-foo srv=mic1
+	// tree-acl path=/orgfs/data/srv/mic1/ag-foo/data/a.txt ou=ag-foo srv=mic1
+	// tree-acl path=/orgfs/data/srv/mic1/ag-foo/data/img.tif ou=ag-foo srv=mic1
+	// tree-acl path=/orgfs/data/srv/mic1/ag-foo/data/sub ou=ag-foo srv=mic1
+	// /orgfs/data/srv/mic1/ag-foo: 2 dirs, 2 files, 0 others, 1 skipped, 4 deviations, 0 errors
+	// # repaired
+	// reason: "checks failed: [tree-acls]"
+	// tree-acl path=/orgfs/data/srv/mic1/ag-foo/data/img.tif ou=ag-foo srv=mic1
+}
+
+func ExampleCheckTrees_errors() {
+	const (
+		tree = rootdir + "/srv/mic1/ag-foo"
+		dir  = tree + "/data"
+	)
+	x := newExample("", groups, fsapply.Options{})
+	for _, d := range []string{dir, dir + "/a", dir + "/b"} {
+		_ = x.fs.Mkdir(d, 0777)
+	}
+	for _, p := range []string{dir + "/a/1.txt", dir + "/b/2.txt"} {
+		_ = x.fs.CreateFile(p, 0666)
+	}
+	fs := failingFS{
+		MemFS: x.fs,
+		fail:  map[string]bool{dir + "/a": true, dir + "/b/2.txt": true},
+	}
+
+	// The walk continues after errors.
+	x.checkTrees(fs, tree, 1)
+
+	// Output:
+	// reason: "checks failed: [tree-acls]"
+	// tree-error path=/orgfs/data/srv/mic1/ag-foo/data/a ou=ag-foo srv=mic1
+	//     actual: open /orgfs/data/srv/mic1/ag-foo/data/a: permission denied
+	// tree-error path=/orgfs/data/srv/mic1/ag-foo/data/b/2.txt ou=ag-foo srv=mic1
+	//     actual: getxattr /orgfs/data/srv/mic1/ag-foo/data/b/2.txt: permission denied
+	// /orgfs/data/srv/mic1/ag-foo: 2 dirs, 0 files, 0 others, 0 skipped, 0 deviations, 2 errors
+}
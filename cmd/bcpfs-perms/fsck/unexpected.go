@@ -2,25 +2,31 @@ package fsck
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/nogproject/bcpfs/pkg/execx"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
+	"github.com/nogproject/bcpfs/pkg/aclfs"
 )
 
 // `CheckNoUnexpected()` checks for unexpected paths.  It returns `ok=false` if
 // there are unexpected paths and `ok=true` if there are none.  `err` is only
 // used to report problems that prevented checking, like an error accessing the
-// filesystem.
+// filesystem.  Unexpected paths are added to `rep` if it is non-nil.  Paths
+// that are not selected by `scope` are ignored; a nil `scope` selects all
+// paths.
 func CheckNoUnexpected(
+	fs aclfs.FS,
 	subroot string, entries []Entry, symlinks map[string]string,
+	scope *bcpscope.Scope, rep *Report,
 ) (ok bool, err error) {
 	pathSet := map[string]bool{}
 	for _, e := range entries {
 		pathSet[e.Path] = true
 	}
 
-	paths, err := findPaths(subroot)
+	paths, modes, err := findPaths(fs, subroot)
 	if err != nil {
 		return false, fmt.Errorf(
 			"failed to list `%s`: %v", subroot, err,
@@ -28,34 +34,56 @@ func CheckNoUnexpected(
 	}
 
 	ok = true
-	for _, p := range paths {
+	for i, p := range paths {
 		if pathSet[p] {
 			continue
 		}
 		if _, ok := symlinks[p]; ok {
 			continue
 		}
+		if !scope.Selects(p) {
+			continue
+		}
 		ok = false
 		msg := fmt.Sprintf("Unexpected path `%s`", p)
 		logger.Error(msg)
+		rep.add(entries, Finding{
+			Category: CategoryUnexpected,
+			Path:     p,
+			Actual:   fileType(modes[i]),
+		})
 	}
 
 	return ok, nil
 }
 
-func findPaths(subroot string) ([]string, error) {
-	out, err := exec.Command(
-		find.Path, subroot, "-maxdepth", "2", "-print0",
-	).Output()
-	if err != nil {
-		return nil, err
+// `findPaths()` lists `subroot` and the paths below it up to depth 2, like
+// `find <subroot> -maxdepth 2`, together with their file modes.
+func findPaths(
+	fs aclfs.FS, subroot string,
+) (paths []string, modes []os.FileMode, err error) {
+	const maxDepth = 2
+	depth := func(p string) int {
+		rel, _ := filepath.Rel(subroot, p)
+		if rel == "." {
+			return 0
+		}
+		return strings.Count(rel, "/") + 1
 	}
-	sep := "\000"
-	return strings.Split(strings.TrimRight(string(out), sep), sep), nil
-}
 
-var find = execx.MustLookTool(execx.ToolSpec{
-	Program:   "find",
-	CheckArgs: []string{"--version"},
-	CheckText: "GNU findutils",
-})
+	walkFn := func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		modes = append(modes, fi.Mode())
+		if fi.IsDir() && depth(p) >= maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if err := aclfs.Walk(fs, subroot, walkFn); err != nil {
+		return nil, nil, err
+	}
+	return paths, modes, nil
+}
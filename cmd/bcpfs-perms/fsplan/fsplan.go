@@ -0,0 +1,144 @@
+// Package `fsplan` provides `Plan`, which records filesystem changes that
+// `bcpfs-perms apply` would perform.  Packages `fsapply` and
+// `bcpsharingapply` add operations to a plan instead of modifying the
+// filesystem if they are called with a non-nil plan.
+//
+// A plan can be printed as text, with one shell-like line per operation, or as
+// JSON for further processing.
+package fsplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// `Action` enumerates the kinds of filesystem operations.
+type Action string
+
+const (
+	ActionMkdir   Action = "mkdir"
+	ActionChown   Action = "chown"
+	ActionChmod   Action = "chmod"
+	ActionSetfacl Action = "setfacl"
+	ActionSymlink Action = "symlink"
+	ActionRemove  Action = "remove"
+)
+
+// `Op` is a single filesystem operation on `Path`.  The other fields depend on
+// the `Action`:
+//
+//  - `ActionChown`: `Owner` is `<uid>:<gid>`.
+//  - `ActionChmod`: `Mode` is a symbolic mode, like `g+s`.
+//  - `ActionSetfacl`: `Modify` contains ACL entries that are added or
+//    modified; `Remove` contains ACL entries that are removed.  `Recursive`
+//    indicates that the entries are applied to the whole subtree.
+//  - `ActionSymlink`: `Target` is the symlink target.
+//
+type Op struct {
+	Action    Action   `json:"action"`
+	Path      string   `json:"path"`
+	Owner     string   `json:"owner,omitempty"`
+	Mode      string   `json:"mode,omitempty"`
+	Modify    []string `json:"modify,omitempty"`
+	Remove    []string `json:"remove,omitempty"`
+	Target    string   `json:"target,omitempty"`
+	Recursive bool     `json:"recursive,omitempty"`
+}
+
+// `String()` formats the operation similar to a shell command.
+func (op Op) String() string {
+	switch op.Action {
+	case ActionMkdir:
+		return fmt.Sprintf("mkdir %s", op.Path)
+	case ActionChown:
+		return fmt.Sprintf("chown %s %s", op.Owner, op.Path)
+	case ActionChmod:
+		return fmt.Sprintf("chmod %s %s", op.Mode, op.Path)
+	case ActionSetfacl:
+		args := []string{"setfacl"}
+		if op.Recursive {
+			args = append(args, "-R")
+		}
+		if len(op.Modify) > 0 {
+			args = append(args, "-m", strings.Join(op.Modify, ","))
+		}
+		if len(op.Remove) > 0 {
+			args = append(args, "-x", strings.Join(op.Remove, ","))
+		}
+		args = append(args, op.Path)
+		return strings.Join(args, " ")
+	case ActionSymlink:
+		return fmt.Sprintf("ln -s %s %s", op.Target, op.Path)
+	case ActionRemove:
+		return fmt.Sprintf("rm %s", op.Path)
+	default:
+		return fmt.Sprintf("%s %s", op.Action, op.Path)
+	}
+}
+
+// `Plan` is an ordered list of operations.
+type Plan struct {
+	Ops     []Op `json:"ops"`
+	created map[string]bool
+}
+
+func New() *Plan {
+	return &Plan{
+		Ops:     make([]Op, 0),
+		created: make(map[string]bool),
+	}
+}
+
+// `Add()` appends `op` to the plan.
+func (p *Plan) Add(op Op) {
+	if op.Action == ActionMkdir {
+		p.created[op.Path] = true
+	}
+	p.Ops = append(p.Ops, op)
+}
+
+func (p *Plan) IsEmpty() bool {
+	return len(p.Ops) == 0
+}
+
+// `Creates(path)` returns true if the plan contains `mkdir path`.  It is used
+// during planning to treat directories that would be created as existing and
+// empty.
+func (p *Plan) Creates(path string) bool {
+	return p.created[path]
+}
+
+// `Fork()` returns an empty plan that treats the directories that `p` creates
+// as existing.  It is used to plan independent subtrees concurrently.  The
+// forked plan is added to `p` with `Merge()`.  `p` must not be modified while
+// forks are in use.
+func (p *Plan) Fork() *Plan {
+	f := New()
+	for path := range p.created {
+		f.created[path] = true
+	}
+	return f
+}
+
+// `Merge()` appends the operations of `f` to `p`.
+func (p *Plan) Merge(f *Plan) {
+	for _, op := range f.Ops {
+		p.Add(op)
+	}
+}
+
+// `Text()` returns the plan with one line per operation.
+func (p *Plan) Text() string {
+	var b strings.Builder
+	for _, op := range p.Ops {
+		b.WriteString(op.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// `JSON()` returns the plan as indented JSON.
+func (p *Plan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
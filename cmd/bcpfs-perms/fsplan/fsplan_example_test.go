@@ -0,0 +1,68 @@
+package fsplan_test
+
+import (
+	"fmt"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsplan"
+)
+
+func ExamplePlan() {
+	plan := fsplan.New()
+	plan.Add(fsplan.Op{
+		Action: fsplan.ActionMkdir,
+		Path:   "/orgfs/srv/foo",
+	})
+	plan.Add(fsplan.Op{
+		Action: fsplan.ActionChown,
+		Path:   "/orgfs/srv/foo",
+		Owner:  "0:1001",
+	})
+	plan.Add(fsplan.Op{
+		Action: fsplan.ActionSetfacl,
+		Path:   "/orgfs/srv/foo",
+		Modify: []string{"group:1001:r-x", "mask::r-x"},
+		Remove: []string{"group:1000"},
+	})
+	plan.Add(fsplan.Op{
+		Action: fsplan.ActionSymlink,
+		Path:   "/orgfs/org/ag-alice/foo",
+		Target: "../../srv/foo/ag-alice",
+	})
+
+	fmt.Print(plan.Text())
+	fmt.Println(plan.IsEmpty())
+	fmt.Println(plan.Creates("/orgfs/srv/foo"))
+	fmt.Println(plan.Creates("/orgfs/srv/bar"))
+
+	// Output:
+	// mkdir /orgfs/srv/foo
+	// chown 0:1001 /orgfs/srv/foo
+	// setfacl -m group:1001:r-x,mask::r-x -x group:1000 /orgfs/srv/foo
+	// ln -s ../../srv/foo/ag-alice /orgfs/org/ag-alice/foo
+	// false
+	// true
+	// false
+}
+
+func ExamplePlan_JSON() {
+	plan := fsplan.New()
+	plan.Add(fsplan.Op{
+		Action: fsplan.ActionChmod,
+		Path:   "/orgfs/org/ag-alice",
+		Mode:   "g+s",
+	})
+
+	d, _ := plan.JSON()
+	fmt.Printf("%s\n", d)
+
+	// Output:
+	// {
+	//   "ops": [
+	//     {
+	//       "action": "chmod",
+	//       "path": "/orgfs/org/ag-alice",
+	//       "mode": "g+s"
+	//     }
+	//   ]
+	// }
+}
@@ -4,6 +4,24 @@
 # To see the current default settings, run `bcpfs-perms describe config` with a
 # minimal config file that contains only a `rootdir` statement.
 
+# The config may be split across several files, so that, for example, each
+# facility admin can maintain a separate file.  `bcpfs-perms --config=<dir>`
+# merges the `*.hcl` files of a directory in the order of their names.
+# Alternatively, the main config file may `include` glob patterns, which are
+# relative to the directory of the main file.  Settings like `rootdir` may be
+# repeated with the same value.  `facility`, `orgUnit`, `filter`, `symlink`,
+# and `sharing` blocks are concatenated in file order.  Facilities, org units,
+# symlink paths, and sharing export paths must be unique across files.
+#
+#     include = ["bcpfs.d/*.hcl"]
+#
+# The config may also be written in YAML or JSON, detected by the file
+# extensions `.yaml`, `.yml`, and `.json`.  The fields are the same as in the
+# output of `bcpfs-perms describe config`, which can be used as input.  A
+# config directory may mix formats.  `bcpfs-perms describe config-schema`
+# prints a JSON Schema for YAML and JSON config files, which editors can use
+# for completion and validation.
+
 
 # `rootdir` is the path to the root directory of the managed filesystem.  The
 # toplevel folders for services and organizational units are managed as direct
@@ -108,6 +126,14 @@ facility {
 # Example: `dirs=[{name:people policy:owner}]` ->
 # `/orgfs/data/org/ag-alice/people` with owner read-write, group read.
 #
+# `memberDirs=true` can be added to a dir with policy `owner` to create a
+# subdir for each member of the organizational unit Unix group, owned by the
+# member.  Subdirs of users who are no longer members are reported by
+# `bcpfs-perms check` but not removed.
+#
+# Example: `{name:people policy:owner memberDirs:true}` ->
+# `/orgfs/data/org/ag-alice/people/alice` owned by user `alice`.
+#
 # `orgUnit.extraDirs` is supported for backward compatibility.  Entries are
 # automatically added to `dirs` with policy `group`.
 #
@@ -115,7 +141,7 @@ facility {
 orgUnit {
     name = "ag-alice"
     subdirs = [
-        { name = "people", policy = "owner" },
+        { name = "people", policy = "owner", memberDirs = true },
         { name = "service", policy = "manager" },
         { name = "shared", policy = "manager" },
     ]
@@ -143,7 +169,49 @@ orgUnit {
 # are automatically anchored to the beginning "^" and end "$" of names. If both
 # regexes match, the `action` is applied.  The `action` can be `accept` of
 # `reject`.  The order of filter rules matters.  If no rule matches, the
-# default is to `reject`.
+# `defaultAction` is applied, see below.
+#
+# A filter rule may instead or in addition use the following criteria:
+#
+#  - `facility` or `facilities`: regexes for the name of the facility that
+#    owns the service;
+#  - `access`: the facility access policy of the service, `perService` or
+#    `allOrgUnits`;
+#  - `orgUnitKind`: `facility` for facility org units, `lab` for all other org
+#    units.
+#
+# The `action` is applied only if all specified criteria match.  If a rule
+# specifies one of `service` or `orgUnit` without the other, the missing regex
+# matches all names.  Example: the rule below would reject all `lm` services
+# for lab org units:
+#
+#     filter {
+#         facility = "lm"
+#         orgUnitKind = "lab"
+#         action = "reject"
+#     }
+#
+# A filter rule may be limited to a time window with `validFrom` and
+# `validUntil` dates `YYYY-MM-DD`, both inclusive and in local time.  Outside
+# of the window, the rule is ignored.  When an accepting rule expires, `apply`
+# removes the symlink `org/<ou>/<service>` and revokes the org unit access to
+# `srv/<service>/<ou>`, but it keeps the directory and its data.  Extending
+# `validUntil` restores access.  `bcpfs-perms describe expirations` lists the
+# upcoming and past expirations.  Example: the rule below would grant `ag-bob`
+# temporary access to `ms-data`:
+#
+#     filter {
+#         service = "ms-data"
+#         orgUnit = "ag-bob"
+#         action = "accept"
+#         validFrom = "2019-01-01"
+#         validUntil = "2019-06-30"
+#     }
+#
+# `defaultAction` is applied if no filter rule matches.  It can be `accept` or
+# `reject`.  The default is `reject`.
+defaultAction = "reject"
+
 #
 # Example: With the rules below, directories `/orgfs/data/srv/*/nog` and
 # symlinks `/orgfs/data/org/nog/*` will be rejected.  Combinations that have
@@ -1,53 +1,32 @@
 // vim: sw=8
 
 // Package `grp` provides access to Unix groups.
+//
+// Groups are read from a `GroupSource`: `Getent` for the live NSS view,
+// `GroupFile` for files in `/etc/group` format, and `SnapshotFile` for JSON
+// or YAML snapshots.
 package grp
 
 import (
 	"fmt"
-	"os/exec"
-	"strconv"
 	"strings"
-
-	"github.com/nogproject/bcpfs/pkg/execx"
 )
 
-var getent = execx.MustLookTool(execx.ToolSpec{
-	Program:   "getent",
-	CheckArgs: []string{"--version"},
-	CheckText: "getent",
-})
-
+// `Group` is a Unix group.  `Members` are the user names that are listed as
+// members in the group database.  Users that have the group as their primary
+// group are usually not listed.  `Members` are omitted from YAML, so that
+// `describe groups` and `describe org` stay concise; see `describe members`.
 type Group struct {
-	Name string
-	Gid  int
+	Name    string
+	Gid     int
+	Members []string `yaml:"-"`
 }
 
 // `Groups()` returns a list of Unix groups as reported by `getent`.  The list
-// may contain duplicates, even conflicting ones.
+// may contain duplicates, even conflicting ones.  It is equivalent to
+// `Getent{}.Groups()`.
 func Groups() ([]Group, error) {
-	dat, err := exec.Command(getent.Path, "group").Output()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to execute `getent`: %v", err)
-	}
-	txt := strings.TrimSpace(string(dat))
-
-	gs := make([]Group, 0)
-	for _, line := range strings.Split(txt, "\n") {
-		fs := strings.Split(line, ":")
-		if len(fs) != 4 {
-			return nil, fmt.Errorf(
-				"Invalid getent output `%s`", line,
-			)
-		}
-		gid, err := strconv.Atoi(fs[2])
-		if err != nil {
-			return nil, fmt.Errorf("Invalid gid `%s`", fs[2])
-		}
-		gs = append(gs, Group{Name: fs[0], Gid: gid})
-	}
-
-	return gs, nil
+	return Getent{}.Groups()
 }
 
 // `selectGroups()` selects `groups` whose names begin with any of the
@@ -80,10 +59,13 @@ func SelectGroups(groups []Group, prefixes []string, equals []string) []Group {
 }
 
 // `DedupGroups()` returns a list without duplicate groups.  It returns an
-// error if the input contains conflicting duplicates.
+// error if the input contains conflicting duplicates.  The members of
+// duplicates are merged, because NSS may report a group from several sources
+// with different members.
 func DedupGroups(groups []Group) ([]Group, error) {
 	byName := make(map[string]Group)
 	byGid := make(map[int]Group)
+	idx := make(map[string]int)
 
 	errConflict := func(a, b Group) error {
 		return fmt.Errorf(
@@ -93,15 +75,18 @@ func DedupGroups(groups []Group) ([]Group, error) {
 		)
 	}
 
+	isSame := func(a, b Group) bool {
+		return a.Name == b.Name && a.Gid == b.Gid
+	}
 	isDuplicate := func(g Group) (bool, error) {
 		if seen, ok := byName[g.Name]; ok {
-			if g != seen {
+			if !isSame(g, seen) {
 				return true, errConflict(seen, g)
 			}
 			return true, nil
 		}
 		if seen, ok := byGid[g.Gid]; ok {
-			if g != seen {
+			if !isSame(g, seen) {
 				return true, errConflict(seen, g)
 			}
 			return true, nil
@@ -116,12 +101,50 @@ func DedupGroups(groups []Group) ([]Group, error) {
 			return nil, err
 		}
 		if isDup {
+			i := idx[g.Name]
+			res[i].Members = mergeMembers(res[i].Members, g.Members)
 			continue
 		}
 		byName[g.Name] = g
 		byGid[g.Gid] = g
+		idx[g.Name] = len(res)
 		res = append(res, g)
 	}
 
 	return res, nil
 }
+
+// `mergeMembers()` appends the members of `b` that are not in `a`.
+func mergeMembers(a, b []string) []string {
+	seen := make(map[string]bool)
+	for _, m := range a {
+		seen[m] = true
+	}
+	for _, m := range b {
+		if !seen[m] {
+			a = append(a, m)
+			seen[m] = true
+		}
+	}
+	return a
+}
+
+// `GroupsOfUser()` selects the `groups` that list `user` as a member or have
+// the gid `primaryGid`.  Use a negative `primaryGid` if the primary group is
+// unknown.
+func GroupsOfUser(groups []Group, user string, primaryGid int) []Group {
+	var res []Group
+	for _, g := range groups {
+		if g.Gid == primaryGid {
+			res = append(res, g)
+			continue
+		}
+		for _, m := range g.Members {
+			if m == user {
+				res = append(res, g)
+				break
+			}
+		}
+	}
+	return res
+}
@@ -56,16 +56,16 @@ func ExampleSelectGroups() {
 	}
 
 	//Output:
-	// {Name:ag_org Gid:1}
-	// {Name:org_ms-facility Gid:2}
-	// {Name:srv_mic1 Gid:3}
-	// {Name:org_ag-foo Gid:4}
+	// {Name:ag_org Gid:1 Members:[]}
+	// {Name:org_ms-facility Gid:2 Members:[]}
+	// {Name:srv_mic1 Gid:3 Members:[]}
+	// {Name:org_ag-foo Gid:4 Members:[]}
 }
 
 func ExampleDedupGroups() {
 	gs := []grp.Group{
-		grp.Group{"foo", 1},
-		grp.Group{"foo", 1},
+		grp.Group{Name: "foo", Gid: 1},
+		grp.Group{Name: "foo", Gid: 1},
 	}
 	gs, err := grp.DedupGroups(gs)
 
@@ -76,13 +76,13 @@ func ExampleDedupGroups() {
 
 	//Output:
 	// error: <nil>
-	// {Name:foo Gid:1}
+	// {Name:foo Gid:1 Members:[]}
 }
 
 func ExampleDedupGroupsDiffGid() {
 	gs := []grp.Group{
-		grp.Group{"foo", 1},
-		grp.Group{"foo", 2},
+		grp.Group{Name: "foo", Gid: 1},
+		grp.Group{Name: "foo", Gid: 2},
 	}
 	gs, err := grp.DedupGroups(gs)
 
@@ -97,8 +97,8 @@ func ExampleDedupGroupsDiffGid() {
 
 func ExampleDedupGroupsDiffName() {
 	gs := []grp.Group{
-		grp.Group{"foo", 1},
-		grp.Group{"bar", 1},
+		grp.Group{Name: "foo", Gid: 1},
+		grp.Group{Name: "bar", Gid: 1},
 	}
 	gs, err := grp.DedupGroups(gs)
 
@@ -110,3 +110,36 @@ func ExampleDedupGroupsDiffName() {
 	//Output:
 	// error: conflicting groups 1(foo) and 1(bar)
 }
+
+func ExampleDedupGroups_members() {
+	gs := []grp.Group{
+		{Name: "foo", Gid: 1, Members: []string{"alice", "bob"}},
+		{Name: "foo", Gid: 1, Members: []string{"bob", "charly"}},
+	}
+	gs, err := grp.DedupGroups(gs)
+
+	fmt.Println("error:", err)
+	for _, g := range gs {
+		fmt.Printf("%+v\n", g)
+	}
+
+	//Output:
+	// error: <nil>
+	// {Name:foo Gid:1 Members:[alice bob charly]}
+}
+
+func ExampleGroupsOfUser() {
+	gs := []grp.Group{
+		{Name: "org_ag-alice", Gid: 1, Members: []string{"alice", "bob"}},
+		{Name: "org_ag-bob", Gid: 2},
+		{Name: "srv_tem-505", Gid: 3, Members: []string{"alice"}},
+	}
+
+	for _, g := range grp.GroupsOfUser(gs, "bob", 2) {
+		fmt.Println(g.Name)
+	}
+
+	//Output:
+	// org_ag-alice
+	// org_ag-bob
+}
@@ -0,0 +1,172 @@
+package grp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nogproject/bcpfs/pkg/execx"
+	"gopkg.in/yaml.v2"
+)
+
+var getentSpec = execx.ToolSpec{
+	Program:   "getent",
+	CheckArgs: []string{"--version"},
+	CheckText: "getent",
+}
+
+// `GroupSource` provides a list of Unix groups.  The list may contain
+// duplicates, even conflicting ones; see `DedupGroups()`.
+type GroupSource interface {
+	Groups() ([]Group, error)
+}
+
+// `Getent` is the live NSS view of the machine as reported by `getent group`.
+// `getent` is located only when it is used, so that other sources work on
+// machines without `getent`.
+type Getent struct{}
+
+// `GroupFile` is a file in `/etc/group` format, like the output of `getent
+// group`.
+type GroupFile struct {
+	Path string
+}
+
+// `SnapshotFile` is a JSON or YAML file with a list of groups with optional
+// members:
+//
+//     groups:
+//       - { name: org_ag-alice, gid: 2001, members: [alice, bob] }
+//
+// JSON is used for the extension `.json`, YAML otherwise.  Unknown fields are
+// rejected in both formats.
+type SnapshotFile struct {
+	Path string
+}
+
+var _ GroupSource = Getent{}
+var _ GroupSource = GroupFile{}
+var _ GroupSource = SnapshotFile{}
+
+// `SourceFromFile()` returns a `SnapshotFile` for paths with extension
+// `.json`, `.yaml`, or `.yml` and a `GroupFile` otherwise.
+func SourceFromFile(path string) GroupSource {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".yaml", ".yml":
+		return SnapshotFile{Path: path}
+	default:
+		return GroupFile{Path: path}
+	}
+}
+
+func (Getent) Groups() ([]Group, error) {
+	getent, err := execx.LookTool(getentSpec)
+	if err != nil {
+		return nil, err
+	}
+	dat, err := exec.Command(getent.Path, "group").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to execute `getent`: %v", err)
+	}
+	return parseGroupLines(string(dat), "getent output")
+}
+
+func (src GroupFile) Groups() ([]Group, error) {
+	dat, err := ioutil.ReadFile(src.Path)
+	if err != nil {
+		return nil, err
+	}
+	return parseGroupLines(
+		string(dat), fmt.Sprintf("group file `%s`", src.Path),
+	)
+}
+
+// `parseGroupLines()` parses lines in `/etc/group` format.  Blank lines are
+// ignored.  `what` describes the input in error messages, which also name
+// the line number.
+func parseGroupLines(txt string, what string) ([]Group, error) {
+	gs := make([]Group, 0)
+	for i, line := range strings.Split(txt, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fs := strings.Split(line, ":")
+		if len(fs) != 4 {
+			return nil, fmt.Errorf(
+				"Invalid %s line %d `%s`", what, i+1, line,
+			)
+		}
+		gid, err := strconv.Atoi(fs[2])
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Invalid gid `%s` in %s line %d", fs[2], what, i+1,
+			)
+		}
+		gs = append(gs, Group{
+			Name: fs[0], Gid: gid, Members: parseMembers(fs[3]),
+		})
+	}
+	return gs, nil
+}
+
+// `parseMembers()` parses a comma-separated member list.
+func parseMembers(s string) []string {
+	var members []string
+	for _, m := range strings.Split(s, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+type snapshot struct {
+	Groups []snapshotGroup `json:"groups" yaml:"groups"`
+}
+
+type snapshotGroup struct {
+	Name    string   `json:"name" yaml:"name"`
+	Gid     *int     `json:"gid" yaml:"gid"`
+	Members []string `json:"members" yaml:"members"`
+}
+
+func (src SnapshotFile) Groups() ([]Group, error) {
+	dat, err := ioutil.ReadFile(src.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var snap snapshot
+	if strings.ToLower(filepath.Ext(src.Path)) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(dat))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&snap)
+	} else {
+		err = yaml.UnmarshalStrict(dat, &snap)
+	}
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Invalid group snapshot `%s`: %v", src.Path, err,
+		)
+	}
+
+	gs := make([]Group, 0, len(snap.Groups))
+	for i, g := range snap.Groups {
+		if g.Name == "" || g.Gid == nil {
+			return nil, fmt.Errorf(
+				"Invalid group snapshot `%s`: "+
+					"group %d requires name and gid",
+				src.Path, i,
+			)
+		}
+		gs = append(gs, Group{
+			Name: g.Name, Gid: *g.Gid, Members: g.Members,
+		})
+	}
+	return gs, nil
+}
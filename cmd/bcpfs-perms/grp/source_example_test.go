@@ -0,0 +1,59 @@
+package grp_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+)
+
+func ExampleSourceFromFile() {
+	dir, _ := ioutil.TempDir("", "grp")
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"group": "" +
+			"root:x:0:\n" +
+			"org_ag-alice:x:2001:alice,bob\n",
+		"groups.yml": "" +
+			"groups:\n" +
+			"  - { name: root, gid: 0 }\n" +
+			"  - { name: org_ag-alice, gid: 2001, " +
+			"members: [alice, bob] }\n",
+		"groups.json": `{"groups": [` +
+			`{"name": "root", "gid": 0}, ` +
+			`{"name": "org_ag-alice", "gid": 2001, ` +
+			`"members": ["alice", "bob"]}]}`,
+		"invalid.json": `{"groups": [{"name": "root"}]}`,
+		"unknown.json": `{"groups": [{"name": "root", "gids": 0}]}`,
+		"invalid-gid": "" +
+			"root:x:0:\n" +
+			"\n" +
+			"org_ag-alice:x:20o1:alice\n",
+	}
+	for _, name := range []string{
+		"group", "groups.yml", "groups.json",
+		"invalid.json", "unknown.json", "invalid-gid",
+	} {
+		path := filepath.Join(dir, name)
+		_ = ioutil.WriteFile(path, []byte(files[name]), 0644)
+		gs, err := grp.SourceFromFile(path).Groups()
+		if err != nil {
+			fmt.Println(name, strings.Replace(
+				err.Error(), dir, "<dir>", -1,
+			))
+			continue
+		}
+		fmt.Println(name, gs)
+	}
+
+	// Output:
+	// group [{root 0 []} {org_ag-alice 2001 [alice bob]}]
+	// groups.yml [{root 0 []} {org_ag-alice 2001 [alice bob]}]
+	// groups.json [{root 0 []} {org_ag-alice 2001 [alice bob]}]
+	// invalid.json Invalid group snapshot `<dir>/invalid.json`: group 0 requires name and gid
+	// unknown.json Invalid group snapshot `<dir>/unknown.json`: json: unknown field "gids"
+	// invalid-gid Invalid gid `20o1` in group file `<dir>/invalid-gid` line 3
+}
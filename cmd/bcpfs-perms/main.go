@@ -7,19 +7,27 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/docopt/docopt-go"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
 	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpscope"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharingapply"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/describe"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsapply"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsck"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsplan"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/policytest"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/runlock"
 	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/v"
 )
 
@@ -34,21 +42,73 @@ func qqBackticks(s string) string {
 
 var usage = qqBackticks(`Usage:
   bcpfs-perms [--config=<path>] describe config
+  bcpfs-perms describe config-schema
   bcpfs-perms [--config=<path>] describe groups [--strict]
-  bcpfs-perms [--config=<path>] describe org [--strict]
+              [--groups-from=<file>]
+  bcpfs-perms [--config=<path>] describe org [--strict] [--groups-from=<file>]
+  bcpfs-perms [--config=<path>] describe members [--groups-from=<file>]
+  bcpfs-perms [--config=<path>] describe user <name> [--groups-from=<file>]
+  bcpfs-perms [--config=<path>] describe matrix [--groups-from=<file>]
+              [--format=<fmt>]
+  bcpfs-perms [--config=<path>] describe expirations [--groups-from=<file>]
+  bcpfs-perms [--config=<path>] explain filter <service> <orgUnit>
+              [--groups-from=<file>]
+  bcpfs-perms [--config=<path>] lint config [--groups-from=<file>]
+  bcpfs-perms [--config=<path>] test <testfile> [--groups-from=<file>]
+  bcpfs-perms diff-config <old> <new> [--groups-from=<file>]
+  bcpfs-perms init [--rootdir=<dir>] [--org-unit-prefix=<pfx>]
+              [--service-prefix=<pfx>] [--groups-from=<file>]
   bcpfs-perms [--config=<path>] apply [--debug] [--recursive] [--sharing]
-  bcpfs-perms [--config=<path>] check [--debug]
+              [--org-unit=<regex>] [--service=<regex>]
+              [--jobs=<n>] [--keep-going] [--wait | --no-wait]
+              [--dry-run [--groups-from=<file>]] [--format=<fmt>]
+  bcpfs-perms [--config=<path>] check [--debug] [--sharing]
+              [--groups [--strict]] [--org-unit=<regex>] [--service=<regex>]
+              [--recursive [--sample=<n>]] [--groups-from=<file>]
+              [--format=<fmt>]
   bcpfs-perms version
 
 Options:
   --config=<path>  [default: /etc/bcpfs.hcl]
         Path to a mandatory HCL config file, which must at least specify the
         ''rootdir''.  See ''/usr/share/doc/bcpfs'' for an example config.
+        Files with extension ''.yaml'', ''.yml'', or ''.json'' are parsed as
+        YAML or JSON with the fields of ''describe config''.  The path may be
+        a directory, like ''/etc/bcpfs.d'', whose config files are merged in
+        the order of their names.  An HCL config file that is not in a
+        config directory may also ''include'' other files.
   --debug   Enable debug logging.
   --strict  Enable stricter checking for compatibility of configuration and
-        Unix groups.
-  --recursive  Apply permissions recursively.
-  --sharing    Apply sharing permissions.
+        Unix groups.  With ''check --groups'', fail if group memberships are
+        inconsistent.
+  --groups  Check the consistency of Unix group memberships.
+  --groups-from=<file>
+        Read the Unix groups from a file instead of ''getent group''.  Files
+        with extension ''.json'', ''.yaml'', or ''.yml'' are group snapshots;
+        other files use the ''/etc/group'' format.
+  --recursive  Apply or check permissions recursively.
+  --sample=<n>  [default: 1]
+        Check only every n-th file below the toplevel directories with
+        ''check --recursive''.  Directories are always checked.
+  --sharing    Apply or check sharing permissions.
+  --org-unit=<regex>  Restrict apply or check to the matching org units.
+  --service=<regex>   Restrict apply or check to the matching services.
+  --jobs=<n>  [default: 1]
+        Number of service and org unit subtrees that ''apply'' processes
+        concurrently.
+  --keep-going  Continue ''apply'' after errors and report a summary at the end.
+  --wait       Wait for a concurrent ''apply'' to release the run lock.
+  --no-wait    Fail if a concurrent ''apply'' holds the run lock (default).
+  --dry-run    Print the planned changes without modifying the filesystem.
+  --format=<fmt>  [default: text]
+        Output format of ''apply --dry-run'' and ''check'': ''text'' or
+        ''json''.  Output format of ''describe matrix'': ''text'' or ''csv''.
+  --rootdir=<dir>  [default: /orgfs/data]
+        The ''rootdir'' of the config that ''init'' generates.
+  --org-unit-prefix=<pfx>  [default: org]
+        The Unix group prefix of org units for ''init''.
+  --service-prefix=<pfx>  [default: srv]
+        The Unix group prefix of services for ''init''.
 
 ''bcpfs-perms'' manages the toplevel directories as described in the 2016
 filesystem concept.
@@ -56,9 +116,28 @@ filesystem concept.
 ''bcpfs-perms describe config'' prints the active config, which is based on the
 config file and defaults.
 
+''bcpfs-perms describe config-schema'' prints a JSON Schema for YAML and JSON
+config files, which editors can use for completion and validation.  The fields
+are the same as in the output of ''describe config'', like ''orgunits'' and
+''servicedir''; they are the exact set of keys that YAML and JSON config files
+accept.  The schema does not describe HCL config files, which use different
+names, like ''orgUnit'' blocks and ''serviceDir''.
+
 ''bcpfs-perms describe groups'' prints the Unix groups, filtered for the active
 config.
 
+''--groups-from=<file>'' replaces the live Unix groups from ''getent group'' for
+''describe'', ''check'', and ''apply --dry-run'', for example to preview the
+effect of upcoming group changes or to reproduce production behavior on a
+different machine.  The file is either in ''/etc/group'' format, like the output
+of ''getent group'', or a JSON or YAML snapshot:
+
+''''''
+groups:
+  - { name: org_ag-alice, gid: 2001 }
+  - { name: srv_tem-505, gid: 2011 }
+''''''
+
 ''bcpfs-perms describe org'' prints the org units, facilities, and services, as
 determined from the config and the Unix groups.  ''bcpfs-perms describe org''
 runs a basic sanity check to confirm that the configuration is compatible with
@@ -66,9 +145,129 @@ the the Unix groups.  If run with ''--strict'', ''bcpfs-perms describe org''
 checks if there are service Unix groups that are not specified in the
 configuration and exits with an error if there are any.
 
+''bcpfs-perms describe members'' prints the members of the org unit groups and
+of the service and ops groups, as listed in the Unix groups.  Users whose
+primary group is the group are usually not listed, because the group database
+does not include them.
+
+''bcpfs-perms describe user <name>'' prints the managed paths that a user can
+read or write, together with the Unix group and the mechanism that grants the
+access: ''orgUnit'', ''service'', ''ops'', ''superGroup'', or ''sharing''.  Paths
+whose ACL grants access but whose service directory cannot be traversed are
+listed as ''blocked'' with a note that names the missing group.  The report is
+computed from the config, the Unix groups, the ''filter'' rules, and the
+''sharing'' config as ''apply'' would set the permissions; it does not inspect
+the filesystem.  The user's groups are the groups that list the user as a
+member and the user's primary group if the user is known to the system.
+
+''bcpfs-perms describe matrix'' prints the ''filter'' decisions for all
+combinations of org units and services as a table with one row per org unit
+and one column per service, with ''x'' for accepted and ''-'' for rejected
+combinations, or as CSV with ''accept'' and ''reject'' with ''--format=csv''.
+
+''bcpfs-perms describe expirations'' lists the combinations of services and
+org units that are accepted by ''filter'' rules with ''validUntil'', as
+''status: active'', and the combinations whose rules have expired, as
+''status: expired'', sorted by ''validUntil''.  When a rule expires, ''apply''
+removes the ''org/<ou>/<service>'' symlink and revokes the org unit access to
+''srv/<service>/<ou>'' but keeps the directory and its data.
+
+''bcpfs-perms explain filter <service> <orgUnit>'' evaluates every ''filter''
+rule in order for a combination of service and org unit and prints the action
+and reason of each rule, followed by the result.  The first rule that accepts
+or rejects is marked as ''decisive''; later rules are marked as ''ignored''.
+The implicit last rule accepts services of a facility for the facility org
+unit.
+
+''bcpfs-perms lint config'' checks the config file and prints each problem as
+''<file>:<line>:<col>: <severity>: <message>''.  Errors are problems that
+''apply'' would reject, like invalid values and regexes that do not compile.
+Warnings are unknown fields, duplicate facilities, services that are listed in
+more than one facility, and ''filter'' rules that are never reached because
+they are shadowed by earlier rules.  Shadowing is detected by comparing
+patterns literally, where ''.*'' matches everything.  If there are no errors,
+it also loads the Unix groups and checks that the groups in ''sharing'' ACL
+entries and imports are org units.  It exits with a non-zero status if there
+are errors.
+
+''bcpfs-perms test <testfile>'' evaluates policy tests, which state expected
+outcomes of the config, for example before merging config changes.  The YAML
+test file optionally names a group fixture, relative to the test file, which
+''--groups-from'' overrides, and lists tests that each assert one of:
+''path'' is managed, or absent with ''exists: false'', optionally with a
+''symlink'' target and ''acl'' entries that must be present and ''noAcl''
+entries that must be absent; the ''filter'' ''action'' for a service and org
+unit; or that a sharing export ''share'' is shared with a ''group'', optionally
+with ''mode''.  The tests are evaluated against the expected state, not the
+filesystem.  The group fixture contains no uids, so per-member directories of
+''memberDirs'' subdirs are expected only for members that the system user
+database knows, like with ''apply''.  Failures are printed like a unit test
+runner, and the exit status is non-zero if a test failed:
+
+''''''
+groups: groups.yaml
+tests:
+  - name: ag-alice has tem-505
+    path: srv/tem-505/ag-alice
+    acl: [ "group:org_ag-alice:rwx" ]
+  - name: ag-bob cannot see ms-data
+    path: org/ag-bob/ms-data
+    exists: false
+  - filter: { service: ms-data, orgUnit: ag-bob, action: reject }
+  - share: { path: em-facility/service/guides, group: ag-alice, mode: r-x }
+''''''
+
+''bcpfs-perms diff-config <old> <new>'' compares the expected filesystem state
+of two config paths with the same Unix groups, for example to review a config
+change before it is merged.  It prints added ''+'' and removed ''-''
+directories, symlinks, ACL entries, and sharing exports, sorted by path, with
+paths relative to the rootdir.  Removals that revoke access of an org unit are
+marked with ''(loses access)'': removed service org unit directories, like
+''srv/tem-505/ag-alice'', removed org unit service symlinks, like
+''org/ag-alice/tem-505'', removed or narrowed named group ACL entries, and
+removed or narrowed shares.  Directories that are no longer expected are not
+removed by ''apply''; see ''check''.
+
+''bcpfs-perms init'' prints a config skeleton for a new site, which is generated
+from the Unix groups with the prefixes ''--org-unit-prefix'' and
+''--service-prefix''.  Facilities are inferred from the facility org unit
+groups, like ''org_em-facility'', and the ops groups, like ''srv_em-ops''.
+Services are assigned to the facility with the longest matching name prefix,
+like ''em-analysis'' to ''em''.  Facilities that lack one of the two groups are
+commented out, and their services are listed with the services that have no
+facility, which must be added to a facility before ''apply'' accepts the
+config.  The skeleton contains ''facility'' and ''orgUnit'' blocks and default
+''filter'' rules.  Guesses are marked with ''REVIEW'' comments, which must be
+checked before the config is used.
+
 ''bcpfs-perms apply'' creates the toplevel directories and applies permissions.
 If used with ''--recursive'', permissions will be propagated to
-sub-directories.  Sub-directories are updated silently.
+sub-directories.  Sub-directories are updated silently.  With
+''--jobs=<n>'', ''apply'' processes up to n service org unit directories and
+org unit subdirectories concurrently, which reduces the runtime of
+''--recursive'' for large filesystems.  Log messages and ''--dry-run'' plans are
+printed in the same order as with ''--jobs=1''.  After an error, no further
+subtrees are started, and the errors of the running subtrees are reported
+together.
+
+''bcpfs-perms apply --keep-going'' does not stop at the first error, like an
+NFS timeout or an immutable file.  It records the failed paths, continues with
+the remaining directories, files, shared directories, and share tree entries,
+and ends with a summary of all failures and a non-zero exit status.
+
+''bcpfs-perms apply'' takes an exclusive advisory lock on the file
+''<rootdir>/.bcpfs-perms.lock'' to prevent concurrent runs, like a cron job and
+a manual ''apply --recursive'', from modifying the same trees.  If the lock is
+held, ''apply'' fails with a message that names the PID and start time of the
+holder, or waits until the lock is released with ''--wait''.  The lock is
+released automatically when the holder exits.  ''apply --dry-run'' and
+''check'' do not take the lock.
+
+''bcpfs-perms apply --dry-run'' inspects the filesystem and prints the changes
+that ''apply'' would perform, like ''mkdir'', ''chown'', ''setfacl'', symlink
+creation, and removal, one per line or as JSON with ''--format=json''.  The
+filesystem is not modified.  It exits with status 2 if the plan is not empty,
+so that changes can be reviewed before they are applied.
 
 ''bcpfs-perms apply --sharing'' manages ''<ou>/shared'' trees, in addition to
 the usual permissions, as configured in the ''sharing'' configuration block.
@@ -103,7 +302,53 @@ Supported import actions: ''accept'' and ''reject''.
 
 ''bcpfs-perms check'' verifies directories and permissions.  ''--debug''
 enables reporting of details, such as paths that are skipped due to ''filter''
-statements in the configuration.
+statements in the configuration.  With ''--format=json'', ''check'' prints the
+findings as JSON to stdout, one object per deviation with the fields
+''category'', ''path'', ''expected'', ''actual'', ''orgUnit'', and ''service''.
+Categories are ''unexpected'', ''symlink'', ''explicit-symlink'', ''acl'', and
+''stale-member-dir'' for directories of former org unit members in subdirs with
+''memberDirs = true''.  It exits with a non-zero status if there are findings,
+like with ''text''.
+
+''bcpfs-perms check --sharing'' additionally verifies the ''sharing''
+configuration without modifying the filesystem: the named group ACL entries of
+shared directories, including unexpected entries, the ''--x'' traversal
+entries, and the ''<ou>/shared'' trees, including stale, missing, unexpected,
+and dangling symlinks.  Categories are ''sharing-acl'', ''sharing-traversal'',
+''sharing-tree'', and ''sharing-dangling''.  Only the toplevel directory of a
+share is inspected.
+
+''bcpfs-perms check --groups'' additionally verifies the Unix group
+memberships that the permissions rely on: members of org unit groups must be
+members of the ''superGroup'', members of facility ops groups must be members
+of the facility org unit group, and service groups must not be empty.
+Categories are ''group-super'', ''group-ops'', and ''group-empty-service''; the
+findings have the fields ''group'' and ''user'' instead of ''path''.  Users are
+only recognized if they are listed as group members; users whose primary group
+is the group are usually not listed.  Inconsistencies are reported but ignored
+unless ''--strict'' is used.
+
+''bcpfs-perms check --recursive'' additionally compares the files and
+directories below ''<srv>/<ou>'' and ''<ou>/<subdir>'' with the owning group,
+SGID bit, and ACLs that ''apply --recursive'' would set.  Deviations have
+category ''tree-acl''.  Named user entries are reported as deviations, although
+''apply'' does not remove them.  At most 20 deviations are reported per tree;
+further deviations are only counted.  Paths that cannot be inspected, like
+directories without read permission or files that are removed during the
+check, have category ''tree-error''; the check continues with the remaining
+paths.  A summary is logged for each tree and included as ''trees'' with
+''--format=json''.  Use ''--sample=<n>'' to reduce the cost for large trees.
+
+''--org-unit=<regex>'' and ''--service=<regex>'' restrict ''apply'' and
+''check'', including ''--sharing'' and ''--recursive'', to the subtrees of the
+matching org units and services, for example to fix a single lab after
+onboarding without touching the rest of the filesystem.  The regexes are
+anchored to the full name, like in ''filter'' rules.  With ''--org-unit'',
+the selected subtrees are ''<ou>/...'' and ''<srv>/<service>/<ou>/...''.  With
+''--service'', they are ''<srv>/<service>/...'' and the service symlinks
+''<ou>/<service>''.  If both are given, only the paths that belong to a
+matching combination are selected.  The toplevel directories and explicit
+''symlink'' statements outside the selected subtrees are left alone.
 `)
 
 func main() {
@@ -120,10 +365,30 @@ func main() {
 		cmdCheck(args)
 	case args["describe"].(bool) && args["config"].(bool):
 		cmdDescribeConfig(args)
+	case args["describe"].(bool) && args["config-schema"].(bool):
+		cmdDescribeConfigSchema()
 	case args["describe"].(bool) && args["groups"].(bool):
 		cmdDescribeGroups(args)
 	case args["describe"].(bool) && args["org"].(bool):
 		cmdDescribeOrg(args)
+	case args["describe"].(bool) && args["members"].(bool):
+		cmdDescribeMembers(args)
+	case args["describe"].(bool) && args["user"].(bool):
+		cmdDescribeUser(args)
+	case args["describe"].(bool) && args["matrix"].(bool):
+		cmdDescribeMatrix(args)
+	case args["describe"].(bool) && args["expirations"].(bool):
+		cmdDescribeExpirations(args)
+	case args["explain"].(bool) && args["filter"].(bool):
+		cmdExplainFilter(args)
+	case args["lint"].(bool) && args["config"].(bool):
+		cmdLintConfig(args)
+	case args["test"].(bool):
+		cmdTest(args)
+	case args["diff-config"].(bool):
+		cmdDiffConfig(args)
+	case args["init"].(bool):
+		cmdInit(args)
 	}
 }
 
@@ -141,12 +406,53 @@ func cmdVersion() {
 }
 
 func cmdApply(args map[string]interface{}) {
+	var plan *fsplan.Plan
+	if args["--dry-run"].(bool) {
+		plan = fsplan.New()
+	} else if args["--groups-from"] != nil {
+		// Groups from a file may be outdated.  They are only used to
+		// preview changes.
+		logger.Fatal("--groups-from requires --dry-run.")
+	}
+	format := mustFormat(args)
+	jobs, err := strconv.Atoi(args["--jobs"].(string))
+	if err != nil || jobs < 1 {
+		msg := fmt.Sprintf(
+			"Invalid --jobs `%s`.", args["--jobs"].(string),
+		)
+		logger.Fatal(msg)
+	}
+
+	keepGoing := args["--keep-going"].(bool)
+
 	opts := &fsapply.Options{
 		Recursive: args["--recursive"].(bool),
+		Plan:      plan,
+		Jobs:      jobs,
+		KeepGoing: keepGoing,
+	}
+
+	// With `--keep-going`, `fail()` records failures for the summary at
+	// the end.  Otherwise, it exits immediately.
+	var failures []string
+	fail := func(what string, err error) {
+		if !keepGoing {
+			msg := fmt.Sprintf("%s: %v", what, err)
+			logger.Fatal(msg)
+		}
+		if fs, ok := err.(fsapply.Failures); ok {
+			for _, f := range fs {
+				failures = append(failures, fmt.Sprintf(
+					"%s: %v", what, f,
+				))
+			}
+			return
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", what, err))
 	}
 
 	cfg := MustLoadConfig(args["--config"].(string))
-	_, org, unconfServices := MustLoadGroups(cfg)
+	gs, org, unconfServices := MustLoadGroups(cfg, MustGroupSource(args))
 	if len(unconfServices) > 0 {
 		for _, s := range unconfServices {
 			logger.Error(s)
@@ -156,59 +462,99 @@ func cmdApply(args map[string]interface{}) {
 	}
 
 	filter := MustCompileFilter(cfg)
+	scope := MustScope(args, cfg, org)
+	opts.Scope = scope
 
-	err := fsapply.EnsurePermissions(cfg, org, filter, opts)
+	if plan == nil {
+		lock := MustLock(cfg, args["--wait"].(bool))
+		defer func() { _ = lock.Release() }()
+	}
+
+	err = fsapply.EnsurePermissions(cfg, org, filter, opts)
 	if err != nil {
-		msg := fmt.Sprintf("Failed to apply permissions: %v", err)
-		logger.Fatal(msg)
+		fail("Failed to apply permissions", err)
 	}
 
 	if args["--sharing"].(bool) {
-		if cfg.Sharing == nil {
-			msg := "Missing sharing config."
-			logger.Fatal(msg)
-		}
-
-		sharing, err := bcpsharing.Compile(cfg)
-		if err != nil {
-			msg := fmt.Sprintf(
-				"Failed to compile sharing: %v", err,
-			)
-			logger.Fatal(msg)
+		sharing := MustCompileSharing(cfg)
+		sharingOpts := &bcpsharingapply.Options{
+			Groups: gs, Plan: plan, Scope: scope,
+			KeepGoing: keepGoing,
 		}
 
 		if err := bcpsharingapply.EnsureRealShares(
-			logger, sharing.Bcpfs, sharing.RealShares,
+			logger, sharingOpts, sharing.Bcpfs, sharing.RealShares,
 		); err != nil {
-			msg := fmt.Sprintf(
-				"Failed to apply sharing: %v", err,
-			)
-			logger.Fatal(msg)
+			fail("Failed to apply sharing", err)
 		}
 
 		if err := bcpsharingapply.EnsureTraversal(
-			logger, sharing.Bcpfs, sharing.Traversal,
+			logger, sharingOpts, sharing.Bcpfs, sharing.Traversal,
 		); err != nil {
-			msg := fmt.Sprintf(
-				"Failed to apply sharing traversal: %v", err,
-			)
-			logger.Fatal(msg)
+			fail("Failed to apply sharing traversal", err)
 		}
 
 		if err := bcpsharingapply.EnsureShareTrees(
-			logger, sharing.Bcpfs, sharing.ShareTrees,
+			logger, sharingOpts, sharing.Bcpfs, sharing.ShareTrees,
 		); err != nil {
-			msg := fmt.Sprintf(
-				"Failed to apply sharing trees: %v", err,
-			)
+			fail("Failed to apply sharing trees", err)
+		}
+	}
+
+	if plan != nil {
+		printPlan(plan, format)
+	}
+	if len(failures) > 0 {
+		for _, f := range failures {
+			logger.Error(f)
+		}
+		msg := fmt.Sprintf(
+			"apply failed: %d failures; see summary above.",
+			len(failures),
+		)
+		logger.Fatal(msg)
+	}
+	if plan != nil && !plan.IsEmpty() {
+		os.Exit(2)
+	}
+}
+
+// `mustFormat()` returns the validated `--format`.
+func mustFormat(args map[string]interface{}) string {
+	format := args["--format"].(string)
+	if format != "text" && format != "json" {
+		msg := fmt.Sprintf("Invalid --format `%s`.", format)
+		logger.Fatal(msg)
+	}
+	return format
+}
+
+func printPlan(plan *fsplan.Plan, format string) {
+	switch format {
+	case "json":
+		d, err := plan.JSON()
+		if err != nil {
+			msg := fmt.Sprintf("Failed to marshal plan: %v", err)
 			logger.Fatal(msg)
 		}
+		fmt.Printf("%s\n", d)
+	default:
+		fmt.Print(plan.Text())
 	}
 }
 
 func cmdCheck(args map[string]interface{}) {
+	if args["--strict"].(bool) && !args["--groups"].(bool) {
+		logger.Fatal("--strict requires --groups.")
+	}
+	format := mustFormat(args)
+	var report *fsck.Report
+	if format == "json" {
+		report = fsck.NewReport()
+	}
+
 	cfg := MustLoadConfig(args["--config"].(string))
-	_, org, unconfServices := MustLoadGroups(cfg)
+	gs, org, unconfServices := MustLoadGroups(cfg, MustGroupSource(args))
 	if len(unconfServices) > 0 {
 		for _, s := range unconfServices {
 			logger.Error(s)
@@ -217,18 +563,85 @@ func cmdCheck(args map[string]interface{}) {
 		logger.Fatal(msg)
 	}
 	filter := MustCompileFilter(cfg)
-	reason, err := fsck.CheckPermissions(cfg, org, filter)
+	sample, err := strconv.Atoi(args["--sample"].(string))
+	if err != nil || sample < 1 {
+		msg := fmt.Sprintf(
+			"Invalid --sample `%s`.", args["--sample"].(string),
+		)
+		logger.Fatal(msg)
+	}
+	opts := &fsck.Options{
+		Report:    report,
+		Recursive: args["--recursive"].(bool),
+		Sample:    sample,
+		Scope:     MustScope(args, cfg, org),
+	}
+	var reasons []string
+	reason, err := fsck.CheckPermissions(cfg, org, filter, opts)
 	if err != nil {
 		msg := fmt.Sprintf("fsck error: %v", err)
 		logger.Fatal(msg)
 	}
 	if reason != "" {
-		msg := fmt.Sprintf("fsck failed: %s", reason)
+		reasons = append(reasons, reason)
+	}
+
+	if args["--sharing"].(bool) {
+		sharing := MustCompileSharing(cfg)
+		reason, err := fsck.CheckSharing(sharing, gs, opts)
+		if err != nil {
+			msg := fmt.Sprintf("fsck sharing error: %v", err)
+			logger.Fatal(msg)
+		}
+		if reason != "" {
+			reasons = append(reasons, "sharing "+reason)
+		}
+	}
+
+	if args["--groups"].(bool) {
+		reason := fsck.CheckGroups(cfg.SuperGroup, org, gs, opts)
+		if reason != "" && args["--strict"].(bool) {
+			reasons = append(reasons, "groups "+reason)
+		} else if reason != "" {
+			msg := fmt.Sprintf(
+				"groups %s; ignored without --strict.", reason,
+			)
+			logger.Info(msg)
+		}
+	}
+
+	if report != nil {
+		d, err := report.JSON()
+		if err != nil {
+			msg := fmt.Sprintf("Failed to marshal report: %v", err)
+			logger.Fatal(msg)
+		}
+		fmt.Printf("%s\n", d)
+	}
+	if len(reasons) > 0 {
+		msg := fmt.Sprintf(
+			"fsck failed: %s", strings.Join(reasons, "; "),
+		)
 		logger.Fatal(msg)
 	}
 	logger.Info("fsck ok")
 }
 
+// `MustCompileSharing()` compiles the mandatory sharing config.
+func MustCompileSharing(cfg *bcpcfg.Root) *bcpsharing.Sharing {
+	if cfg.Sharing == nil {
+		msg := "Missing sharing config."
+		logger.Fatal(msg)
+	}
+
+	sharing, err := bcpsharing.Compile(cfg)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to compile sharing: %v", err)
+		logger.Fatal(msg)
+	}
+	return sharing
+}
+
 // `MustLoadConfig()` loads the config and inserts defaults.
 func MustLoadConfig(path string) *bcpcfg.Root {
 	cfg, err := bcpcfg.Load(path)
@@ -248,6 +661,9 @@ func MustLoadConfig(path string) *bcpcfg.Root {
 	if cfg.FacilitySuffix == "" {
 		cfg.FacilitySuffix = "facility"
 	}
+	if cfg.DefaultAction == "" {
+		cfg.DefaultAction = "reject"
+	}
 	if cfg.ServiceDir == "" {
 		logger.Fatal("Missing config `serviceDir`.")
 	}
@@ -257,12 +673,24 @@ func MustLoadConfig(path string) *bcpcfg.Root {
 	return cfg
 }
 
-// `MustLoadGroups()` loads the Unix groups and parses them to return an
-// `Organization`.
-func MustLoadGroups(cfg *bcpcfg.Root) (
+// `MustGroupSource()` returns the group source that is selected by
+// `--groups-from`.
+func MustGroupSource(args map[string]interface{}) grp.GroupSource {
+	path, _ := args["--groups-from"].(string)
+	if path == "" {
+		return grp.Getent{}
+	}
+	msg := fmt.Sprintf("Using groups from `%s`.", path)
+	logger.Info(msg)
+	return grp.SourceFromFile(path)
+}
+
+// `MustLoadGroups()` loads the Unix groups from `src` and parses them to
+// return an `Organization`.
+func MustLoadGroups(cfg *bcpcfg.Root, src grp.GroupSource) (
 	[]grp.Group, *bcp.Organization, []string,
 ) {
-	gs, err := grp.Groups()
+	gs, err := src.Groups()
 	if err != nil {
 		msg := fmt.Sprintf("Failed to get groups: %v", err)
 		logger.Fatal(msg)
@@ -295,10 +723,52 @@ func MustLoadGroups(cfg *bcpcfg.Root) (
 	return gs, org, unconfServices
 }
 
-func MustCompileFilter(cfg *bcpcfg.Root) bfilter.OrgServiceFilter {
+// `MustLock()` acquires the run lock in the rootdir.
+func MustLock(cfg *bcpcfg.Root, wait bool) *runlock.Lock {
+	path := filepath.Join(cfg.Rootdir, runlock.Name)
+	waiting := func(h runlock.Holder) {
+		msg := fmt.Sprintf(
+			"Waiting for lock `%s` held by %s.", path, h,
+		)
+		logger.Info(msg)
+	}
+	lock, err := runlock.Acquire(path, wait, waiting)
+	if err != nil {
+		msg := fmt.Sprintf(
+			"Failed to acquire run lock: %v; "+
+				"another apply is running; use --wait to wait.",
+			err,
+		)
+		if _, ok := err.(*runlock.HeldError); !ok {
+			msg = fmt.Sprintf("Failed to acquire run lock: %v", err)
+		}
+		logger.Fatal(msg)
+	}
+	return lock
+}
+
+// `MustScope()` creates the scope from `--org-unit` and `--service`.
+func MustScope(
+	args map[string]interface{}, cfg *bcpcfg.Root, org *bcp.Organization,
+) *bcpscope.Scope {
+	orgUnit, _ := args["--org-unit"].(string)
+	service, _ := args["--service"].(string)
+	scope, err := bcpscope.New(cfg, org, orgUnit, service)
+	if err != nil {
+		msg := fmt.Sprintf("Invalid scope: %v", err)
+		logger.Fatal(msg)
+	}
+	if !scope.IsAll() {
+		msg := fmt.Sprintf("Restricted to scope %s.", scope)
+		logger.Info(msg)
+	}
+	return scope
+}
+
+func MustCompileFilter(cfg *bcpcfg.Root) *bfilter.DecidersFilter {
 	var deciders []bfilter.Decider
 	for _, decide := range cfg.Filter {
-		if r, err := bfilter.NewRegexpDecider(decide); err != nil {
+		if r, err := bfilter.NewFilterRuleDecider(decide); err != nil {
 			msg := fmt.Sprintf(
 				"Invalid reject=%+v: %v", decide, err,
 			)
@@ -308,7 +778,10 @@ func MustCompileFilter(cfg *bcpcfg.Root) bfilter.OrgServiceFilter {
 		}
 	}
 	deciders = append(deciders, bfilter.NewSameFacilityDecider())
-	return &bfilter.DecidersFilter{Rules: deciders}
+	return &bfilter.DecidersFilter{
+		Rules:   deciders,
+		Default: bfilter.ActionFromString(cfg.DefaultAction),
+	}
 }
 
 func cmdDescribeConfig(args map[string]interface{}) {
@@ -316,9 +789,13 @@ func cmdDescribeConfig(args map[string]interface{}) {
 	fmt.Printf("%s", describe.MustDescribeConfig(cfg))
 }
 
+func cmdDescribeConfigSchema() {
+	fmt.Printf("%s", describe.MustDescribeConfigSchema())
+}
+
 func cmdDescribeGroups(args map[string]interface{}) {
 	cfg := MustLoadConfig(args["--config"].(string))
-	gs, _, unconfServices := MustLoadGroups(cfg)
+	gs, _, unconfServices := MustLoadGroups(cfg, MustGroupSource(args))
 	if args["--strict"].(bool) && len(unconfServices) > 0 {
 		for _, s := range unconfServices {
 			logger.Error(s)
@@ -331,7 +808,7 @@ func cmdDescribeGroups(args map[string]interface{}) {
 
 func cmdDescribeOrg(args map[string]interface{}) {
 	cfg := MustLoadConfig(args["--config"].(string))
-	_, org, unconfServices := MustLoadGroups(cfg)
+	_, org, unconfServices := MustLoadGroups(cfg, MustGroupSource(args))
 	if args["--strict"].(bool) && len(unconfServices) > 0 {
 		for _, s := range unconfServices {
 			logger.Error(s)
@@ -341,3 +818,216 @@ func cmdDescribeOrg(args map[string]interface{}) {
 	}
 	fmt.Printf("%s", describe.MustDescribeOrg(org))
 }
+
+func cmdDescribeMembers(args map[string]interface{}) {
+	cfg := MustLoadConfig(args["--config"].(string))
+	_, org, _ := MustLoadGroups(cfg, MustGroupSource(args))
+	fmt.Printf("%s", describe.MustDescribeMembers(org))
+}
+
+func cmdDescribeUser(args map[string]interface{}) {
+	cfg := MustLoadConfig(args["--config"].(string))
+	gs, org, _ := MustLoadGroups(cfg, MustGroupSource(args))
+	name := args["<name>"].(string)
+
+	primaryGid := -1
+	if u, err := user.Lookup(name); err != nil {
+		msg := fmt.Sprintf(
+			"Ignored primary group of unknown user `%s`.", name,
+		)
+		logger.Info(msg)
+	} else if gid, err := strconv.Atoi(u.Gid); err == nil {
+		primaryGid = gid
+	}
+
+	var sharing *bcpsharing.Sharing
+	if cfg.Sharing != nil {
+		sharing = MustCompileSharing(cfg)
+	}
+	ua := describe.NewUserAccess(
+		name, grp.GroupsOfUser(gs, name, primaryGid),
+		cfg, org, MustCompileFilter(cfg), sharing,
+	)
+	fmt.Printf("%s", describe.MustDescribeUser(ua))
+}
+
+func cmdDescribeMatrix(args map[string]interface{}) {
+	format := args["--format"].(string)
+	if format != "text" && format != "csv" {
+		msg := fmt.Sprintf("Invalid --format `%s`.", format)
+		logger.Fatal(msg)
+	}
+	cfg := MustLoadConfig(args["--config"].(string))
+	_, org, _ := MustLoadGroups(cfg, MustGroupSource(args))
+	filter := MustCompileFilter(cfg)
+	fmt.Printf("%s", describe.MustDescribeMatrix(org, filter, format))
+}
+
+func cmdDescribeExpirations(args map[string]interface{}) {
+	cfg := MustLoadConfig(args["--config"].(string))
+	_, org, _ := MustLoadGroups(cfg, MustGroupSource(args))
+	filter := MustCompileFilter(cfg)
+	fmt.Printf("%s", describe.MustDescribeExpirations(org, filter))
+}
+
+func cmdLintConfig(args map[string]interface{}) {
+	path := args["--config"].(string)
+	files, err := bcpcfg.ConfigFiles(path)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to load config: %v", err)
+		logger.Fatal(msg)
+	}
+	mustLint := func(orgUnits []string) []bcpcfg.Problem {
+		problems, err := bcpcfg.LintFiles(files, orgUnits)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to load config: %v", err)
+			logger.Fatal(msg)
+		}
+		return problems
+	}
+
+	problems := mustLint(nil)
+	if !bcpcfg.HasErrors(problems) {
+		cfg := MustLoadConfig(path)
+		_, org, _ := MustLoadGroups(cfg, MustGroupSource(args))
+		orgUnits := make([]string, 0, len(org.OrgUnits))
+		for _, ou := range org.OrgUnits {
+			orgUnits = append(orgUnits, ou.Name)
+		}
+		problems = mustLint(orgUnits)
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if bcpcfg.HasErrors(problems) {
+		os.Exit(1)
+	}
+}
+
+func cmdTest(args map[string]interface{}) {
+	path := args["<testfile>"].(string)
+	tf, err := policytest.Load(path)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to load tests `%s`: %v", path, err)
+		logger.Fatal(msg)
+	}
+
+	cfg := MustLoadConfig(args["--config"].(string))
+	src := MustGroupSource(args)
+	if _, ok := args["--groups-from"].(string); !ok && tf.Groups != "" {
+		msg := fmt.Sprintf("Using groups from `%s`.", tf.Groups)
+		logger.Info(msg)
+		src = grp.SourceFromFile(tf.Groups)
+	}
+	gs, org, _ := MustLoadGroups(cfg, src)
+
+	var sharing *bcpsharing.Sharing
+	if cfg.Sharing != nil {
+		sharing = MustCompileSharing(cfg)
+	}
+	// The group fixture has no uids.  Member dirs use the live user
+	// database, like `apply`.
+	env, err := policytest.NewEnv(
+		cfg, gs, org, MustCompileFilter(cfg), sharing, nil,
+	)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to evaluate config: %v", err)
+		logger.Fatal(msg)
+	}
+
+	if policytest.Report(os.Stdout, env.Run(tf.Tests)) > 0 {
+		os.Exit(1)
+	}
+}
+
+func cmdDiffConfig(args map[string]interface{}) {
+	src := MustGroupSource(args)
+	mustState := func(path string) *describe.ConfigState {
+		cfg := MustLoadConfig(path)
+		gs, org, _ := MustLoadGroups(cfg, src)
+		var sharing *bcpsharing.Sharing
+		if cfg.Sharing != nil {
+			sharing = MustCompileSharing(cfg)
+		}
+		st, err := describe.NewConfigState(
+			cfg, gs, org, MustCompileFilter(cfg), sharing,
+		)
+		if err != nil {
+			msg := fmt.Sprintf(
+				"Failed to evaluate config `%s`: %v", path, err,
+			)
+			logger.Fatal(msg)
+		}
+		return st
+	}
+
+	changes := describe.DiffConfigStates(
+		mustState(args["<old>"].(string)),
+		mustState(args["<new>"].(string)),
+	)
+	fmt.Printf("%s", describe.MustDescribeConfigDiff(changes))
+}
+
+func cmdInit(args map[string]interface{}) {
+	cfg := &bcpcfg.Root{
+		Rootdir:        args["--rootdir"].(string),
+		ServiceDir:     "srv",
+		OrgUnitDir:     "org",
+		OrgUnitPrefix:  args["--org-unit-prefix"].(string),
+		ServicePrefix:  args["--service-prefix"].(string),
+		OpsSuffix:      "ops",
+		FacilitySuffix: "facility",
+	}
+	if !filepath.IsAbs(cfg.Rootdir) {
+		logger.Fatal("--rootdir must be absolute.")
+	}
+
+	gs, err := MustGroupSource(args).Groups()
+	if err != nil {
+		msg := fmt.Sprintf("Failed to get groups: %v", err)
+		logger.Fatal(msg)
+	}
+	prefixes := []string{
+		fmt.Sprintf("%s_", cfg.OrgUnitPrefix),
+		fmt.Sprintf("%s_", cfg.ServicePrefix),
+	}
+	var names []string
+	for _, g := range grp.SelectGroups(gs, prefixes, nil) {
+		names = append(names, g.Name)
+	}
+	if len(names) == 0 {
+		logger.Fatal("No org unit or service groups.")
+	}
+
+	fmt.Printf("%s", bcpcfg.Skeleton(names, cfg))
+}
+
+func cmdExplainFilter(args map[string]interface{}) {
+	cfg := MustLoadConfig(args["--config"].(string))
+	_, org, _ := MustLoadGroups(cfg, MustGroupSource(args))
+	filter := MustCompileFilter(cfg)
+
+	srvName := args["<service>"].(string)
+	ouName := args["<orgUnit>"].(string)
+	var srv *bcp.Service
+	for i := range org.Services {
+		if org.Services[i].Name == srvName {
+			srv = &org.Services[i]
+		}
+	}
+	if srv == nil {
+		logger.Fatal(fmt.Sprintf("Unknown service `%s`.", srvName))
+	}
+	var ou *bcp.OrgUnit
+	for i := range org.OrgUnits {
+		if org.OrgUnits[i].Name == ouName {
+			ou = &org.OrgUnits[i]
+		}
+	}
+	if ou == nil {
+		logger.Fatal(fmt.Sprintf("Unknown org unit `%s`.", ouName))
+	}
+
+	fmt.Print(filter.Explain(*srv, *ou))
+}
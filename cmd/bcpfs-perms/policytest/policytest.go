@@ -0,0 +1,447 @@
+/*
+Package `policytest` evaluates policy tests, which state the expected outcome
+of a config, like "ag-alice has `srv/tem-505/ag-alice`" or "ag-bob cannot see
+`ms-data`".  The tests are evaluated against the entries that `fsck` expects,
+the filter, and the compiled sharing.  They do not inspect the filesystem, so
+that config changes can be tested before they are applied.
+*/
+package policytest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/fsck"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// `File` is a YAML policy test file:
+//
+//     groups: groups.yaml
+//     tests:
+//       - name: ag-alice has tem-505
+//         path: srv/tem-505/ag-alice
+//         acl: [ "group:org_ag-alice:rwx" ]
+//       - name: ag-bob cannot see ms-data
+//         path: org/ag-bob/ms-data
+//         exists: false
+//       - name: ms-data is rejected for ag-bob
+//         filter: { service: ms-data, orgUnit: ag-bob, action: reject }
+//       - name: ag-alice reads the em guides
+//         share: { path: em-facility/service/guides, group: ag-alice }
+//
+// `Groups` is an optional group fixture, see `grp.SourceFromFile()`.
+// `Load()` resolves it relative to the directory of the test file.
+type File struct {
+	Groups string `yaml:"groups"`
+	Tests  []Test `yaml:"tests"`
+}
+
+// `Test` is a single assertion.  It must specify exactly one of `Path`,
+// `Filter`, or `Share`.
+//
+// `Path` is relative to the rootdir.  The path must be managed, unless
+// `Exists` is false, in which case it must not be managed.  `Symlink` is the
+// expected symlink target.  `Acl` lists entries that the ACL must contain,
+// and `NoAcl` lists entries that it must not contain.  ACL entries use the
+// `getfacl` format, like `group:org_ag-alice:rwx` or
+// `default:group:srv_em-ops:rwx`; group names are resolved to gids.  The
+// paths of expired filter rules are managed, see `fsck.Entry.Optional`.
+type Test struct {
+	Name    string      `yaml:"name"`
+	Path    string      `yaml:"path"`
+	Exists  *bool       `yaml:"exists"`
+	Symlink string      `yaml:"symlink"`
+	Acl     []string    `yaml:"acl"`
+	NoAcl   []string    `yaml:"noAcl"`
+	Filter  *FilterTest `yaml:"filter"`
+	Share   *ShareTest  `yaml:"share"`
+}
+
+// `FilterTest` asserts that the filter decides `Action`, which is `accept` or
+// `reject`, for a combination of service and org unit.
+type FilterTest struct {
+	Service string `yaml:"service"`
+	OrgUnit string `yaml:"orgUnit"`
+	Action  string `yaml:"action"`
+}
+
+// `ShareTest` asserts that the export `Path`, a logical sharing path, is
+// shared with the org unit `Group`, with mode `Mode` if specified.  If
+// `Shared` is false, it must not be shared with the group.
+type ShareTest struct {
+	Path   string `yaml:"path"`
+	Group  string `yaml:"group"`
+	Mode   string `yaml:"mode"`
+	Shared *bool  `yaml:"shared"`
+}
+
+// `Load()` reads a policy test file and checks that the tests are
+// well-formed.  Unnamed tests are named by their position, like `test 2`.
+func Load(path string) (*File, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := yaml.UnmarshalStrict(d, &f); err != nil {
+		return nil, err
+	}
+	if f.Groups != "" && !filepath.IsAbs(f.Groups) {
+		f.Groups = filepath.Join(filepath.Dir(path), f.Groups)
+	}
+	if len(f.Tests) == 0 {
+		return nil, errors.New("no tests")
+	}
+	for i := range f.Tests {
+		t := &f.Tests[i]
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("test %d", i+1)
+		}
+		if err := validateTest(*t); err != nil {
+			return nil, fmt.Errorf("invalid `%s`: %v", t.Name, err)
+		}
+	}
+	return &f, nil
+}
+
+func validateTest(t Test) error {
+	n := 0
+	if t.Path != "" {
+		n++
+	}
+	if t.Filter != nil {
+		n++
+	}
+	if t.Share != nil {
+		n++
+	}
+	if n != 1 {
+		return errors.New(
+			"requires exactly one of `path`, `filter`, or `share`",
+		)
+	}
+
+	isPath := t.Path != ""
+	if !isPath && (t.Exists != nil || t.Symlink != "" ||
+		len(t.Acl) > 0 || len(t.NoAcl) > 0) {
+		return errors.New(
+			"`exists`, `symlink`, `acl`, and `noAcl` " +
+				"require `path`",
+		)
+	}
+	if isPath && t.Exists != nil && !*t.Exists &&
+		(t.Symlink != "" || len(t.Acl) > 0 || len(t.NoAcl) > 0) {
+		return errors.New(
+			"`symlink`, `acl`, and `noAcl` require a managed path",
+		)
+	}
+
+	if f := t.Filter; f != nil {
+		if f.Service == "" || f.OrgUnit == "" {
+			return errors.New(
+				"`filter` requires `service` and `orgUnit`",
+			)
+		}
+		if f.Action != "accept" && f.Action != "reject" {
+			return fmt.Errorf(
+				"invalid filter action `%s`", f.Action,
+			)
+		}
+	}
+
+	if s := t.Share; s != nil {
+		if s.Path == "" || s.Group == "" {
+			return errors.New("`share` requires `path` and `group`")
+		}
+	}
+	return nil
+}
+
+// `Env` is the expected state against which tests are evaluated.
+type Env struct {
+	rootdir  string
+	org      *bcp.Organization
+	filter   bfilter.OrgServiceFilter
+	sharing  *bcpsharing.Sharing
+	gids     map[string]int
+	entries  map[string]fsck.Entry
+	symlinks map[string]string
+}
+
+// `NewEnv()` determines the expected state for a config and the Unix groups
+// `gs`, from which `org` has been created.  `sharing` may be nil if the config
+// has no sharing.  `lookupUid` determines the owners of per-member
+// directories; nil uses `bcp.LookupUid()`.
+func NewEnv(
+	cfg *bcpcfg.Root, gs []grp.Group, org *bcp.Organization,
+	filter bfilter.OrgServiceFilter,
+	sharing *bcpsharing.Sharing,
+	lookupUid bcp.UidFunc,
+) (*Env, error) {
+	entries, err := fsck.ExpectedEntries(cfg, org, filter, lookupUid)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(cfg.Rootdir)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &Env{
+		rootdir:  root,
+		org:      org,
+		filter:   filter,
+		sharing:  sharing,
+		gids:     make(map[string]int),
+		entries:  make(map[string]fsck.Entry),
+		symlinks: make(map[string]string),
+	}
+	for _, g := range gs {
+		env.gids[g.Name] = g.Gid
+	}
+	for _, e := range entries {
+		env.entries[e.Path] = e
+	}
+	for _, link := range cfg.Symlinks {
+		env.symlinks[filepath.Join(root, link.Path)] = link.Target
+	}
+	return env, nil
+}
+
+// `Result` is the outcome of a test.  The test passed if `Failures` is
+// empty.
+type Result struct {
+	Name     string
+	Failures []string
+}
+
+func (r Result) Ok() bool {
+	return len(r.Failures) == 0
+}
+
+// `Run()` evaluates the tests in order.
+func (env *Env) Run(tests []Test) []Result {
+	results := make([]Result, 0, len(tests))
+	for _, t := range tests {
+		var failures []string
+		switch {
+		case t.Filter != nil:
+			failures = env.runFilter(*t.Filter)
+		case t.Share != nil:
+			failures = env.runShare(*t.Share)
+		default:
+			failures = env.runPath(t)
+		}
+		results = append(results, Result{
+			Name: t.Name, Failures: failures,
+		})
+	}
+	return results
+}
+
+func (env *Env) runPath(t Test) (failures []string) {
+	fail := func(format string, a ...interface{}) {
+		failures = append(failures, fmt.Sprintf(format, a...))
+	}
+
+	rel := filepath.Clean(t.Path)
+	path := filepath.Join(env.rootdir, rel)
+	entry, isEntry := env.entries[path]
+	target, isExplicit := env.symlinks[path]
+	managed := isEntry || isExplicit
+
+	if t.Exists != nil && !*t.Exists {
+		if managed {
+			fail("`%s` is managed, expected no such path", rel)
+		}
+		return
+	}
+	if !managed {
+		fail("`%s` is not managed", rel)
+		return
+	}
+
+	isSymlink := isExplicit || entry.IsSymlink
+	if isEntry && entry.IsSymlink {
+		target = entry.LinkDest
+	}
+	if t.Symlink != "" {
+		switch {
+		case !isSymlink:
+			fail("`%s` is not a symlink", rel)
+		case target != t.Symlink:
+			fail(
+				"symlink `%s` points to `%s`, expected `%s`",
+				rel, target, t.Symlink,
+			)
+		}
+	}
+
+	if len(t.Acl) == 0 && len(t.NoAcl) == 0 {
+		return
+	}
+	if isSymlink {
+		fail("`%s` is a symlink without ACL", rel)
+		return
+	}
+	has := make(map[string]bool)
+	for _, line := range strings.Split(entry.ACL.FACLString(), "\n") {
+		has[line] = true
+	}
+	for _, ace := range t.Acl {
+		want, err := env.resolveAce(ace)
+		if err != nil {
+			fail("%v", err)
+		} else if !has[want] {
+			fail("ACL of `%s` lacks `%s`", rel, ace)
+		}
+	}
+	for _, ace := range t.NoAcl {
+		want, err := env.resolveAce(ace)
+		if err != nil {
+			fail("%v", err)
+		} else if has[want] {
+			fail("ACL of `%s` contains `%s`", rel, ace)
+		}
+	}
+	return
+}
+
+// `resolveAce()` replaces the group name of a named group ACL entry, like
+// `group:org_ag-alice:rwx`, by the gid, as used in `fsck.ACL.FACLString()`.
+func (env *Env) resolveAce(ace string) (string, error) {
+	fields := strings.Split(ace, ":")
+	i := 0
+	if fields[0] == "default" {
+		i = 1
+	}
+	if len(fields) != i+3 {
+		return "", fmt.Errorf("malformed ACL entry `%s`", ace)
+	}
+	name := fields[i+1]
+	if fields[i] != "group" || name == "" {
+		return ace, nil
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return ace, nil
+	}
+	gid, ok := env.gids[name]
+	if !ok {
+		return "", fmt.Errorf(
+			"unknown group `%s` in ACL entry `%s`", name, ace,
+		)
+	}
+	fields[i+1] = strconv.Itoa(gid)
+	return strings.Join(fields, ":"), nil
+}
+
+func (env *Env) runFilter(t FilterTest) []string {
+	var srv *bcp.Service
+	for i := range env.org.Services {
+		if env.org.Services[i].Name == t.Service {
+			srv = &env.org.Services[i]
+		}
+	}
+	if srv == nil {
+		return []string{fmt.Sprintf("unknown service `%s`", t.Service)}
+	}
+	var ou *bcp.OrgUnit
+	for i := range env.org.OrgUnits {
+		if env.org.OrgUnits[i].Name == t.OrgUnit {
+			ou = &env.org.OrgUnits[i]
+		}
+	}
+	if ou == nil {
+		return []string{fmt.Sprintf("unknown org unit `%s`", t.OrgUnit)}
+	}
+
+	ok, reason := env.filter.Accept(*srv, *ou)
+	got := "reject"
+	if ok {
+		got = "accept"
+	}
+	if got != t.Action {
+		return []string{fmt.Sprintf(
+			"`service=%s orgUnit=%s`: %s (%s), expected %s",
+			t.Service, t.OrgUnit, got, reason, t.Action,
+		)}
+	}
+	return nil
+}
+
+func (env *Env) runShare(t ShareTest) []string {
+	if env.sharing == nil {
+		return []string{"no sharing config"}
+	}
+	var ace *bcpsharing.Ace
+	for _, exp := range env.sharing.Shares {
+		if exp.Path != t.Path {
+			continue
+		}
+		for i := range exp.Acl {
+			if exp.Acl[i].Group == t.Group {
+				ace = &exp.Acl[i]
+			}
+		}
+	}
+
+	if t.Shared != nil && !*t.Shared {
+		if ace != nil {
+			return []string{fmt.Sprintf(
+				"`%s` is shared with `%s`, expected not shared",
+				t.Path, t.Group,
+			)}
+		}
+		return nil
+	}
+	if ace == nil {
+		return []string{fmt.Sprintf(
+			"`%s` is not shared with `%s`", t.Path, t.Group,
+		)}
+	}
+	if t.Mode != "" && string(ace.Mode) != t.Mode {
+		return []string{fmt.Sprintf(
+			"`%s` is shared with `%s` with mode `%s`, "+
+				"expected `%s`",
+			t.Path, t.Group, ace.Mode, t.Mode,
+		)}
+	}
+	return nil
+}
+
+// `Report()` writes the results like a unit test runner, with a line per
+// test, the failures, and a summary.  It returns the number of failed tests.
+func Report(w io.Writer, results []Result) (failed int) {
+	for _, r := range results {
+		if r.Ok() {
+			fmt.Fprintf(w, "--- PASS: %s\n", r.Name)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "--- FAIL: %s\n", r.Name)
+		for _, f := range r.Failures {
+			fmt.Fprintf(w, "    %s\n", f)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(
+			w, "FAIL: %d of %d tests failed\n",
+			failed, len(results),
+		)
+	} else {
+		fmt.Fprintf(w, "PASS: %d tests\n", len(results))
+	}
+	return failed
+}
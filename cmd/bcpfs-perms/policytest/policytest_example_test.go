@@ -0,0 +1,176 @@
+package policytest_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpcfg"
+	bfilter "github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpfilter"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/bcpsharing"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/grp"
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/policytest"
+)
+
+const config = `
+rootdir = "/orgfs/data"
+serviceDir = "srv"
+orgUnitDir = "org"
+orgUnitPrefix = "org"
+servicePrefix = "srv"
+opsSuffix = "ops"
+facilitySuffix = "facility"
+
+facility {
+    name = "em"
+    services = [ "tem" ]
+    access = "perService"
+}
+
+orgUnit {
+    name = "ag-alice"
+    subdirs = [ { name = "shared", policy = "manager" } ]
+}
+
+filter {
+    service = "tem"
+    orgUnit = "ag-alice"
+    action = "accept"
+}
+
+sharing {
+    namingPolicy { action = "allow", match = "ag-alice/tem(/.*)?" }
+    export {
+        path = "ag-alice/tem/data"
+        acl = [ "group:ag-bob:r-x" ]
+    }
+    import { action = "accept", group = "ag-bob", match = "ag-alice/.*" }
+}
+`
+
+const tests = `
+tests:
+  - name: ag-alice has tem
+    path: srv/tem/ag-alice
+    acl: [ "group:org_ag-alice:rwx" ]
+    noAcl: [ "group:org_ag-bob:rwx" ]
+  - name: ag-alice links tem
+    path: org/ag-alice/tem
+    symlink: ../../srv/tem/ag-alice
+  - name: ag-bob cannot see tem
+    path: org/ag-bob/tem
+    exists: false
+  - filter: { service: tem, orgUnit: ag-bob, action: accept }
+  - share: { path: ag-alice/tem/data, group: ag-bob, mode: rwx }
+`
+
+var groups = []grp.Group{
+	{Name: "org_ag-alice", Gid: 2001},
+	{Name: "org_ag-bob", Gid: 2002},
+	{Name: "org_em-facility", Gid: 2003},
+	{Name: "srv_tem", Gid: 2011},
+	{Name: "srv_em-ops", Gid: 2021},
+}
+
+func Example() {
+	dir, err := ioutil.TempDir("", "policytest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tests.yaml")
+	if err := ioutil.WriteFile(path, []byte(tests), 0644); err != nil {
+		panic(err)
+	}
+	tf, err := policytest.Load(path)
+	if err != nil {
+		panic(err)
+	}
+
+	cfg, err := bcpcfg.Parse(config)
+	if err != nil {
+		panic(err)
+	}
+	org, _, err := bcp.New(groups, cfg)
+	if err != nil {
+		panic(err)
+	}
+	var deciders []bfilter.Decider
+	for _, rule := range cfg.Filter {
+		d, err := bfilter.NewFilterRuleDecider(rule)
+		if err != nil {
+			panic(err)
+		}
+		deciders = append(deciders, d)
+	}
+	filter := &bfilter.DecidersFilter{Rules: deciders}
+	sharing, err := bcpsharing.Compile(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	env, err := policytest.NewEnv(cfg, groups, org, filter, sharing, nil)
+	if err != nil {
+		panic(err)
+	}
+	failed := policytest.Report(os.Stdout, env.Run(tf.Tests))
+	fmt.Println(failed)
+
+	// Output:
+	// --- PASS: ag-alice has tem
+	// --- PASS: ag-alice links tem
+	// --- PASS: ag-bob cannot see tem
+	// --- FAIL: test 4
+	//     `service=tem orgUnit=ag-bob`: reject (no rule accepted), expected accept
+	// --- FAIL: test 5
+	//     `ag-alice/tem/data` is shared with `ag-bob` with mode `r-x`, expected `rwx`
+	// FAIL: 2 of 5 tests failed
+	// 2
+}
+
+func ExampleLoad() {
+	dir, err := ioutil.TempDir("", "policytest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(content string) string {
+		path := filepath.Join(dir, "tests.yaml")
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			panic(err)
+		}
+		return path
+	}
+
+	tf, err := policytest.Load(write(`
+groups: groups.yaml
+tests:
+  - path: srv/tem
+`))
+	fmt.Println(err)
+	fmt.Println(tf.Groups == filepath.Join(dir, "groups.yaml"))
+	fmt.Println(tf.Tests[0].Name)
+
+	_, err = policytest.Load(write(`
+tests:
+  - path: srv/tem
+    filter: { service: tem, orgUnit: ag-bob, action: accept }
+`))
+	fmt.Println(err)
+
+	_, err = policytest.Load(write(`
+tests:
+  - filter: { service: tem, orgUnit: ag-bob, action: allow }
+`))
+	fmt.Println(err)
+
+	// Output:
+	// <nil>
+	// true
+	// test 1
+	// invalid `test 1`: requires exactly one of `path`, `filter`, or `share`
+	// invalid `test 1`: invalid filter action `allow`
+}
@@ -0,0 +1,130 @@
+// Package `runlock` provides an advisory lock that prevents concurrent
+// `bcpfs-perms` runs from modifying the same filesystem.
+//
+// The lock is an exclusive `flock(2)` on a lock file.  The kernel releases the
+// lock when the process exits, so that a crashed run does not leave a stale
+// lock.  The holder writes its PID and start time to the lock file, so that
+// other runs can report who holds the lock.
+package runlock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// `Name` is the name of the lock file in the rootdir.
+const Name = ".bcpfs-perms.lock"
+
+// `Holder` describes the process that holds a lock.
+type Holder struct {
+	Pid   int
+	Start time.Time
+}
+
+func (h Holder) String() string {
+	if h.Pid == 0 {
+		return "unknown process"
+	}
+	return fmt.Sprintf(
+		"pid %d, started %s", h.Pid, h.Start.Format(time.RFC3339),
+	)
+}
+
+// `HeldError` is returned by `Acquire()` without waiting if another process
+// holds the lock.
+type HeldError struct {
+	Path   string
+	Holder Holder
+}
+
+func (err *HeldError) Error() string {
+	return fmt.Sprintf("lock `%s` is held by %s", err.Path, err.Holder)
+}
+
+// `Lock` is an acquired lock.
+type Lock struct {
+	path string
+	f    *os.File
+}
+
+// `Acquire()` locks the file `path`, creating it if necessary.  If another
+// process holds the lock and `wait` is false, it returns a `*HeldError`.  If
+// `wait` is true, it calls `waiting` with the current holder, unless `waiting`
+// is nil, and blocks until the lock is available.
+func Acquire(
+	path string, wait bool, waiting func(Holder),
+) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		holder := readHolder(path)
+		if !wait {
+			_ = f.Close()
+			return nil, &HeldError{Path: path, Holder: holder}
+		}
+		if waiting != nil {
+			waiting(holder)
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock `%s`: %v", path, err)
+	}
+
+	l := &Lock{path: path, f: f}
+	if err := l.writeHolder(); err != nil {
+		_ = l.Release()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Lock) writeHolder() error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.f.WriteAt([]byte(fmt.Sprintf(
+		"pid=%d start=%s\n",
+		os.Getpid(), time.Now().UTC().Format(time.RFC3339),
+	)), 0)
+	return err
+}
+
+// `Release()` clears the holder information and unlocks.
+func (l *Lock) Release() error {
+	_ = l.f.Truncate(0)
+	return l.f.Close()
+}
+
+// `readHolder()` parses the holder information.  It returns a zero `Holder`
+// if the information is not available, for example because the holder has
+// not written it yet.
+func readHolder(path string) Holder {
+	var h Holder
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	for _, field := range strings.Fields(string(data)) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "pid":
+			_, _ = fmt.Sscan(kv[1], &h.Pid)
+		case "start":
+			h.Start, _ = time.Parse(time.RFC3339, kv[1])
+		}
+	}
+	return h
+}
@@ -0,0 +1,70 @@
+package runlock_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nogproject/bcpfs/cmd/bcpfs-perms/runlock"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, runlock.Name)
+
+	l, err := runlock.Acquire(path, false, nil)
+	if err != nil {
+		t.Fatalf("Acquire() failed: %v", err)
+	}
+
+	// `flock(2)` locks are per open file, so that a second `Acquire()`
+	// conflicts even in the same process.
+	_, err = runlock.Acquire(path, false, nil)
+	held, ok := err.(*runlock.HeldError)
+	if !ok {
+		t.Fatalf("expected HeldError, got %v", err)
+	}
+	if held.Holder.Pid != os.Getpid() {
+		t.Errorf("wrong holder pid %d", held.Holder.Pid)
+	}
+	if time.Since(held.Holder.Start) > time.Minute {
+		t.Errorf("wrong holder start %s", held.Holder.Start)
+	}
+
+	acquired := make(chan *runlock.Lock)
+	waiting := make(chan runlock.Holder, 1)
+	go func() {
+		l2, err := runlock.Acquire(path, true, func(h runlock.Holder) {
+			waiting <- h
+		})
+		if err != nil {
+			t.Errorf("Acquire() with wait failed: %v", err)
+		}
+		acquired <- l2
+	}()
+	if h := <-waiting; h.Pid != os.Getpid() {
+		t.Errorf("wrong waiting holder %s", h)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("acquired lock while held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release() failed: %v", err)
+	}
+	l2 := <-acquired
+	if l2 == nil {
+		t.Fatal("missing lock")
+	}
+	if err := l2.Release(); err != nil {
+		t.Fatalf("Release() failed: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+// Package `aclfs` provides an interface `FS` for the filesystem operations
+// that are needed to manage directories with owners and POSIX ACLs.  `OS`
+// implements `FS` for the real filesystem.  `MemFS` is an in-memory
+// implementation that models ownership, SGID, symlinks, and POSIX ACLs
+// including default ACL inheritance, so that code can be tested without root
+// privileges.
+//
+// Paths must be absolute.
+package aclfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+// `FS` is the filesystem interface.  The functions behave like the
+// corresponding functions of packages `os` and `ioutil`.  `ReadDir()` returns
+// entries sorted by name.  `GetACL()` and the ACL setters follow symlinks;
+// see package `posixacl` for details.
+type FS interface {
+	Lstat(path string) (os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+	Mkdir(path string, perm os.FileMode) error
+	Lchown(path string, uid, gid int) error
+	Chmod(path string, mode os.FileMode) error
+	Symlink(target, path string) error
+	Readlink(path string) (string, error)
+	Remove(path string) error
+	ReadDir(path string) ([]os.FileInfo, error)
+	GetACL(path string) (posixacl.FileACL, error)
+	SetAccessACL(path string, acl posixacl.ACL) error
+	SetDefaultACL(path string, acl posixacl.ACL) error
+}
+
+// `OS` implements `FS` for the real filesystem.
+type OS struct{}
+
+var _ FS = OS{}
+
+func (OS) Lstat(path string) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
+
+func (OS) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (OS) Mkdir(path string, perm os.FileMode) error {
+	return os.Mkdir(path, perm)
+}
+
+func (OS) Lchown(path string, uid, gid int) error {
+	return os.Lchown(path, uid, gid)
+}
+
+func (OS) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(path, mode)
+}
+
+func (OS) Symlink(target, path string) error {
+	return os.Symlink(target, path)
+}
+
+func (OS) Readlink(path string) (string, error) {
+	return os.Readlink(path)
+}
+
+func (OS) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (OS) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(path)
+}
+
+func (OS) GetACL(path string) (posixacl.FileACL, error) {
+	return posixacl.Get(path)
+}
+
+func (OS) SetAccessACL(path string, acl posixacl.ACL) error {
+	return posixacl.SetAccess(path, acl)
+}
+
+func (OS) SetDefaultACL(path string, acl posixacl.ACL) error {
+	return posixacl.SetDefault(path, acl)
+}
+
+// `Owner()` returns the uid and gid from a `FileInfo` that has been returned
+// by one of the `FS` implementations.
+func Owner(fi os.FileInfo) (uid, gid int) {
+	st := fi.Sys().(*syscall.Stat_t)
+	return int(st.Uid), int(st.Gid)
+}
+
+// `UpdateACL()` writes the access and default ACLs of `path` that differ
+// between `actual` and `want`.  Unmodified ACLs are not written in order to
+// avoid unnecessary ctime changes.
+func UpdateACL(fs FS, path string, actual, want posixacl.FileACL) error {
+	if !want.Access.Equal(actual.Access) {
+		if err := fs.SetAccessACL(path, want.Access); err != nil {
+			return err
+		}
+	}
+	if !want.Default.Equal(actual.Default) {
+		if err := fs.SetDefaultACL(path, want.Default); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// `Walk()` is like `filepath.Walk()` but uses `fs`.  It does not follow
+// symlinks.
+func Walk(fs FS, root string, walkFn filepath.WalkFunc) error {
+	fi, err := fs.Lstat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = walk(fs, root, fi, walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walk(fs FS, path string, fi os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !fi.IsDir() {
+		return walkFn(path, fi, nil)
+	}
+
+	children, err := fs.ReadDir(path)
+	err1 := walkFn(path, fi, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+	for _, c := range children {
+		p := filepath.Join(path, c.Name())
+		if err := walk(fs, p, c, walkFn); err != nil {
+			if !c.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
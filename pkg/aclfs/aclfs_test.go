@@ -0,0 +1,182 @@
+package aclfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+// `TestMemFSLikeOS` runs the same operations on the real filesystem and on
+// `MemFS` and compares the resulting owners, modes, and ACLs.
+func TestMemFSLikeOS(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "aclfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	umask := syscall.Umask(022)
+	defer syscall.Umask(umask)
+
+	mem := NewMemFS()
+	mem.Uid = os.Getuid()
+	mem.Gid = os.Getgid()
+	if err := mem.MkdirAll(tmp, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	gid := os.Getgid()
+	run := func(fs FS, createFile func(string) error) error {
+		p := func(rel string) string { return filepath.Join(tmp, rel) }
+		if err := fs.Mkdir(p("a"), 0777); err != nil {
+			return err
+		}
+		if err := fs.Chmod(p("a"), os.ModeSetgid|0750); err != nil {
+			return err
+		}
+		acl := posixacl.MustParseFileACL(
+			"user::rwx",
+			"group::---",
+			"group:12345:rwx",
+			"mask::rwx",
+			"other::---",
+		).Access
+		if err := fs.SetAccessACL(p("a"), acl); err != nil {
+			return err
+		}
+		dflt := acl.Set(posixacl.Entry{
+			Tag: posixacl.TagGroup, ID: 12346, Perm: 5,
+		})
+		if err := fs.SetDefaultACL(p("a"), dflt); err != nil {
+			return err
+		}
+		if err := fs.Mkdir(p("a/b"), 0755); err != nil {
+			return err
+		}
+		if err := createFile(p("a/b/f")); err != nil {
+			return err
+		}
+		if err := fs.Chmod(p("a/b"), 0700); err != nil {
+			return err
+		}
+		if err := fs.Lchown(p("a/b/f"), -1, gid); err != nil {
+			return err
+		}
+		if err := fs.Symlink("a/b", p("l")); err != nil {
+			return err
+		}
+		if err := fs.SetDefaultACL(p("l"), nil); err != nil {
+			return err
+		}
+		if err := fs.Mkdir(p("c"), 0755); err != nil {
+			return err
+		}
+		return fs.Remove(p("c"))
+	}
+
+	createOSFile := func(path string) error {
+		return ioutil.WriteFile(path, nil, 0666)
+	}
+	if err := run(OS{}, createOSFile); err != nil {
+		if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.ENOTSUP {
+			t.Skip("filesystem does not support ACLs")
+		}
+		t.Fatal(err)
+	}
+	createMemFile := func(path string) error {
+		return mem.CreateFile(path, 0666)
+	}
+	if err := run(mem, createMemFile); err != nil {
+		t.Fatal(err)
+	}
+
+	compare := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		mfi, err := mem.Lstat(path)
+		if err != nil {
+			t.Errorf("missing in MemFS: %v", err)
+			return nil
+		}
+		if mfi.Mode() != fi.Mode() {
+			t.Errorf("`%s`: mode %v, want %v", path, mfi.Mode(), fi.Mode())
+		}
+		muid, mgid := Owner(mfi)
+		uid, gid := Owner(fi)
+		if muid != uid || mgid != gid {
+			t.Errorf(
+				"`%s`: owner %d:%d, want %d:%d",
+				path, muid, mgid, uid, gid,
+			)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			mt, _ := mem.Readlink(path)
+			ot, _ := os.Readlink(path)
+			if mt != ot {
+				t.Errorf("`%s`: target `%s`, want `%s`", path, mt, ot)
+			}
+			return nil
+		}
+		macl, err := mem.GetACL(path)
+		if err != nil {
+			return err
+		}
+		oacl, err := OS{}.GetACL(path)
+		if err != nil {
+			return err
+		}
+		if !macl.Equal(oacl) {
+			t.Errorf("`%s`: ACL `%s`, want `%s`", path, macl, oacl)
+		}
+		return nil
+	}
+	if err := Walk(OS{}, tmp, compare); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mem.Lstat(filepath.Join(tmp, "c")); !os.IsNotExist(err) {
+		t.Errorf("removed dir still exists")
+	}
+	if fi, err := mem.Stat(filepath.Join(tmp, "l/f")); err != nil {
+		t.Errorf("failed to stat via symlink: %v", err)
+	} else if !fi.Mode().IsRegular() {
+		t.Errorf("wrong file via symlink")
+	}
+}
+
+func TestMemFSErrors(t *testing.T) {
+	fs := NewMemFS()
+	if err := fs.Mkdir("/a/b", 0755); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+	if err := fs.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/a", 0755); !os.IsExist(err) {
+		t.Errorf("expected exist error, got %v", err)
+	}
+	err := fs.Remove("/a")
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.ENOTEMPTY {
+		t.Errorf("expected ENOTEMPTY, got %v", err)
+	}
+	if err := fs.Symlink("/x", "/a/l"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/a/l"); !os.IsNotExist(err) {
+		t.Errorf("expected dangling symlink, got %v", err)
+	}
+	if err := fs.Symlink("l2", "/a/l1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("l1", "/a/l2"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.Stat("/a/l1")
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.ELOOP {
+		t.Errorf("expected ELOOP, got %v", err)
+	}
+}
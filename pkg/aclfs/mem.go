@@ -0,0 +1,479 @@
+package aclfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nogproject/bcpfs/pkg/posixacl"
+)
+
+// `MemFS` is an in-memory `FS`.  New files are owned by `Uid` and `Gid`,
+// unless the parent directory has the SGID bit set, in which case the new
+// file inherits the parent group, and new directories also inherit the SGID
+// bit.  New files inherit the parent default ACL like on Linux.  `Umask` is
+// only applied if the parent has no default ACL.
+//
+// `MemFS` does not check permissions; it behaves as if run by root.  It is
+// safe for concurrent use.  `Uid`, `Gid`, and `Umask` must not be modified
+// concurrently.
+type MemFS struct {
+	Uid   int
+	Gid   int
+	Umask os.FileMode
+	mu    sync.Mutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	mode   os.FileMode
+	uid    int
+	gid    int
+	access posixacl.ACL
+	dflt   posixacl.ACL
+	target string
+}
+
+var _ FS = &MemFS{}
+
+const maxSymlinkHops = 40
+
+// `NewMemFS()` returns a `MemFS` that contains only the root directory `/`
+// with mode 0755.  The default owner is root and the default umask 022.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		Umask: 022,
+		nodes: map[string]*memNode{
+			"/": {mode: os.ModeDir | 0755},
+		},
+	}
+}
+
+func pathErr(op, path string, err error) error {
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// `resolve()` returns the symlink-free path of `path`.  The last path element
+// may be missing.  It is only resolved if it is a symlink and `followLast` is
+// true.
+func (m *MemFS) resolve(path string, followLast bool) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", syscall.EINVAL
+	}
+	parts := splitPath(path)
+	cur := "/"
+	hops := 0
+	for i := 0; i < len(parts); i++ {
+		next := filepath.Join(cur, parts[i])
+		isLast := i == len(parts)-1
+		n, ok := m.nodes[next]
+		if !ok {
+			if isLast {
+				return next, nil
+			}
+			return "", syscall.ENOENT
+		}
+		if n.mode&os.ModeSymlink != 0 && (!isLast || followLast) {
+			hops++
+			if hops > maxSymlinkHops {
+				return "", syscall.ELOOP
+			}
+			target := n.target
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(cur, target)
+			}
+			parts = append(splitPath(target), parts[i+1:]...)
+			cur = "/"
+			i = -1
+			continue
+		}
+		if !isLast && !n.mode.IsDir() {
+			return "", syscall.ENOTDIR
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+func splitPath(path string) []string {
+	path = filepath.Clean(path)
+	if path == "/" {
+		return nil
+	}
+	return strings.Split(path[1:], "/")
+}
+
+// `lookup()` resolves `path` and returns the node.
+func (m *MemFS) lookup(
+	op, path string, followLast bool,
+) (string, *memNode, error) {
+	p, err := m.resolve(path, followLast)
+	if err != nil {
+		return "", nil, pathErr(op, path, err)
+	}
+	n, ok := m.nodes[p]
+	if !ok {
+		return "", nil, pathErr(op, path, syscall.ENOENT)
+	}
+	return p, n, nil
+}
+
+func (m *MemFS) Lstat(path string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, n, err := m.lookup("lstat", path, false)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(p), nil
+}
+
+func (m *MemFS) Stat(path string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, n, err := m.lookup("stat", path, true)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(p), nil
+}
+
+func (m *MemFS) Mkdir(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.create("mkdir", path, os.ModeDir|perm.Perm())
+}
+
+// `CreateFile()` creates an empty regular file.
+func (m *MemFS) CreateFile(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.create("open", path, perm.Perm())
+}
+
+// `MkdirAll()` is like `os.MkdirAll()`.
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdirAll(path, perm)
+}
+
+func (m *MemFS) mkdirAll(path string, perm os.FileMode) error {
+	if _, n, err := m.lookup("stat", path, true); err == nil {
+		if n.mode.IsDir() {
+			return nil
+		}
+		return pathErr("mkdir", path, syscall.ENOTDIR)
+	}
+	parent := filepath.Dir(filepath.Clean(path))
+	if parent != path {
+		if err := m.mkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	return m.create("mkdir", path, os.ModeDir|perm.Perm())
+}
+
+// `create()` creates a new node with parent inheritance, see `MemFS`.
+func (m *MemFS) create(op, path string, mode os.FileMode) error {
+	p, err := m.resolve(path, false)
+	if err != nil {
+		return pathErr(op, path, err)
+	}
+	if _, ok := m.nodes[p]; ok {
+		return pathErr(op, path, syscall.EEXIST)
+	}
+	parent, ok := m.nodes[filepath.Dir(p)]
+	if !ok {
+		return pathErr(op, path, syscall.ENOENT)
+	}
+	if !parent.mode.IsDir() {
+		return pathErr(op, path, syscall.ENOTDIR)
+	}
+
+	n := &memNode{uid: m.Uid, gid: m.Gid}
+	if parent.mode&os.ModeSetgid != 0 {
+		n.gid = parent.gid
+		if mode.IsDir() {
+			mode |= os.ModeSetgid
+		}
+	}
+
+	if len(parent.dflt) == 0 {
+		n.mode = mode &^ m.Umask
+	} else {
+		n.access = inheritACL(parent.dflt, mode)
+		n.mode = mode&^os.ModePerm | n.access.Mode()
+		if n.access.IsMinimal() {
+			n.access = nil
+		}
+		if mode.IsDir() {
+			n.dflt = parent.dflt.Sorted()
+		}
+	}
+
+	m.nodes[p] = n
+	return nil
+}
+
+// `inheritACL()` computes the access ACL of a new file from the parent
+// default ACL like Linux `posix_acl_create()`: the permissions of the user
+// obj, other, and the mask, or the group obj if there is no mask, are limited
+// by the `mode` of the create call.
+func inheritACL(dflt posixacl.ACL, mode os.FileMode) posixacl.ACL {
+	hasMask := dflt.HasKey(posixacl.Entry{Tag: posixacl.TagMask})
+	u := posixacl.Perm(mode>>6) & 7
+	g := posixacl.Perm(mode>>3) & 7
+	o := posixacl.Perm(mode) & 7
+
+	a := make(posixacl.ACL, 0, len(dflt))
+	for _, e := range dflt {
+		switch {
+		case e.Tag == posixacl.TagUserObj:
+			e.Perm &= u
+		case e.Tag == posixacl.TagMask:
+			e.Perm &= g
+		case e.Tag == posixacl.TagGroupObj && !hasMask:
+			e.Perm &= g
+		case e.Tag == posixacl.TagOther:
+			e.Perm &= o
+		}
+		a = append(a, e)
+	}
+	return a.Sorted()
+}
+
+func (m *MemFS) Lchown(path string, uid, gid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("lchown", path, false)
+	if err != nil {
+		return err
+	}
+	if uid >= 0 {
+		n.uid = uid
+	}
+	if gid >= 0 {
+		n.gid = gid
+	}
+	return nil
+}
+
+// `Chmod()` sets the permission bits and the SGID, SUID, and sticky bits.  If
+// the file has an extended ACL, the user obj, mask, and other entries are
+// updated accordingly, like on Linux.
+func (m *MemFS) Chmod(path string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("chmod", path, true)
+	if err != nil {
+		return err
+	}
+	special := os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	n.mode = n.mode&^(os.ModePerm|special) | mode&(os.ModePerm|special)
+	if n.access != nil {
+		n.access = n.access.Set(modeEntries(n.access, mode)...)
+	}
+	return nil
+}
+
+// `modeEntries()` returns the entries that correspond to the permission bits
+// of `mode`: user obj, mask if the ACL has a mask or group obj, and other.
+func modeEntries(a posixacl.ACL, mode os.FileMode) []posixacl.Entry {
+	groupTag := posixacl.TagGroupObj
+	if a.HasKey(posixacl.Entry{Tag: posixacl.TagMask}) {
+		groupTag = posixacl.TagMask
+	}
+	return []posixacl.Entry{
+		{Tag: posixacl.TagUserObj, Perm: posixacl.Perm(mode>>6) & 7},
+		{Tag: groupTag, Perm: posixacl.Perm(mode>>3) & 7},
+		{Tag: posixacl.TagOther, Perm: posixacl.Perm(mode) & 7},
+	}
+}
+
+func (m *MemFS) Symlink(target, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.resolve(path, false)
+	if err != nil {
+		return pathErr("symlink", path, err)
+	}
+	if _, ok := m.nodes[p]; ok {
+		return pathErr("symlink", path, syscall.EEXIST)
+	}
+	parent, ok := m.nodes[filepath.Dir(p)]
+	if !ok {
+		return pathErr("symlink", path, syscall.ENOENT)
+	}
+	if !parent.mode.IsDir() {
+		return pathErr("symlink", path, syscall.ENOTDIR)
+	}
+	gid := m.Gid
+	if parent.mode&os.ModeSetgid != 0 {
+		gid = parent.gid
+	}
+	m.nodes[p] = &memNode{
+		mode:   os.ModeSymlink | 0777,
+		uid:    m.Uid,
+		gid:    gid,
+		target: target,
+	}
+	return nil
+}
+
+func (m *MemFS) Readlink(path string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("readlink", path, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&os.ModeSymlink == 0 {
+		return "", pathErr("readlink", path, syscall.EINVAL)
+	}
+	return n.target, nil
+}
+
+func (m *MemFS) Remove(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, n, err := m.lookup("remove", path, false)
+	if err != nil {
+		return err
+	}
+	if p == "/" {
+		return pathErr("remove", path, syscall.EBUSY)
+	}
+	if n.mode.IsDir() && len(m.children(p)) > 0 {
+		return pathErr("remove", path, syscall.ENOTEMPTY)
+	}
+	delete(m.nodes, p)
+	return nil
+}
+
+func (m *MemFS) ReadDir(path string) ([]os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, n, err := m.lookup("open", path, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, pathErr("readdirent", path, syscall.ENOTDIR)
+	}
+	names := m.children(p)
+	sort.Strings(names)
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		c := filepath.Join(p, name)
+		infos = append(infos, m.nodes[c].info(c))
+	}
+	return infos, nil
+}
+
+func (m *MemFS) children(dir string) []string {
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = "/"
+	}
+	names := make([]string, 0)
+	for p := range m.nodes {
+		if p == dir || !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := p[len(prefix):]
+		if !strings.Contains(rest, "/") {
+			names = append(names, rest)
+		}
+	}
+	return names
+}
+
+func (m *MemFS) GetACL(path string) (posixacl.FileACL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("getxattr", path, true)
+	if err != nil {
+		return posixacl.FileACL{}, err
+	}
+	f := posixacl.FileACL{Access: n.access.Sorted()}
+	if n.access == nil {
+		f.Access = posixacl.FromMode(n.mode)
+	}
+	if n.mode.IsDir() {
+		f.Default = n.dflt.Sorted()
+	}
+	return f, nil
+}
+
+// `SetAccessACL()` stores the ACL and updates the permission bits.  A
+// minimal ACL is stored only as permission bits.
+func (m *MemFS) SetAccessACL(path string, acl posixacl.ACL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("setxattr", path, true)
+	if err != nil {
+		return err
+	}
+	if err := acl.Validate(); err != nil {
+		return pathErr("setxattr", path, syscall.EINVAL)
+	}
+	n.mode = n.mode&^os.ModePerm | acl.Mode()
+	if acl.IsMinimal() {
+		n.access = nil
+	} else {
+		n.access = acl.Sorted()
+	}
+	return nil
+}
+
+// `SetDefaultACL()` stores the default ACL of a directory or removes it if
+// `acl` is empty.
+func (m *MemFS) SetDefaultACL(path string, acl posixacl.ACL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("setxattr", path, true)
+	if err != nil {
+		return err
+	}
+	if !n.mode.IsDir() {
+		if len(acl) == 0 {
+			return nil
+		}
+		return pathErr("setxattr", path, syscall.EACCES)
+	}
+	if len(acl) == 0 {
+		n.dflt = nil
+		return nil
+	}
+	if err := acl.Validate(); err != nil {
+		return pathErr("setxattr", path, syscall.EINVAL)
+	}
+	n.dflt = acl.Sorted()
+	return nil
+}
+
+func (n *memNode) info(path string) os.FileInfo {
+	return &memFileInfo{
+		name: filepath.Base(path),
+		mode: n.mode,
+		sys:  &syscall.Stat_t{Uid: uint32(n.uid), Gid: uint32(n.gid)},
+	}
+}
+
+type memFileInfo struct {
+	name string
+	mode os.FileMode
+	sys  *syscall.Stat_t
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return 0 }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return fi.sys }
@@ -0,0 +1,95 @@
+package posixacl
+
+import (
+	"fmt"
+	"os"
+)
+
+// `Get()` reads the access ACL and, for directories, the default ACL of
+// `path`.  Symlinks are followed.
+func Get(path string) (FileACL, error) {
+	var f FileACL
+	fi, err := os.Stat(path)
+	if err != nil {
+		return f, err
+	}
+	if f.Access, err = getAccess(path, fi.Mode()); err != nil {
+		return f, err
+	}
+	if fi.IsDir() {
+		if f.Default, err = GetDefault(path); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// `GetAccess()` reads the access ACL of `path`.  If the file has no ACL
+// xattr, the minimal ACL is derived from the permission bits.
+func GetAccess(path string) (ACL, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return getAccess(path, fi.Mode())
+}
+
+func getAccess(path string, mode os.FileMode) (ACL, error) {
+	a, err := getXattrACL(path, XattrAccess)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return FromMode(mode), nil
+	}
+	return a, nil
+}
+
+// `GetDefault()` reads the default ACL of `path`.  It returns an empty ACL
+// if there is none.
+func GetDefault(path string) (ACL, error) {
+	return getXattrACL(path, XattrDefault)
+}
+
+// `Set()` writes the access ACL and, if `f.Default` is non-empty or `path`
+// is a directory, the default ACL.  An empty default ACL removes the default
+// ACL.  An empty access ACL is left unmodified.
+func Set(path string, f FileACL) error {
+	if len(f.Access) > 0 {
+		if err := SetAccess(path, f.Access); err != nil {
+			return err
+		}
+	}
+	if len(f.Default) > 0 {
+		return SetDefault(path, f.Default)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return SetDefault(path, nil)
+	}
+	return nil
+}
+
+// `SetAccess()` writes the access ACL.  The kernel updates the permission
+// bits accordingly.
+func SetAccess(path string, a ACL) error {
+	return setXattrACL(path, XattrAccess, a)
+}
+
+// `SetDefault()` writes the default ACL or removes it if `a` is empty.
+func SetDefault(path string, a ACL) error {
+	if len(a) == 0 {
+		return removeXattr(path, XattrDefault)
+	}
+	return setXattrACL(path, XattrDefault, a)
+}
+
+func setXattrACL(path, attr string, a ACL) error {
+	if err := a.Validate(); err != nil {
+		return fmt.Errorf("invalid ACL `%s` for `%s`: %v", a, path, err)
+	}
+	return setXattr(path, attr, EncodeXattr(a))
+}
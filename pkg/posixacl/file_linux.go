@@ -0,0 +1,54 @@
+package posixacl
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func setXattr(path, attr string, buf []byte) error {
+	err := syscall.Setxattr(path, attr, buf, 0)
+	if err != nil {
+		return &os.PathError{Op: "setxattr", Path: path, Err: err}
+	}
+	return nil
+}
+
+// `removeXattr()` ignores a missing xattr.
+func removeXattr(path, attr string) error {
+	err := syscall.Removexattr(path, attr)
+	if err != nil && err != syscall.ENODATA {
+		return &os.PathError{Op: "removexattr", Path: path, Err: err}
+	}
+	return nil
+}
+
+// `getXattrACL()` returns nil without error if the xattr is missing.
+func getXattrACL(path, attr string) (ACL, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := syscall.Getxattr(path, attr, buf)
+		switch {
+		case err == syscall.ENODATA:
+			return nil, nil
+		case err == syscall.ERANGE:
+			n, err = syscall.Getxattr(path, attr, nil)
+			if err != nil {
+				return nil, &os.PathError{
+					Op: "getxattr", Path: path, Err: err,
+				}
+			}
+			buf = make([]byte, n)
+			continue
+		case err != nil:
+			return nil, &os.PathError{
+				Op: "getxattr", Path: path, Err: err,
+			}
+		}
+		a, err := DecodeXattr(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("`%s`: %v", path, err)
+		}
+		return a, nil
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package posixacl
+
+import (
+	"os"
+	"syscall"
+)
+
+// ACL xattrs are only supported on Linux.  The stubs return `ENOTSUP` like
+// a Linux filesystem without ACL support.
+
+func setXattr(path, attr string, buf []byte) error {
+	return &os.PathError{Op: "setxattr", Path: path, Err: syscall.ENOTSUP}
+}
+
+func removeXattr(path, attr string) error {
+	return &os.PathError{Op: "removexattr", Path: path, Err: syscall.ENOTSUP}
+}
+
+func getXattrACL(path, attr string) (ACL, error) {
+	return nil, &os.PathError{Op: "getxattr", Path: path, Err: syscall.ENOTSUP}
+}
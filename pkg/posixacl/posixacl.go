@@ -0,0 +1,562 @@
+// Package `posixacl` provides a typed model of POSIX ACLs and reads and writes
+// them directly as the extended attributes `system.posix_acl_access` and
+// `system.posix_acl_default`, without running `getfacl` or `setfacl`.
+//
+// The text format is the short `getfacl -n` format with numeric ids, like
+// `user::rwx`, `group:1001:r-x`, `mask::r-x`, or `other::---`.  Default ACL
+// entries are prefixed with `default:`.
+//
+// `FileACL.Modify()` and `FileACL.Remove()` implement the semantics of
+// `setfacl --modify` and `setfacl --remove` without mask recalculation, that
+// is `setfacl -n`.
+package posixacl
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// `Tag` is the ACL entry type.  The values are the Linux xattr values, which
+// also define the canonical entry order.
+type Tag uint16
+
+const (
+	TagUserObj  Tag = 0x01
+	TagUser     Tag = 0x02
+	TagGroupObj Tag = 0x04
+	TagGroup    Tag = 0x08
+	TagMask     Tag = 0x10
+	TagOther    Tag = 0x20
+)
+
+func (t Tag) String() string {
+	switch t {
+	case TagUserObj, TagUser:
+		return "user"
+	case TagGroupObj, TagGroup:
+		return "group"
+	case TagMask:
+		return "mask"
+	case TagOther:
+		return "other"
+	default:
+		return fmt.Sprintf("tag(%d)", uint16(t))
+	}
+}
+
+// `IsNamed()` is true for entries that have an id qualifier.
+func (t Tag) IsNamed() bool {
+	return t == TagUser || t == TagGroup
+}
+
+// `Perm` contains the permission bits `r=4`, `w=2`, and `x=1`.
+type Perm uint16
+
+const (
+	PermExecute Perm = 1 << iota
+	PermWrite
+	PermRead
+)
+
+func (p Perm) String() string {
+	b := []byte("---")
+	if p&PermRead != 0 {
+		b[0] = 'r'
+	}
+	if p&PermWrite != 0 {
+		b[1] = 'w'
+	}
+	if p&PermExecute != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
+}
+
+// `ParsePerm()` parses the `rwx` format, like `r-x`.
+func ParsePerm(s string) (Perm, error) {
+	if len(s) != 3 {
+		return 0, fmt.Errorf("malformed permissions `%s`", s)
+	}
+	var p Perm
+	for i, bit := range []struct {
+		c byte
+		p Perm
+	}{{'r', PermRead}, {'w', PermWrite}, {'x', PermExecute}} {
+		switch s[i] {
+		case bit.c:
+			p |= bit.p
+		case '-':
+		default:
+			return 0, fmt.Errorf("malformed permissions `%s`", s)
+		}
+	}
+	return p, nil
+}
+
+// `Entry` is an ACL entry.  `ID` is the uid or gid for `TagUser` and
+// `TagGroup` entries and ignored for other tags.
+type Entry struct {
+	Tag  Tag
+	ID   int
+	Perm Perm
+}
+
+// `String()` returns the text format, like `group:1001:r-x`.
+func (e Entry) String() string {
+	return e.Key() + ":" + e.Perm.String()
+}
+
+// `Key()` returns the text format without permissions, like `group:1001`
+// or `mask:`.  Entries with equal keys replace each other.
+func (e Entry) Key() string {
+	if e.Tag.IsNamed() {
+		return fmt.Sprintf("%s:%d", e.Tag, e.ID)
+	}
+	return e.Tag.String() + ":"
+}
+
+func (e Entry) sameKey(o Entry) bool {
+	if e.Tag != o.Tag {
+		return false
+	}
+	return !e.Tag.IsNamed() || e.ID == o.ID
+}
+
+// `ParseEntry()` parses an entry in text format with a numeric id, like
+// `group:1001:r-x`.  The permissions may be omitted, like `group:1001`, to
+// specify entries for `Remove()`.
+func ParseEntry(s string) (Entry, error) {
+	var e Entry
+	fields := strings.Split(s, ":")
+	if len(fields) == 2 {
+		fields = append(fields, "")
+	}
+	if len(fields) != 3 {
+		return e, fmt.Errorf("malformed ACL entry `%s`", s)
+	}
+	tag, qual, perm := fields[0], fields[1], fields[2]
+
+	switch {
+	case (tag == "user" || tag == "u") && qual == "":
+		e.Tag = TagUserObj
+	case tag == "user" || tag == "u":
+		e.Tag = TagUser
+	case (tag == "group" || tag == "g") && qual == "":
+		e.Tag = TagGroupObj
+	case tag == "group" || tag == "g":
+		e.Tag = TagGroup
+	case (tag == "mask" || tag == "m") && qual == "":
+		e.Tag = TagMask
+	case (tag == "other" || tag == "o") && qual == "":
+		e.Tag = TagOther
+	default:
+		return e, fmt.Errorf("malformed ACL entry `%s`", s)
+	}
+
+	if e.Tag.IsNamed() {
+		id, err := strconv.Atoi(qual)
+		if err != nil || id < 0 {
+			return e, fmt.Errorf("non-numeric id in ACL entry `%s`", s)
+		}
+		e.ID = id
+	}
+
+	if perm != "" {
+		p, err := ParsePerm(perm)
+		if err != nil {
+			return e, fmt.Errorf("ACL entry `%s`: %v", s, err)
+		}
+		e.Perm = p
+	}
+
+	return e, nil
+}
+
+// `ACL` is a list of entries.  Most functions return ACLs in canonical order.
+type ACL []Entry
+
+// `ParseACL()` parses a list of entries in text format.
+func ParseACL(entries []string) (ACL, error) {
+	acl := make(ACL, 0, len(entries))
+	for _, s := range entries {
+		e, err := ParseEntry(s)
+		if err != nil {
+			return nil, err
+		}
+		acl = append(acl, e)
+	}
+	return acl.Sorted(), nil
+}
+
+// `FromMode()` returns the minimal ACL that is equivalent to the permission
+// bits of `mode`.
+func FromMode(mode os.FileMode) ACL {
+	return ACL{
+		{Tag: TagUserObj, Perm: Perm(mode>>6) & 7},
+		{Tag: TagGroupObj, Perm: Perm(mode>>3) & 7},
+		{Tag: TagOther, Perm: Perm(mode) & 7},
+	}
+}
+
+// `Sorted()` returns a copy in canonical order: user obj, users by uid, group
+// obj, groups by gid, mask, other.
+func (a ACL) Sorted() ACL {
+	s := make(ACL, len(a))
+	copy(s, a)
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].Tag != s[j].Tag {
+			return s[i].Tag < s[j].Tag
+		}
+		if s[i].Tag.IsNamed() {
+			return s[i].ID < s[j].ID
+		}
+		return false
+	})
+	return s
+}
+
+// `Find()` returns the entry with the same key as `k`.
+func (a ACL) Find(k Entry) (Entry, bool) {
+	for _, e := range a {
+		if e.sameKey(k) {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// `Has()` is true if `a` contains an entry that is equal to `e`, including
+// permissions.
+func (a ACL) Has(e Entry) bool {
+	have, ok := a.Find(e)
+	return ok && have.Perm == e.Perm
+}
+
+// `HasKey()` is true if `a` contains an entry with the same key as `k`,
+// ignoring permissions.
+func (a ACL) HasKey(k Entry) bool {
+	_, ok := a.Find(k)
+	return ok
+}
+
+// `Set()` returns a copy with the `entries` added or replacing entries with
+// the same key.
+func (a ACL) Set(entries ...Entry) ACL {
+	res := make(ACL, 0, len(a)+len(entries))
+	res = append(res, a...)
+	for _, e := range entries {
+		replaced := false
+		for i := range res {
+			if res[i].sameKey(e) {
+				res[i] = e
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			res = append(res, e)
+		}
+	}
+	return res.Sorted()
+}
+
+// `Delete()` returns a copy without entries that have the same key as one of
+// the `keys`.
+func (a ACL) Delete(keys ...Entry) ACL {
+	res := make(ACL, 0, len(a))
+	for _, e := range a {
+		del := false
+		for _, k := range keys {
+			if e.sameKey(k) {
+				del = true
+				break
+			}
+		}
+		if !del {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// `NamedGroups()` returns the named group entries.
+func (a ACL) NamedGroups() ACL {
+	res := make(ACL, 0)
+	for _, e := range a {
+		if e.Tag == TagGroup {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// `IsMinimal()` is true if the ACL contains only the user obj, group obj, and
+// other entries, which are equivalent to the traditional permission bits.
+func (a ACL) IsMinimal() bool {
+	for _, e := range a {
+		switch e.Tag {
+		case TagUserObj, TagGroupObj, TagOther:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// `WithMask()` returns a copy with a mask entry if the ACL has named entries
+// but no mask.  The mask is the union of the group class permissions.  An
+// existing mask is left unmodified, as with `setfacl -n`.
+func (a ACL) WithMask() ACL {
+	needMask := false
+	var mask Perm
+	for _, e := range a {
+		switch e.Tag {
+		case TagMask:
+			return a.Sorted()
+		case TagUser, TagGroup:
+			needMask = true
+			mask |= e.Perm
+		case TagGroupObj:
+			mask |= e.Perm
+		}
+	}
+	if !needMask {
+		return a.Sorted()
+	}
+	return a.Set(Entry{Tag: TagMask, Perm: mask})
+}
+
+// `Mode()` returns the permission bits that correspond to the ACL: user obj,
+// mask or group obj if there is no mask, and other.
+func (a ACL) Mode() os.FileMode {
+	var u, g, o Perm
+	hasMask := false
+	for _, e := range a {
+		switch e.Tag {
+		case TagUserObj:
+			u = e.Perm
+		case TagGroupObj:
+			if !hasMask {
+				g = e.Perm
+			}
+		case TagMask:
+			hasMask = true
+			g = e.Perm
+		case TagOther:
+			o = e.Perm
+		}
+	}
+	return os.FileMode(u)<<6 | os.FileMode(g)<<3 | os.FileMode(o)
+}
+
+// `Validate()` checks that the ACL is valid as an access or default ACL: it
+// contains exactly one user obj, group obj, and other entry, a mask if it
+// contains named entries, and no duplicate keys.
+func (a ACL) Validate() error {
+	seen := make(map[string]bool)
+	named := false
+	for _, e := range a {
+		k := e.Key()
+		if seen[k] {
+			return fmt.Errorf("duplicate entry `%s`", k)
+		}
+		seen[k] = true
+		if e.Tag.IsNamed() {
+			named = true
+		}
+	}
+	for _, k := range []string{"user:", "group:", "other:"} {
+		if !seen[k] {
+			return fmt.Errorf("missing entry `%s`", k)
+		}
+	}
+	if named && !seen["mask:"] {
+		return fmt.Errorf("missing entry `mask:`")
+	}
+	return nil
+}
+
+// `Equal()` compares ACLs independent of entry order.
+func (a ACL) Equal(b ACL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sa := a.Sorted()
+	sb := b.Sorted()
+	for i := range sa {
+		if !sa[i].sameKey(sb[i]) || sa[i].Perm != sb[i].Perm {
+			return false
+		}
+	}
+	return true
+}
+
+// `Strings()` returns the entries in text format.
+func (a ACL) Strings() []string {
+	res := make([]string, 0, len(a))
+	for _, e := range a {
+		res = append(res, e.String())
+	}
+	return res
+}
+
+// `String()` returns the entries in text format separated by comma.
+func (a ACL) String() string {
+	return strings.Join(a.Strings(), ",")
+}
+
+// `FileACL` combines the access ACL and the default ACL of a file.  Regular
+// files have no default ACL.  An empty `Default` indicates that there is no
+// default ACL.
+type FileACL struct {
+	Access  ACL
+	Default ACL
+}
+
+const defaultPrefix = "default:"
+
+// `ParseFileACL()` parses a list of entries in text format, where default ACL
+// entries are prefixed with `default:`, like `default:group:1001:r-x`.
+func ParseFileACL(entries []string) (FileACL, error) {
+	var acc, def []string
+	for _, s := range entries {
+		if strings.HasPrefix(s, defaultPrefix) {
+			def = append(def, s[len(defaultPrefix):])
+		} else if strings.HasPrefix(s, "d:") {
+			def = append(def, s[len("d:"):])
+		} else {
+			acc = append(acc, s)
+		}
+	}
+
+	var f FileACL
+	var err error
+	if f.Access, err = ParseACL(acc); err != nil {
+		return f, err
+	}
+	if f.Default, err = ParseACL(def); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// `MustParseFileACL()` is like `ParseFileACL()` but panics on error.  It is
+// intended for entries that are constructed by the program.
+func MustParseFileACL(entries ...string) FileACL {
+	f, err := ParseFileACL(entries)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// `Strings()` returns the access entries followed by the default entries with
+// prefix `default:`.
+func (f FileACL) Strings() []string {
+	res := f.Access.Sorted().Strings()
+	for _, s := range f.Default.Sorted().Strings() {
+		res = append(res, defaultPrefix+s)
+	}
+	return res
+}
+
+// `Keys()` is like `Strings()` but without permissions, like `group:1001` or
+// `default:group:1001`, as used with `setfacl -x`.
+func (f FileACL) Keys() []string {
+	res := make([]string, 0, len(f.Access)+len(f.Default))
+	for _, e := range f.Access.Sorted() {
+		res = append(res, e.Key())
+	}
+	for _, e := range f.Default.Sorted() {
+		res = append(res, defaultPrefix+e.Key())
+	}
+	return res
+}
+
+func (f FileACL) String() string {
+	return strings.Join(f.Strings(), ",")
+}
+
+func (f FileACL) IsEmpty() bool {
+	return len(f.Access) == 0 && len(f.Default) == 0
+}
+
+// `Modify()` returns a copy with the entries of `m` added or replaced, like
+// `setfacl -n --modify`.  If there is no default ACL yet and `m` contains
+// default entries, the default ACL is initialized from the access ACL obj
+// entries before the modification, as `setfacl` does.  A mask is added if
+// necessary.
+func (f FileACL) Modify(m FileACL) FileACL {
+	res := FileACL{
+		Access:  f.Access.Set(m.Access...),
+		Default: f.Default.Sorted(),
+	}
+	if len(m.Default) > 0 {
+		if len(res.Default) == 0 {
+			for _, e := range f.Access {
+				switch e.Tag {
+				case TagUserObj, TagGroupObj, TagOther:
+					res.Default = append(res.Default, e)
+				}
+			}
+		}
+		res.Default = res.Default.Set(m.Default...)
+	}
+	if len(m.Access) > 0 {
+		res.Access = res.Access.WithMask()
+	}
+	if len(m.Default) > 0 {
+		res.Default = res.Default.WithMask()
+	}
+	return res
+}
+
+// `Remove()` returns a copy without the entries whose keys are in `r`, like
+// `setfacl --remove`.  Permissions in `r` are ignored.
+func (f FileACL) Remove(r FileACL) FileACL {
+	return FileACL{
+		Access:  f.Access.Delete(r.Access...).Sorted(),
+		Default: f.Default.Delete(r.Default...).Sorted(),
+	}
+}
+
+// `Equal()` compares access and default ACLs independent of entry order.
+func (f FileACL) Equal(g FileACL) bool {
+	return f.Access.Equal(g.Access) && f.Default.Equal(g.Default)
+}
+
+// `Missing()` returns the entries of `want` that are not present in `f` with
+// equal permissions.
+func (f FileACL) Missing(want FileACL) FileACL {
+	var res FileACL
+	for _, e := range want.Access {
+		if !f.Access.Has(e) {
+			res.Access = append(res.Access, e)
+		}
+	}
+	for _, e := range want.Default {
+		if !f.Default.Has(e) {
+			res.Default = append(res.Default, e)
+		}
+	}
+	return res
+}
+
+// `Present()` returns the entries of `f` whose keys are in `keys`.
+func (f FileACL) Present(keys FileACL) FileACL {
+	var res FileACL
+	for _, k := range keys.Access {
+		if e, ok := f.Access.Find(k); ok {
+			res.Access = append(res.Access, e)
+		}
+	}
+	for _, k := range keys.Default {
+		if e, ok := f.Default.Find(k); ok {
+			res.Default = append(res.Default, e)
+		}
+	}
+	return res
+}
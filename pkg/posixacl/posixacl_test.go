@@ -0,0 +1,186 @@
+package posixacl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestParseEntry(t *testing.T) {
+	cases := []struct {
+		text string
+		e    Entry
+	}{
+		{"user::rwx", Entry{Tag: TagUserObj, Perm: 7}},
+		{"user:1000:r--", Entry{Tag: TagUser, ID: 1000, Perm: 4}},
+		{"group::---", Entry{Tag: TagGroupObj}},
+		{"group:1001:r-x", Entry{Tag: TagGroup, ID: 1001, Perm: 5}},
+		{"mask::rw-", Entry{Tag: TagMask, Perm: 6}},
+		{"other::--x", Entry{Tag: TagOther, Perm: 1}},
+		{"group:1001", Entry{Tag: TagGroup, ID: 1001}},
+	}
+	for _, c := range cases {
+		e, err := ParseEntry(c.text)
+		if err != nil {
+			t.Fatalf("ParseEntry(%q): %v", c.text, err)
+		}
+		if e != c.e {
+			t.Errorf("ParseEntry(%q): got %+v, want %+v", c.text, e, c.e)
+		}
+	}
+
+	for _, text := range []string{
+		"", "user", "group:org_ag:r-x", "mask:1:rwx", "other::rwxr",
+		"other::xwr", "foo::rwx", "group:-1:r-x",
+	} {
+		if _, err := ParseEntry(text); err == nil {
+			t.Errorf("ParseEntry(%q): expected error", text)
+		}
+	}
+}
+
+func TestFileACLModifyRemove(t *testing.T) {
+	f := FileACL{Access: FromMode(0750)}
+	got := f.Modify(MustParseFileACL(
+		"group:1001:r-x",
+		"default:group:1001:r-x",
+	))
+	want := MustParseFileACL(
+		"user::rwx",
+		"group::r-x",
+		"group:1001:r-x",
+		"mask::r-x",
+		"other::---",
+		"default:user::rwx",
+		"default:group::r-x",
+		"default:group:1001:r-x",
+		"default:mask::r-x",
+		"default:other::---",
+	)
+	if !got.Equal(want) {
+		t.Fatalf("Modify(): got `%s`, want `%s`", got, want)
+	}
+
+	// An existing mask is not recalculated.
+	got = got.Modify(MustParseFileACL("group:1002:rwx"))
+	if e, _ := got.Access.Find(Entry{Tag: TagMask}); e.Perm != 5 {
+		t.Errorf("Modify() recalculated mask: `%s`", got)
+	}
+
+	got = got.Remove(MustParseFileACL(
+		"group:1001", "group:1002", "default:group:1001",
+	))
+	want = MustParseFileACL(
+		"user::rwx",
+		"group::r-x",
+		"mask::r-x",
+		"other::---",
+		"default:user::rwx",
+		"default:group::r-x",
+		"default:mask::r-x",
+		"default:other::---",
+	)
+	if !got.Equal(want) {
+		t.Fatalf("Remove(): got `%s`, want `%s`", got, want)
+	}
+}
+
+func TestXattrRoundTrip(t *testing.T) {
+	a := MustParseFileACL(
+		"other::---",
+		"group:1002:rwx",
+		"user::rwx",
+		"mask::rwx",
+		"group::---",
+		"group:1001:r-x",
+		"user:1000:rw-",
+	).Access
+	buf := EncodeXattr(a)
+	if len(buf) != 4+7*8 {
+		t.Fatalf("wrong xattr length %d", len(buf))
+	}
+	got, err := DecodeXattr(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, a.Sorted()) {
+		t.Errorf("got `%s`, want `%s`", got, a.Sorted())
+	}
+	if got.String() != "user::rwx,user:1000:rw-,group::---,"+
+		"group:1001:r-x,group:1002:rwx,mask::rwx,other::---" {
+		t.Errorf("wrong canonical order `%s`", got)
+	}
+
+	if _, err := DecodeXattr([]byte{1, 0, 0, 0}); err == nil {
+		t.Error("expected version error")
+	}
+	if _, err := DecodeXattr(buf[:10]); err == nil {
+		t.Error("expected length error")
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "posixacl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a dir with 'quotes'")
+	if err := os.Mkdir(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Get(path)
+	if err != nil {
+		if isNotSup(err) {
+			t.Skip("platform does not support ACLs")
+		}
+		t.Fatal(err)
+	}
+	if !f.Equal(FileACL{Access: FromMode(0700)}) {
+		t.Fatalf("unexpected initial ACL `%s`", f)
+	}
+
+	want := f.Modify(MustParseFileACL(
+		"group:12345:r-x", "default:group:12345:rwx",
+	))
+	if err := Set(path, want); err != nil {
+		if isNotSup(err) {
+			t.Skip("filesystem does not support ACLs")
+		}
+		t.Fatal(err)
+	}
+	got, err := Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("got `%s`, want `%s`", got, want)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != want.Access.Mode() {
+		t.Errorf("wrong mode %v", fi.Mode())
+	}
+
+	if err := Set(path, FileACL{Access: FromMode(0750)}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(FileACL{Access: FromMode(0750)}) {
+		t.Errorf("failed to reset to minimal ACL: `%s`", got)
+	}
+}
+
+func isNotSup(err error) bool {
+	pe, ok := err.(*os.PathError)
+	return ok && pe.Err == syscall.ENOTSUP
+}
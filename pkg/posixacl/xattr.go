@@ -0,0 +1,73 @@
+package posixacl
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The xattr value is a little-endian header `{u32 version}` followed by
+// entries `{u16 tag, u16 perm, u32 id}`; see Linux
+// `include/uapi/linux/posix_acl_xattr.h`.
+const (
+	xattrVersion   = 2
+	xattrHeaderLen = 4
+	xattrEntryLen  = 8
+	undefinedID    = 0xffffffff
+)
+
+const (
+	XattrAccess  = "system.posix_acl_access"
+	XattrDefault = "system.posix_acl_default"
+)
+
+// `EncodeXattr()` returns the xattr value for the ACL in canonical order.
+func EncodeXattr(a ACL) []byte {
+	a = a.Sorted()
+	buf := make([]byte, xattrHeaderLen+xattrEntryLen*len(a))
+	binary.LittleEndian.PutUint32(buf, xattrVersion)
+	for i, e := range a {
+		b := buf[xattrHeaderLen+xattrEntryLen*i:]
+		binary.LittleEndian.PutUint16(b[0:], uint16(e.Tag))
+		binary.LittleEndian.PutUint16(b[2:], uint16(e.Perm))
+		id := uint32(undefinedID)
+		if e.Tag.IsNamed() {
+			id = uint32(e.ID)
+		}
+		binary.LittleEndian.PutUint32(b[4:], id)
+	}
+	return buf
+}
+
+// `DecodeXattr()` parses an xattr value.
+func DecodeXattr(buf []byte) (ACL, error) {
+	if len(buf) < xattrHeaderLen {
+		return nil, fmt.Errorf("ACL xattr too short")
+	}
+	if v := binary.LittleEndian.Uint32(buf); v != xattrVersion {
+		return nil, fmt.Errorf("unsupported ACL xattr version %d", v)
+	}
+	buf = buf[xattrHeaderLen:]
+	if len(buf)%xattrEntryLen != 0 {
+		return nil, fmt.Errorf("malformed ACL xattr length")
+	}
+
+	a := make(ACL, 0, len(buf)/xattrEntryLen)
+	for ; len(buf) > 0; buf = buf[xattrEntryLen:] {
+		e := Entry{
+			Tag:  Tag(binary.LittleEndian.Uint16(buf[0:])),
+			Perm: Perm(binary.LittleEndian.Uint16(buf[2:])),
+		}
+		switch e.Tag {
+		case TagUserObj, TagGroupObj, TagMask, TagOther:
+		case TagUser, TagGroup:
+			e.ID = int(binary.LittleEndian.Uint32(buf[4:]))
+		default:
+			return nil, fmt.Errorf("unknown ACL xattr tag %d", e.Tag)
+		}
+		if e.Perm&^(PermRead|PermWrite|PermExecute) != 0 {
+			return nil, fmt.Errorf("invalid ACL xattr permissions")
+		}
+		a = append(a, e)
+	}
+	return a, nil
+}